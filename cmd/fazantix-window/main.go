@@ -49,8 +49,6 @@ func main() {
 	stdinSource.Start()
 	shaderData := &shaders.ShaderData{
 		Sources:        sources,
-		NumSources:     1,
-		NumLayers:      1,
 		FallbackColour: utils.ColourParse("#ff0000"),
 	}
 	program, err := shaders.BuildGLProgram(shaderData)
@@ -58,7 +56,7 @@ func main() {
 		log.Fatalf("could not init GL program: %s", err)
 	}
 	glvars := rendering.NewGLVars(
-		program, 1,
+		program,
 		sources, []int32{-1},
 		utils.ColourParse("#ff0000"),
 	)
@@ -76,12 +74,13 @@ func main() {
 	stage.Layers[0].Opacity = 1
 	glvars.Start()
 	rendering.SetupTextures(stdinSource.Frames())
+	uploader := rendering.NewUploader(sources, []int32{-1})
 
 	var deltaTimer utils.DeltaTimer
 	for {
 		glvars.StartFrame()
 		dt := deltaTimer.Next()
-		rendering.SendFramesToGPU(sources, dt)
+		uploader.SendFramesToGPU(dt, []*layer.Stage{stage})
 		glvars.DrawStage(stage)
 		windowSink.Window.SwapBuffers()
 		glfw.PollEvents()
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/client"
+)
+
+var urlFlag = flag.String("url", defaultURL(), "Base URL of the fazantix API, also read from $FAZANTIX_URL")
+var tokenFlag = flag.String("token", os.Getenv("FAZANTIX_TOKEN"), "API token, also read from $FAZANTIX_TOKEN. Basic auth credentials can be put in the URL")
+var jsonFlag = flag.Bool("json", false, "Print results as JSON")
+var timeoutFlag = flag.Duration("timeout", 10*time.Second, "Timeout for a single command, not used by events")
+
+type command struct {
+	args string
+	help string
+	run  func(ctx context.Context, c *client.Client, args []string) error
+}
+
+var commands = map[string]command{
+	"stages":     {"", "List stages with their active and cued scene", cmdStages},
+	"scenes":     {"", "List scenes", cmdScenes},
+	"sources":    {"", "List sources with their health", cmdSources},
+	"scene":      {"STAGE SCENE", "Transition a stage to a scene", cmdScene},
+	"cue":        {"STAGE SCENE", "Cue a scene on a stage", cmdCue},
+	"take":       {"STAGE", "Transition a stage to its cued scene", cmdTake},
+	"transition": {"STAGE MS", "Set the transition time of a stage", cmdTransition},
+	"macros":     {"", "List macros with their progress", cmdMacros},
+	"macro":      {"NAME start|stop|pause|resume", "Control a macro", cmdMacro},
+	"lock":       {"[-force] STAGE", "Refuse scene changes on a stage from anyone else", cmdLock},
+	"unlock":     {"[-force] STAGE", "Release the lock on a stage", cmdUnlock},
+	"still":      {"[-sink] [-format jpeg|png] [-o FILE] NAME", "Fetch the current frame of a source or sink", cmdStill},
+	"events":     {"[TOPIC...]", "Print websocket events until interrupted", cmdEvents},
+	"stats":      {"", "Print runtime statistics", cmdStats},
+}
+
+// usageError makes the program exit with status 2 instead of 1
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func defaultURL() string {
+	if u := os.Getenv("FAZANTIX_URL"); u != "" {
+		return u
+	}
+	return "http://localhost:8000"
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] COMMAND [ARGS]\n\nCommands:\n", os.Args[0])
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		cmd := commands[name]
+		fmt.Fprintf(w, "  %s %s\t%s\n", name, cmd.args, cmd.help)
+	}
+	_ = w.Flush()
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "fazantixctl: unknown command %s\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	c, err := client.New(*urlFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fazantixctl: %s\n", err)
+		os.Exit(2)
+	}
+	c.Token = *tokenFlag
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if flag.Arg(0) != "events" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeoutFlag)
+		defer cancel()
+	}
+
+	err = cmd.run(ctx, c, flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fazantixctl: %s: %s\n", flag.Arg(0), err)
+		var uerr usageError
+		if errors.As(err, &uerr) {
+			fmt.Fprintf(os.Stderr, "usage: %s %s\n", flag.Arg(0), cmd.args)
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func expectArgs(args []string, n int) error {
+	if len(args) != n {
+		return usageError{fmt.Sprintf("expected %d arguments, got %d", n, len(args))}
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// printResult prints v as JSON or, in text mode, runs text
+func printResult(v interface{}, text func(w *tabwriter.Writer)) error {
+	if *jsonFlag {
+		return printJSON(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
+
+func printOk() error {
+	if *jsonFlag {
+		return printJSON("ok")
+	}
+	return nil
+}
+
+func cmdStages(ctx context.Context, c *client.Client, args []string) error {
+	if err := expectArgs(args, 0); err != nil {
+		return err
+	}
+	stages, err := c.Stages(ctx)
+	if err != nil {
+		return err
+	}
+	return printResult(stages, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "STAGE\tACTIVE\tCUED\tTRANSITION\tLOCKED BY\n")
+		for _, name := range slices.Sorted(maps.Keys(stages)) {
+			s := stages[name]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%dms\t%s\n", name, s.ActiveScene, s.CuedScene, s.TransitionMs, s.LockedBy)
+		}
+	})
+}
+
+func cmdScenes(ctx context.Context, c *client.Client, args []string) error {
+	if err := expectArgs(args, 0); err != nil {
+		return err
+	}
+	cfg, err := c.Config(ctx)
+	if err != nil {
+		return err
+	}
+	scenes := cfg.Scenes
+	slices.SortFunc(scenes, func(a, b client.SceneInfo) int {
+		return cmp.Compare(a.Code, b.Code)
+	})
+	return printResult(scenes, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "SCENE\tTAG\tLABEL\n")
+		for _, s := range scenes {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Code, s.Tag, s.Label)
+		}
+	})
+}
+
+func cmdSources(ctx context.Context, c *client.Client, args []string) error {
+	if err := expectArgs(args, 0); err != nil {
+		return err
+	}
+	sources, err := c.Sources(ctx)
+	if err != nil {
+		return err
+	}
+	return printResult(sources, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "SOURCE\tREADY\tFRAME AGE\n")
+		for _, s := range sources {
+			fmt.Fprintf(w, "%s\t%t\t%dms\n", s.Name, s.Ready, s.FrameAgeMs)
+		}
+	})
+}
+
+func cmdScene(ctx context.Context, c *client.Client, args []string) error {
+	if err := expectArgs(args, 2); err != nil {
+		return err
+	}
+	if err := c.SetScene(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+	return printOk()
+}
+
+func cmdCue(ctx context.Context, c *client.Client, args []string) error {
+	if err := expectArgs(args, 2); err != nil {
+		return err
+	}
+	if err := c.Cue(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+	return printOk()
+}
+
+func cmdTake(ctx context.Context, c *client.Client, args []string) error {
+	if err := expectArgs(args, 1); err != nil {
+		return err
+	}
+	if err := c.Take(ctx, args[0]); err != nil {
+		return err
+	}
+	return printOk()
+}
+
+func cmdTransition(ctx context.Context, c *client.Client, args []string) error {
+	if err := expectArgs(args, 2); err != nil {
+		return err
+	}
+	ms, err := strconv.Atoi(args[1])
+	if err != nil || ms < 0 {
+		return usageError{fmt.Sprintf("invalid transition time %s", args[1])}
+	}
+	if err := c.SetTransition(ctx, args[0], ms); err != nil {
+		return err
+	}
+	return printOk()
+}
+
+func cmdMacros(ctx context.Context, c *client.Client, args []string) error {
+	if err := expectArgs(args, 0); err != nil {
+		return err
+	}
+	macros, err := c.Macros(ctx)
+	if err != nil {
+		return err
+	}
+	return printResult(macros, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "MACRO\tSTATE\tSTEP\tLABEL\n")
+		for _, m := range macros {
+			fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\n", m.Name, m.State, m.Step+1, m.Steps, m.Label)
+		}
+	})
+}
+
+func cmdMacro(ctx context.Context, c *client.Client, args []string) error {
+	if err := expectArgs(args, 2); err != nil {
+		return err
+	}
+	switch args[1] {
+	case "start", "stop", "pause", "resume":
+	default:
+		return usageError{fmt.Sprintf("unknown macro action %s", args[1])}
+	}
+	if err := c.Macro(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+	return printOk()
+}
+
+func cmdLock(ctx context.Context, c *client.Client, args []string) error {
+	return lockCommand(ctx, "lock", args, c.Lock)
+}
+
+func cmdUnlock(ctx context.Context, c *client.Client, args []string) error {
+	return lockCommand(ctx, "unlock", args, c.Unlock)
+}
+
+func lockCommand(ctx context.Context, name string, args []string, run func(ctx context.Context, stage string, force bool) error) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	force := flags.Bool("force", false, "Override the lock of someone else")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err.Error()}
+	}
+	if err := expectArgs(flags.Args(), 1); err != nil {
+		return err
+	}
+	if err := run(ctx, flags.Arg(0), *force); err != nil {
+		return err
+	}
+	return printOk()
+}
+
+func cmdStill(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("still", flag.ContinueOnError)
+	sink := flags.Bool("sink", false, "Fetch from a sink instead of a source")
+	format := flags.String("format", "jpeg", "Image format, jpeg or png")
+	output := flags.String("o", "-", "File to write the image to")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err.Error()}
+	}
+	if err := expectArgs(flags.Args(), 1); err != nil {
+		return err
+	}
+	if *format != "jpeg" && *format != "png" {
+		return usageError{fmt.Sprintf("unsupported format %s", *format)}
+	}
+
+	data, err := c.Still(ctx, flags.Arg(0), *sink, *format)
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*output, data, 0644)
+}
+
+func cmdEvents(ctx context.Context, c *client.Client, args []string) error {
+	err := c.Tail(ctx, args, func(packet json.RawMessage) error {
+		if *jsonFlag {
+			_, err := fmt.Printf("%s\n", packet)
+			return err
+		}
+		var event struct{ Event string }
+		_ = json.Unmarshal(packet, &event)
+		if event.Event == "" {
+			event.Event = "stats"
+		}
+		_, err := fmt.Printf("%s %s %s\n", time.Now().Format(time.TimeOnly), event.Event, packet)
+		return err
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func cmdStats(ctx context.Context, c *client.Client, args []string) error {
+	if err := expectArgs(args, 0); err != nil {
+		return err
+	}
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	return printResult(stats, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "uptime\t%.0fs\n", stats.Uptime)
+		fmt.Fprintf(w, "fps\t%d\n", stats.FPS)
+		fmt.Fprintf(w, "websocket clients\t%d\n", stats.WsClients)
+		fmt.Fprintf(w, "texture upload\t%d bytes\n", stats.TextureUpload)
+		fmt.Fprintf(w, "texture upload avg\t%.3f GiB/s\n", stats.TextureUploadAvgGb)
+		for _, name := range slices.Sorted(maps.Keys(stats.UploadMs)) {
+			fmt.Fprintf(w, "upload %s\t%.2f ms\n", name, stats.UploadMs[name])
+		}
+	})
+}
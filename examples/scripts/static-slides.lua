@@ -0,0 +1,38 @@
+-- Show the camera on the stream while the slides have not changed for five
+-- minutes, and go back to the slides as soon as they do.
+
+local stage = "stream"
+local static_after_ms = 5 * 60 * 1000
+
+local last_fingerprint = nil
+local changed_at = fazantix.now_ms()
+local on_camera = false
+
+fazantix.every(1000, function()
+	local fingerprint = fazantix.source_fingerprint("slides")
+	if fingerprint ~= last_fingerprint then
+		last_fingerprint = fingerprint
+		changed_at = fazantix.now_ms()
+		if on_camera then
+			on_camera = false
+			local ok, err = fazantix.set_scene(stage, "full-slides")
+			if not ok then
+				fazantix.log("could not go back to the slides:", err)
+			end
+		end
+	elseif not on_camera and fazantix.now_ms() - changed_at > static_after_ms then
+		on_camera = true
+		local ok, err = fazantix.set_scene(stage, "full-cam")
+		if not ok then
+			fazantix.log("could not switch to the camera:", err)
+		end
+	end
+end)
+
+-- an operator taking over ends the automatic switching until the slides
+-- change again
+fazantix.on("set-scene", function(e)
+	if e.Stage == stage and e.Scene ~= "full-cam" then
+		on_camera = false
+	end
+end)
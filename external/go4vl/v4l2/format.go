@@ -27,6 +27,7 @@ var (
 	PixelFmtMPEG  FourCCType = C.V4L2_PIX_FMT_MPEG
 	PixelFmtH264  FourCCType = C.V4L2_PIX_FMT_H264
 	PixelFmtMPEG4 FourCCType = C.V4L2_PIX_FMT_MPEG4
+	PixelFmtP010  FourCCType = C.V4L2_PIX_FMT_P010
 )
 
 // PixelFormats provides a map of FourCCType encoding description
@@ -39,6 +40,7 @@ var PixelFormats = map[FourCCType]string{
 	PixelFmtMPEG:  "MPEG-1/2/4",
 	PixelFmtH264:  "H.264",
 	PixelFmtMPEG4: "MPEG-4 Part 2 ES",
+	PixelFmtP010:  "Y/CbCr 4:2:0 10-bit",
 }
 
 // IsPixYUVEncoded returns true if the pixel format is a chrome+luminance YUV format
@@ -90,6 +90,7 @@
               "cmd/fazantix"
               "cmd/fazantix-window"
               "cmd/fazantix-validate-config"
+              "cmd/fazantixctl"
             ];
 
             inherit tags;
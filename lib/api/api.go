@@ -11,14 +11,15 @@ import (
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
-
 	"github.com/fosdem/fazantix/lib/config"
+	fazantixLog "github.com/fosdem/fazantix/lib/log"
 	"github.com/fosdem/fazantix/lib/metrics"
+	"github.com/fosdem/fazantix/lib/scripting"
 	"github.com/fosdem/fazantix/lib/stats"
 	"github.com/fosdem/fazantix/lib/theatre"
 
 	_ "github.com/fosdem/fazantix/lib/api/docs"
+	"github.com/gorilla/websocket"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
@@ -37,11 +38,23 @@ type Api struct {
 	theatre *theatre.Theatre
 
 	Stats *stats.Stats
+	// Scripts is nil if the API runs without a script host
+	Scripts *scripting.Host
+
+	// InitialState holds the packets a new client needs to catch up: the
+	// active scene and the lock of every stage
+	InitialState map[string]statePacket
+	// sceneSeqs holds the Seq of the last set-scene event sent for every
+	// stage, guarded by stateMutex
+	sceneSeqs  map[string]uint64
+	stateMutex sync.Mutex
 
-	InitialState map[string][]byte
-	stateMutex   sync.Mutex
+	upgrader  websocket.Upgrader
+	wsClients map[*wsClient]bool
+	wsMutex   sync.Mutex
 
-	wsClients map[*websocket.Conn]bool
+	stateTopics map[string]*stateTopic
+	events      *eventLog
 }
 
 func New(cfg *config.ApiCfg, t *theatre.Theatre) *Api {
@@ -51,29 +64,74 @@ func New(cfg *config.ApiCfg, t *theatre.Theatre) *Api {
 	a.theatre = t
 	a.srv.Addr = cfg.Bind
 	a.srv.Handler = a.mux
-	a.wsClients = make(map[*websocket.Conn]bool)
-	a.InitialState = make(map[string][]byte)
+	a.upgrader.CheckOrigin = a.checkOrigin
+	a.wsClients = make(map[*wsClient]bool)
+	a.InitialState = make(map[string]statePacket)
+	a.sceneSeqs = make(map[string]uint64)
+	a.stateTopics = a.newStateTopics()
+	a.events = newEventLog()
 
 	t.AddEventListener("set-scene", func(t *theatre.Theatre, data interface{}) {
+		a.publishScene(data.(theatre.EventDataSetScene))
+	})
+	t.AddEventListener("lock", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataLock)
+		event.Event = "lock"
+		packet := a.publishEvent(TopicScene, event.Event, event)
+		if packet == nil {
+			return
+		}
 		a.stateMutex.Lock()
-		defer a.stateMutex.Unlock()
-		event := data.(theatre.EventDataSetScene)
-		event.Event = "set-scene"
-		packet, err := json.Marshal(event)
-		a.InitialState[fmt.Sprintf("active-scene-%s", event.Stage)] = packet
-
-		for ws := range a.wsClients {
-			if err != nil {
-				return
-			}
-			err = ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err != nil {
-				log.Printf("could not set write deadline: %s\n", err.Error())
-				return
-			}
-			if err := ws.WriteMessage(websocket.TextMessage, packet); err != nil {
-				return
-			}
+		a.InitialState[fmt.Sprintf("lock-%s", event.Stage)] = statePacket{event.Event, packet}
+		a.stateMutex.Unlock()
+	})
+	t.AddEventListener("cue", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataCue)
+		event.Event = "cue"
+		a.publishEvent(TopicScene, event.Event, event)
+	})
+	t.AddEventListener("set-transition", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSetTransition)
+		event.Event = "set-transition"
+		a.publishEvent(TopicScene, event.Event, event)
+	})
+	t.AddEventListener("tally", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataTally)
+		event.Event = "tally"
+		a.publishEvent(TopicTally, event.Event, event)
+	})
+	t.AddEventListener("source-health", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSourceHealth)
+		event.Event = "source-health"
+		a.publishEvent(TopicHealth, event.Event, event)
+	})
+	t.AddEventListener("automation", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataAutomation)
+		event.Event = "automation"
+		a.publishEvent(TopicHealth, event.Event, event)
+	})
+	t.AddEventListener("crash", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataCrash)
+		event.Event = "crash"
+		a.publishEvent(TopicHealth, event.Event, event)
+	})
+	t.AddEventListener("schedule", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSchedule)
+		event.Event = "schedule"
+		a.publishEvent(TopicSchedule, event.Event, event)
+	})
+	t.AddEventListener("macro", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataMacro)
+		event.Event = "macro"
+		a.publishEvent(TopicMacros, event.Event, event)
+	})
+	fazantixLog.AddListener(func(entry *fazantixLog.Entry) {
+		packet, err := json.Marshal(struct {
+			Event string
+			*fazantixLog.Entry
+		}{"log", entry})
+		if err == nil {
+			a.broadcast(TopicLogs, packet)
 		}
 	})
 	a.Stats = stats.New()
@@ -82,20 +140,42 @@ func New(cfg *config.ApiCfg, t *theatre.Theatre) *Api {
 
 func (a *Api) Serve() error {
 	if a.cfg.EnableProfiler {
-		a.mux.HandleFunc("/prof", a.profileCPU)
+		a.mux.HandleFunc("/prof", a.require(RoleAdmin, a.profileCPU))
+	}
+	a.mux.HandleFunc("/api/kill", a.require(RoleAdmin, a.suicide))
+	a.mux.HandleFunc("/api/stats", a.require(RoleViewer, a.getStats))
+	a.mux.HandleFunc("/api/scene", a.require(RoleOperator, a.handleSceneJson))
+	a.mux.HandleFunc("/api/scene/{stage}/{scene}", a.require(RoleOperator, a.handleScene))
+	a.mux.HandleFunc("/api/config", a.require(RoleViewer, a.handleConfig))
+	a.mux.HandleFunc("POST /api/stage/{stage}/lock", a.require(RoleOperator, a.handleLock))
+	a.mux.HandleFunc("DELETE /api/stage/{stage}/lock", a.require(RoleOperator, a.handleLock))
+	a.mux.HandleFunc("/api/ws", a.require(RoleViewer, a.handleWebsocket))
+	a.mux.HandleFunc("GET /api/events", a.require(RoleViewer, a.handleEvents))
+	a.mux.HandleFunc("GET /api/macros", a.require(RoleViewer, a.getMacros))
+	a.mux.HandleFunc("POST /api/macro/{macro}/{action}", a.require(RoleOperator, a.handleMacro))
+	a.mux.HandleFunc("GET /api/schedule", a.require(RoleViewer, a.getSchedule))
+	a.mux.HandleFunc("GET /api/scripts", a.require(RoleViewer, a.getScripts))
+	a.mux.HandleFunc("POST /api/script/{script}/reload", a.require(RoleAdmin, a.reloadScript))
+	a.mux.HandleFunc("GET /api/audit", a.require(RoleOperator, a.handleAudit))
+	for _, pattern := range []string{
+		"/api/media/source/{source}",
+		"/api/media/source/{source}/{format}",
+		"/api/media/sink/{sink}",
+		"/api/media/sink/{sink}/{format}",
+	} {
+		// anyone who can view may fetch stills, replacing images is for admins
+		a.mux.HandleFunc("GET "+pattern, a.require(RoleViewer, a.handleMediaSource))
+		a.mux.HandleFunc(pattern, a.require(RoleAdmin, a.handleMediaSource))
 	}
-	a.mux.HandleFunc("/api/kill", a.suicide)
-	a.mux.HandleFunc("/api/stats", a.getStats)
-	a.mux.HandleFunc("/api/scene", a.handleSceneJson)
-	a.mux.HandleFunc("/api/scene/{stage}/{scene}", a.handleScene)
-	a.mux.HandleFunc("/api/config", a.handleConfig)
-	a.mux.HandleFunc("/api/ws", a.handleWebsocket)
-	a.mux.HandleFunc("/api/media/source/{source}", a.handleMediaSource)
-	a.mux.HandleFunc("/api/media/sink/{sink}", a.handleMediaSource)
-	a.mux.HandleFunc("/api/media/source/{source}/{format}", a.handleMediaSource)
 	a.mux.Handle("/swagger/", httpSwagger.Handler())
-	a.mux.Handle("/metrics", metrics.Handler())
+	a.mux.Handle("/metrics", a.require(RoleViewer, metrics.Handler().ServeHTTP))
 	a.mux.Handle("/", http.FileServer(http.FS(contentFS)))
+	for _, topic := range a.stateTopics {
+		go a.streamState(topic)
+	}
+	if a.cfg.Tls != nil {
+		return a.srv.ListenAndServeTLS(string(a.cfg.Tls.Cert), string(a.cfg.Tls.Key))
+	}
 	return a.srv.ListenAndServe()
 }
 
@@ -114,9 +194,10 @@ func (a *Api) profileCPU(w http.ResponseWriter, _ *http.Request) {
 // @Tags		base
 // @Success	200
 // @Success	200	{object}	stats.Stats
-func (a *Api) suicide(w http.ResponseWriter, _ *http.Request) {
+func (a *Api) suicide(w http.ResponseWriter, req *http.Request) {
 	log.Printf("shutting down as per api request")
 	a.theatre.ShutdownRequested = true
+	a.audit(req, "kill", nil, nil)
 	_, err := fmt.Fprintf(w, "\"ok\"\n")
 	if err != nil {
 		log.Printf("could not write response: %s\n", err.Error())
@@ -145,6 +226,27 @@ func (a *Api) getStats(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+// @Summary	Get the current and next scheduled event
+// @Description	Phase is holding, talk or ending. Current is the running event, or the one that just ended during the ending phase.
+// @Router		/api/schedule [get]
+// @Tags		scene
+// @Produce	json
+// @Success	200	{object}	theatre.ScheduleStatus
+// @Failure	404	{string}	string	"No schedule is configured"
+func (a *Api) getSchedule(w http.ResponseWriter, _ *http.Request) {
+	status := a.theatre.Schedule()
+	if status == nil {
+		http.Error(w, "no schedule configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.Printf("could not write response: %s\n", err.Error())
+		return
+	}
+}
+
 type Config struct {
 	Stages []StageInfo `json:"stages"`
 	Scenes []SceneInfo `json:"scenes"`
@@ -152,6 +254,8 @@ type Config struct {
 type StageInfo struct {
 	Name       string `example:"projector"`
 	PreviewFor string
+	// LockedBy is who holds the lock on the stage, empty if unlocked
+	LockedBy string `example:"director"`
 }
 type SceneInfo struct {
 	Code  string `example:"side-by-side"`
@@ -166,6 +270,17 @@ type SceneInfo struct {
 // @Produce	json
 // @Success	200	{object}	api.Config
 func (a *Api) handleConfig(w http.ResponseWriter, _ *http.Request) {
+	result := a.buildConfig()
+	w.Header().Add("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	err := encoder.Encode(result)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("couldn't encode config: %s", err), http.StatusForbidden)
+		return
+	}
+}
+
+func (a *Api) buildConfig() *Config {
 	result := &Config{
 		Stages: make([]StageInfo, len(a.theatre.Stages)),
 		Scenes: make([]SceneInfo, len(a.theatre.Scenes)),
@@ -181,21 +296,17 @@ func (a *Api) handleConfig(w http.ResponseWriter, _ *http.Request) {
 	for name, stage := range a.theatre.Stages {
 		result.Stages[idx].Name = name
 		result.Stages[idx].PreviewFor = stage.PreviewFor
+		result.Stages[idx].LockedBy = stage.LockedBy
 		idx++
 	}
-	w.Header().Add("Content-Type", "application/json")
-	encoder := json.NewEncoder(w)
-	err := encoder.Encode(result)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("couldn't encode config: %s", err), http.StatusForbidden)
-		return
-	}
+	return result
 }
 
-func ServeInBackground(theatre *theatre.Theatre, cfg *config.ApiCfg) *Api {
+func ServeInBackground(theatre *theatre.Theatre, cfg *config.ApiCfg, scripts *scripting.Host) *Api {
 	var theApi *Api
 	if cfg != nil {
 		theApi = New(cfg, theatre)
+		theApi.Scripts = scripts
 
 		log.Printf("starting web server\n")
 		go func() {
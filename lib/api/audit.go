@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/audit"
+)
+
+// audit records a state-changing request in the theatre's audit log
+func (a *Api) audit(req *http.Request, action string, params map[string]interface{}, err error) {
+	entry := audit.Entry{
+		Origin: "api",
+		Client: req.RemoteAddr,
+		Action: action,
+		Params: params,
+	}
+	if p := PrincipalFrom(req.Context()); p != nil && a.authEnabled() {
+		entry.User = p.Name
+	}
+	a.theatre.Audit.Record(entry, err)
+}
+
+func (a *Api) auditWs(client *wsClient, method string, rawParams json.RawMessage, err error) {
+	entry := audit.Entry{
+		Origin: "api",
+		Client: client.conn.RemoteAddr().String(),
+		Action: method,
+	}
+	if a.authEnabled() {
+		entry.User = client.principal.Name
+	}
+	if len(rawParams) > 0 {
+		_ = json.Unmarshal(rawParams, &entry.Params)
+	}
+	a.theatre.Audit.Record(entry, err)
+}
+
+// @Summary	Get recent state-changing actions
+// @Description	Returns the entries still kept in memory, oldest first. The full history is in the audit log file, if configured.
+// @Router		/api/audit [get]
+// @Tags		base
+// @Param		since	query	string	false	"Only return entries after this RFC 3339 time, or this long ago (as in 15m)"
+// @Produce	json
+// @Success	200	{array}		audit.Entry
+// @Failure	400	{string}	string	"The since parameter could not be parsed"
+func (a *Api) handleAudit(w http.ResponseWriter, req *http.Request) {
+	var since time.Time
+	if s := req.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			ago, durationErr := time.ParseDuration(s)
+			if durationErr != nil {
+				http.Error(w, fmt.Sprintf("could not parse since: %s", err), http.StatusBadRequest)
+				return
+			}
+			since = time.Now().Add(-ago)
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(a.theatre.Audit.Since(since))
+	if err != nil {
+		log.Printf("could not write response: %s\n", err.Error())
+		return
+	}
+}
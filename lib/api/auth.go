@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fosdem/fazantix/lib/config"
+)
+
+// Role is what an API user is allowed to do, every role can do everything
+// the roles below it can
+type Role int
+
+const (
+	RoleNone Role = iota
+	// RoleViewer can read state, stats, metrics and fetch stills
+	RoleViewer
+	// RoleOperator can also switch scenes and change transitions
+	RoleOperator
+	// RoleAdmin can also shut fazantix down, profile it and upload images
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return config.RoleViewer
+	case RoleOperator:
+		return config.RoleOperator
+	case RoleAdmin:
+		return config.RoleAdmin
+	default:
+		return "none"
+	}
+}
+
+func parseRole(name string) Role {
+	switch name {
+	case config.RoleViewer:
+		return RoleViewer
+	case config.RoleOperator:
+		return RoleOperator
+	case config.RoleAdmin:
+		return RoleAdmin
+	default:
+		return RoleNone
+	}
+}
+
+// Principal is whoever made a request
+type Principal struct {
+	Name string
+	Role Role
+}
+
+type principalKey struct{}
+
+// anonymous is used for every request when no users or tokens are
+// configured, which keeps the API open as it always was
+var anonymous = &Principal{Name: "anonymous", Role: RoleAdmin}
+
+// PrincipalFrom returns who made the request, or nil outside of an
+// authenticated handler
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+func (a *Api) authEnabled() bool {
+	return len(a.cfg.Users) > 0 || len(a.cfg.Tokens) > 0
+}
+
+// authenticate checks the bearer token, the access_token query parameter
+// (for browsers opening a websocket) or the basic auth credentials. It
+// returns nil if none of them match.
+func (a *Api) authenticate(req *http.Request) *Principal {
+	if !a.authEnabled() {
+		return anonymous
+	}
+
+	token := req.URL.Query().Get("access_token")
+	if bearer, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	if token != "" {
+		for name, t := range a.cfg.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+				return &Principal{Name: name, Role: parseRole(t.Role)}
+			}
+		}
+		return nil
+	}
+
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil
+	}
+	user, ok := a.cfg.Users[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) != 1 {
+		return nil
+	}
+	return &Principal{Name: username, Role: parseRole(user.Role)}
+}
+
+// require wraps a handler so it only runs for requests made with at least
+// the given role
+func (a *Api) require(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		principal := a.authenticate(req)
+		if principal == nil {
+			if len(a.cfg.Users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="fazantix"`)
+			}
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if principal.Role < role {
+			http.Error(w, fmt.Sprintf("%s role required", role), http.StatusForbidden)
+			return
+		}
+		handler(w, req.WithContext(context.WithValue(req.Context(), principalKey{}, principal)))
+	}
+}
+
+// checkOrigin decides whether a browser page may open the websocket. A
+// browser sends the basic auth credentials it knows along with the
+// handshake, no matter which page asked, so with authentication enabled
+// only our own pages and the configured origins get through. Clients that
+// are not browsers send no Origin at all.
+func (a *Api) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" || !a.authEnabled() {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+	for _, allowed := range a.cfg.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	log.Printf("refusing websocket from origin %s\n", origin)
+	return false
+}
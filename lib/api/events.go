@@ -0,0 +1,187 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventLogSize bounds how many events a reconnecting SSE client can
+// catch up on
+const eventLogSize = 1024
+
+type loggedEvent struct {
+	ID   uint64
+	Name string
+	Data []byte
+}
+
+// eventLog is a ring buffer of the most recent theatre events. Waiters
+// get notified of new events by the closing of the notify channel.
+type eventLog struct {
+	events []loggedEvent
+	lastID uint64
+	notify chan struct{}
+	// epoch tells the IDs of this process apart from those handed out
+	// before a restart, which count from 1 again
+	epoch string
+	sync.Mutex
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		events: make([]loggedEvent, 0, eventLogSize),
+		notify: make(chan struct{}),
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+}
+
+// eventID is the ID sent to SSE clients, made up of the epoch and the
+// number of the event
+func (l *eventLog) eventID(id uint64) string {
+	return fmt.Sprintf("%s-%d", l.epoch, id)
+}
+
+// parseID turns a Last-Event-ID back into the number of an event. An ID
+// of another epoch is not an error, but is not known either: whatever came
+// after it is gone.
+func (l *eventLog) parseID(s string) (uint64, bool, error) {
+	epoch, number, ok := strings.Cut(s, "-")
+	if !ok {
+		number = epoch
+	}
+	id, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	if epoch != l.epoch {
+		return 0, false, nil
+	}
+	return id, true, nil
+}
+
+func (l *eventLog) append(name string, data []byte) {
+	l.Lock()
+	defer l.Unlock()
+	l.lastID++
+	event := loggedEvent{ID: l.lastID, Name: name, Data: data}
+	if len(l.events) < eventLogSize {
+		l.events = append(l.events, event)
+	} else {
+		l.events[(l.lastID-1)%eventLogSize] = event
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// since returns all retained events newer than id, a channel that gets
+// closed when the next event comes in, and whether events after id were
+// lost because the log wrapped around
+func (l *eventLog) since(id uint64) ([]loggedEvent, <-chan struct{}, bool) {
+	l.Lock()
+	defer l.Unlock()
+	if id >= l.lastID {
+		return nil, l.notify, false
+	}
+	oldest := l.lastID - uint64(len(l.events)) + 1
+	lost := id+1 < oldest
+	if lost {
+		id = oldest - 1
+	}
+	result := make([]loggedEvent, 0, l.lastID-id)
+	for i := id + 1; i <= l.lastID; i++ {
+		result = append(result, l.events[(i-1)%eventLogSize])
+	}
+	return result, l.notify, lost
+}
+
+func (l *eventLog) last() uint64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.lastID
+}
+
+// @Summary	Stream theatre events as Server-Sent Events
+// @Description	Carries the same set-scene, cue, set-transition, tally and source-health packets as the websocket.
+// @Description	Reconnecting clients that send Last-Event-ID get the events they missed, as long as they are still in the in-memory log. If some were lost, an "overflow" event is sent first.
+// @Description	An ID from before a restart of fazantix gets an "overflow" event followed by the current state, as for a new client.
+// @Router		/api/events [get]
+// @Param		Last-Event-ID	header	string	false	"ID of the last event the client has seen"
+// @Tags		base
+// @Produce	text/event-stream
+// @Success	200
+// @Failure	400	{string}	string	"Invalid Last-Event-ID"
+func (a *Api) handleEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var lastID uint64
+	known := false
+	resume := req.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = req.URL.Query().Get("last_event_id")
+	}
+	if resume != "" {
+		var err error
+		lastID, known, err = a.events.parseID(resume)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid Last-Event-ID: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if !known {
+		if resume != "" {
+			// the client saw events of a previous run, which we know
+			// nothing about
+			fmt.Fprintf(w, "event: overflow\ndata: {}\n\n")
+		}
+		// a fresh client starts with the current state instead of history
+		lastID = a.events.last()
+		for _, state := range a.initialState() {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", state.name, state.packet)
+		}
+		flusher.Flush()
+	}
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		events, notify, lost := a.events.since(lastID)
+		if lost {
+			fmt.Fprintf(w, "event: overflow\ndata: {}\n\n")
+		}
+		for _, event := range events {
+			_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", a.events.eventID(event.ID), event.Name, event.Data)
+			if err != nil {
+				log.Printf("could not write event: %s\n", err)
+				return
+			}
+			lastID = event.ID
+		}
+		flusher.Flush()
+
+		select {
+		case <-req.Context().Done():
+			return
+		case <-notify:
+		case <-keepalive.C:
+			_, err := fmt.Fprintf(w, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+func appendEvents(l *eventLog, from int, to int) {
+	for i := from; i <= to; i++ {
+		l.append("cue", []byte(fmt.Sprintf(`{"N":%d}`, i)))
+	}
+}
+
+func TestEventLogWraparound(t *testing.T) {
+	l := newEventLog()
+	appendEvents(l, 1, 10)
+
+	events, _, lost := l.since(4)
+	if lost || len(events) != 6 || events[0].ID != 5 || events[5].ID != 10 {
+		t.Errorf("expected events 5 to 10, got %d events, lost %v", len(events), lost)
+	}
+	events, notify, lost := l.since(10)
+	if lost || len(events) != 0 {
+		t.Errorf("expected nothing new, got %d events, lost %v", len(events), lost)
+	}
+	appendEvents(l, 11, eventLogSize+20)
+	select {
+	case <-notify:
+	default:
+		t.Errorf("waiter was not notified")
+	}
+
+	events, _, lost = l.since(4)
+	if !lost || len(events) != eventLogSize || events[0].ID != 21 || events[len(events)-1].ID != eventLogSize+20 {
+		t.Errorf("expected the whole log to be replayed after a loss, got %d events from %d, lost %v",
+			len(events), events[0].ID, lost)
+	}
+	events, _, lost = l.since(20)
+	if lost || len(events) != eventLogSize {
+		t.Errorf("the oldest retained event was counted as lost")
+	}
+	for i, event := range events {
+		if want := fmt.Sprintf(`{"N":%d}`, event.ID); string(event.Data) != want || event.ID != uint64(21+i) {
+			t.Fatalf("event %d is %s with id %d, expected %s", i, event.Data, event.ID, want)
+		}
+	}
+}
+
+func TestEventIDs(t *testing.T) {
+	l := newEventLog()
+	id, known, err := l.parseID(l.eventID(42))
+	if err != nil || !known || id != 42 {
+		t.Errorf("own event id was not recognised: %d %v %v", id, known, err)
+	}
+	for _, stale := range []string{"42", "0-42", "epoch-42"} {
+		if _, known, err := l.parseID(stale); err != nil || known {
+			t.Errorf("%s should be a valid id of another run, got known %v, error %v", stale, known, err)
+		}
+	}
+	for _, invalid := range []string{"", "x", l.epoch + "-", l.epoch + "-x"} {
+		if _, _, err := l.parseID(invalid); err == nil {
+			t.Errorf("%q should not parse", invalid)
+		}
+	}
+}
+
+// readSSE collects the id and event lines of an event stream, until it
+// has seen until
+func readSSE(t *testing.T, srv *httptest.Server, lastEventID string, until string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not open event stream: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("event stream returned %s", resp.Status)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "id: ") || strings.HasPrefix(line, "event: ") {
+			lines = append(lines, line)
+		}
+		if line == until {
+			return lines
+		}
+	}
+	t.Fatalf("stream ended before %s, got %v", until, lines)
+	return nil
+}
+
+func TestEventsResume(t *testing.T) {
+	a := New(&config.ApiCfg{}, theatretest.New(t, nil))
+	srv := httptest.NewServer(http.HandlerFunc(a.handleEvents))
+	t.Cleanup(srv.Close)
+	appendEvents(a.events, 1, 5)
+
+	lines := readSSE(t, srv, a.events.eventID(3), "id: "+a.events.eventID(5))
+	expected := []string{
+		"id: " + a.events.eventID(4), "event: cue",
+		"id: " + a.events.eventID(5),
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected events 4 and 5, got %v", lines)
+	}
+
+	appendEvents(a.events, 6, eventLogSize+10)
+	lines = readSSE(t, srv, a.events.eventID(3), "id: "+a.events.eventID(11))
+	if len(lines) != 2 || lines[0] != "event: overflow" {
+		t.Errorf("expected an overflow before the oldest retained event, got %v", lines)
+	}
+
+	// after a restart, the ids start over and an old one means nothing
+	lines = readSSE(t, srv, "0-3", "event: overflow")
+	if len(lines) != 1 {
+		t.Errorf("expected an overflow for an id of another run, got %v", lines)
+	}
+
+	resp, err := http.Get(srv.URL + "?last_event_id=nonsense")
+	if err != nil {
+		t.Fatalf("could not request events: %s", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an invalid id to be refused, got %s", resp.Status)
+	}
+}
+
+func TestEventsInitialState(t *testing.T) {
+	th := theatretest.Build(t, theatretest.Config())
+	a := New(&config.ApiCfg{}, th)
+	srv := httptest.NewServer(http.HandlerFunc(a.handleEvents))
+	t.Cleanup(srv.Close)
+	if err := th.ResetToDefaultScenes(); err != nil {
+		t.Fatalf("could not reset scenes: %s", err)
+	}
+	if err := th.Lock(theatre.Caller{Holder: "alice"}, "projector"); err != nil {
+		t.Fatalf("could not lock: %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(a.initialState()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the scene and lock never made it into the initial state")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	lines := readSSE(t, srv, "", "event: lock")
+	if strings.Join(lines, "\n") != "event: set-scene\nevent: lock" {
+		t.Errorf("expected the scene and the lock under their own names, got %v", lines)
+	}
+}
+
+func TestEventsSceneOrder(t *testing.T) {
+	a := New(&config.ApiCfg{}, theatretest.Build(t, theatretest.Config()))
+	from := a.events.last()
+	a.publishScene(theatre.EventDataSetScene{Stage: "projector", Scene: "full-cam", Seq: 2})
+	a.publishScene(theatre.EventDataSetScene{Stage: "projector", Scene: "full-slides", Seq: 1})
+
+	events, _, _ := a.events.since(from)
+	if len(events) != 1 || !strings.Contains(string(events[0].Data), `"full-cam"`) {
+		t.Errorf("expected only the later scene change to be published, got %d events", len(events))
+	}
+	state := a.initialState()
+	if len(state) != 1 || !strings.Contains(string(state[0].packet), `"full-cam"`) {
+		t.Errorf("the late scene change replaced the current scene in the initial state")
+	}
+}
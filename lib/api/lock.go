@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+// caller identifies the request for stage locks: the API user if
+// authentication is enabled, the client address otherwise
+func (a *Api) caller(req *http.Request, force bool) theatre.Caller {
+	if p := PrincipalFrom(req.Context()); p != nil && a.authEnabled() {
+		return theatre.Caller{Holder: p.Name, Force: force}
+	}
+	return theatre.Caller{Holder: hostOf(req.RemoteAddr), Force: force}
+}
+
+func (a *Api) wsCaller(client *wsClient, force bool) theatre.Caller {
+	if a.authEnabled() {
+		return theatre.Caller{Holder: client.principal.Name, Force: force}
+	}
+	return theatre.Caller{Holder: hostOf(client.conn.RemoteAddr().String()), Force: force}
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// lockStatus picks the status code for a failed scene change
+func lockStatus(err error) int {
+	var lockErr *theatre.StageLockedError
+	if errors.As(err, &lockErr) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// @Summary	Lock or unlock a stage
+// @Description	While a stage is locked, scene changes from anyone but the holder of the lock are refused, from every control surface, unless they are forced.
+// @Description	The holder is the API user, or the client address if authentication is disabled.
+// @Router		/api/stage/{stage}/lock [post]
+// @Router		/api/stage/{stage}/lock [delete]
+// @Tags		scene
+// @Param		stage	path	string	true	"Stage to lock or unlock"
+// @Param		force	query	bool	false	"Take over or release someone else's lock"
+// @Success	200
+// @Failure	400	{string}	string	"The stage does not exist"
+// @Failure	409	{string}	string	"The stage is locked by someone else"
+func (a *Api) handleLock(w http.ResponseWriter, req *http.Request) {
+	stage := req.PathValue("stage")
+	force := req.URL.Query().Get("force") == "true"
+	caller := a.caller(req, force)
+
+	var err error
+	action := "lock"
+	if req.Method == http.MethodDelete {
+		action = "unlock"
+		err = a.theatre.Unlock(caller, stage)
+	} else {
+		err = a.theatre.Lock(caller, stage)
+	}
+	a.audit(req, action, map[string]interface{}{
+		"stage": stage,
+		"force": force,
+	}, err)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not %s stage: %s", action, err), lockStatus(err))
+		return
+	}
+
+	_, err = fmt.Fprintf(w, "\"ok\"\n")
+	if err != nil {
+		log.Printf("could not write response: %s\n", err.Error())
+		return
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+type WsMacroParams struct {
+	Name string `example:"intro"`
+}
+
+func (a *Api) macroAction(caller theatre.Caller, name string, action string) error {
+	switch action {
+	case "start":
+		return a.theatre.StartMacro(caller, name)
+	case "stop":
+		return a.theatre.StopMacro(name)
+	case "pause":
+		return a.theatre.PauseMacro(name)
+	case "resume":
+		return a.theatre.ResumeMacro(name)
+	default:
+		return fmt.Errorf("unknown macro action %s", action)
+	}
+}
+
+// @Summary	List the macros and what they are doing
+// @Router		/api/macros [get]
+// @Tags		scene
+// @Produce	json
+// @Success	200	{array}	theatre.MacroStatus
+func (a *Api) getMacros(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(a.theatre.MacroStatuses())
+	if err != nil {
+		log.Printf("could not write response: %s\n", err.Error())
+		return
+	}
+}
+
+// @Summary	Start, stop, pause or resume a macro
+// @Description	A macro runs its scene changes on behalf of whoever started it, so they respect stage locks in the same way. Stopping or pausing takes effect before the next step.
+// @Router		/api/macro/{macro}/{action} [post]
+// @Tags		scene
+// @Param		macro	path	string	true	"Name of the macro"
+// @Param		action	path	string	true	"start, stop, pause or resume"
+// @Success	200
+// @Failure	400	{string}	string	"No such macro, or it is not in a state to do this"
+func (a *Api) handleMacro(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("macro")
+	action := req.PathValue("action")
+	err := a.macroAction(a.caller(req, false), name, action)
+	a.audit(req, "macro-"+action, map[string]interface{}{"name": name}, err)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not %s macro: %s", action, err), http.StatusBadRequest)
+		return
+	}
+
+	_, err = fmt.Fprintf(w, "\"ok\"\n")
+	if err != nil {
+		log.Printf("could not write response: %s\n", err.Error())
+		return
+	}
+}
+
+func wsMacroCommand(action string) func(a *Api, client *wsClient, params json.RawMessage) (interface{}, error) {
+	return func(a *Api, client *wsClient, params json.RawMessage) (interface{}, error) {
+		var p WsMacroParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return nil, a.macroAction(a.wsCaller(client, false), p.Name, action)
+	}
+}
@@ -72,35 +72,9 @@ func (a *Api) handleMediaSource(w http.ResponseWriter, req *http.Request) {
 		}
 		defer source.Frames().FinishedReading(frame)
 
-		bounds := image.Rectangle{
-			Min: image.Point{},
-			Max: image.Point{X: frame.Width, Y: frame.Height},
-		}
-
-		var img image.Image
-		switch frame.Type {
-		case encdec.RGBAFrames:
-			img = image.NewNRGBA(bounds)
-			copy(img.(*image.NRGBA).Pix, frame.Data)
-		case encdec.RGBFrames:
-			img = image.NewNRGBA(bounds)
-			pix := img.(*image.NRGBA).Pix
-			for i := range len(frame.Data) / 3 {
-				pix[i*4+0] = frame.Data[i*3+0]
-				pix[i*4+1] = frame.Data[i*3+1]
-				pix[i*4+2] = frame.Data[i*3+2]
-				pix[i*4+3] = 255
-			}
-		case encdec.YUV422Frames:
-			img = image.NewYCbCr(bounds, image.YCbCrSubsampleRatio422)
-			textureY, _, _ := frame.Texture(0)
-			textureCb, _, _ := frame.Texture(1)
-			textureCr, _, _ := frame.Texture(2)
-			copy(img.(*image.YCbCr).Y, textureY)
-			copy(img.(*image.YCbCr).Cb, textureCb)
-			copy(img.(*image.YCbCr).Cr, textureCr)
-		default:
-			http.Error(w, "Unhandled frame type", http.StatusInternalServerError)
+		img, err := encdec.FrameToImage(frame, &source.Frames().FrameInfo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		switch formatName {
@@ -124,20 +98,25 @@ func (a *Api) handleMediaSource(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	imgSource, ok := source.(*imgsource.ImgSource)
-	if !ok {
+	if _, ok := source.(*imgsource.ImgSource); !ok {
 		http.Error(w, "not a valid image source", http.StatusBadRequest)
 		return
 	}
 
 	if req.Method == "PUT" {
+		params := map[string]interface{}{"source": sourceName}
 		newImage, ftype, err := image.Decode(req.Body)
 		if err != nil {
+			a.audit(req, "upload-image", params, err)
 			http.Error(w, fmt.Sprintf("not a valid image: %s", err), http.StatusBadRequest)
 			return
 		}
+		params["format"] = ftype
+		params["width"] = newImage.Bounds().Dx()
+		params["height"] = newImage.Bounds().Dy()
 		log.Printf("Image source %s was updated with new %s image (%dx%d)\n", sourceName, ftype, newImage.Bounds().Dx(), newImage.Bounds().Dy())
-		err = imgSource.SetImage(newImage)
+		err = a.theatre.SetImage(sourceName, newImage)
+		a.audit(req, "upload-image", params, err)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("could not update image: %s", err), http.StatusBadRequest)
 			return
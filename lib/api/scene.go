@@ -10,6 +10,8 @@ import (
 type SceneReq struct {
 	Stage string `example:"projector"`
 	Scene string `example:"side-by-side"`
+	// Force switches even if someone else holds the lock on the stage
+	Force bool
 }
 
 // @Summary	Start a transition to a specific scene on one of the outputs
@@ -17,8 +19,10 @@ type SceneReq struct {
 // @Tags		scene
 // @Param		stage	path	string	true	"Output name to switch the scene for"
 // @Param		scene	path	string	true	"The name of the scene to transition to"
+// @Param		force	query	bool	false	"Switch even if someone else holds the lock on the stage"
 // @Success	200
 // @Failure	400	{string}	string	"Could not decode json request"
+// @Failure	409	{string}	string	"The stage is locked by someone else"
 func (a *Api) handleScene(w http.ResponseWriter, req *http.Request) {
 	var sceneReq SceneReq
 	if req.PathValue("scene") == "" && req.PathValue("stage") == "" {
@@ -30,11 +34,13 @@ func (a *Api) handleScene(w http.ResponseWriter, req *http.Request) {
 	} else {
 		sceneReq.Scene = req.PathValue("scene")
 		sceneReq.Stage = req.PathValue("stage")
+		sceneReq.Force = req.URL.Query().Get("force") == "true"
 	}
 
-	err := a.theatre.SetScene(sceneReq.Stage, sceneReq.Scene, true)
+	err := a.theatre.SetSceneAs(a.caller(req, sceneReq.Force), sceneReq.Stage, sceneReq.Scene, true)
+	a.auditScene(req, &sceneReq, err)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not set scene: %s", err), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("could not set scene: %s", err), lockStatus(err))
 		return
 	}
 
@@ -53,6 +59,7 @@ func (a *Api) handleScene(w http.ResponseWriter, req *http.Request) {
 // @Produce	json
 // @Success	200
 // @Failure	400	{string}	string	"Could not decode json request"
+// @Failure	409	{string}	string	"The stage is locked by someone else"
 func (a *Api) handleSceneJson(w http.ResponseWriter, req *http.Request) {
 	var sceneReq SceneReq
 	err := json.NewDecoder(req.Body).Decode(&sceneReq)
@@ -61,9 +68,10 @@ func (a *Api) handleSceneJson(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = a.theatre.SetScene(sceneReq.Stage, sceneReq.Scene, true)
+	err = a.theatre.SetSceneAs(a.caller(req, sceneReq.Force), sceneReq.Stage, sceneReq.Scene, true)
+	a.auditScene(req, &sceneReq, err)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not set scene: %s", err), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("could not set scene: %s", err), lockStatus(err))
 		return
 	}
 
@@ -73,3 +81,11 @@ func (a *Api) handleSceneJson(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 }
+
+func (a *Api) auditScene(req *http.Request, sceneReq *SceneReq, err error) {
+	a.audit(req, "set-scene", map[string]interface{}{
+		"stage": sceneReq.Stage,
+		"scene": sceneReq.Scene,
+		"force": sceneReq.Force,
+	}, err)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/fosdem/fazantix/lib/scripting"
+)
+
+// @Summary	List the scripts and whether they loaded
+// @Router		/api/scripts [get]
+// @Tags		scripting
+// @Produce	json
+// @Success	200	{array}	scripting.Status
+func (a *Api) getScripts(w http.ResponseWriter, _ *http.Request) {
+	statuses := []scripting.Status{}
+	if a.Scripts != nil {
+		statuses = a.Scripts.Statuses()
+	}
+	w.Header().Add("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(statuses)
+	if err != nil {
+		log.Printf("could not write response: %s\n", err.Error())
+		return
+	}
+}
+
+// @Summary	Reload a script from its file
+// @Description	If the new version fails to load, the old one keeps running and the error is returned.
+// @Router		/api/script/{script}/reload [post]
+// @Tags		scripting
+// @Param		script	path	string	true	"Name of the script"
+// @Success	200
+// @Failure	400	{string}	string	"No such script, or it does not load"
+func (a *Api) reloadScript(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("script")
+	err := fmt.Errorf("no such script: %s", name)
+	if a.Scripts != nil {
+		err = a.Scripts.Reload(name)
+	}
+	a.audit(req, "script-reload", map[string]interface{}{"name": name}, err)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not reload script: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	_, err = fmt.Fprintf(w, "\"ok\"\n")
+	if err != nil {
+		log.Printf("could not write response: %s\n", err.Error())
+		return
+	}
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/sink/ffmpegsink"
+)
+
+const (
+	TopicLayers      = "layers"
+	TopicSources     = "sources"
+	TopicSinks       = "sinks"
+	TopicTransitions = "transitions"
+)
+
+var defaultStateRates = map[string]float64{
+	TopicLayers:      10,
+	TopicSources:     2,
+	TopicSinks:       1,
+	TopicTransitions: 10,
+}
+
+type LayerGeometry struct {
+	Source  string  `example:"camera"`
+	X       float32 `example:"0.03"`
+	Y       float32 `example:"0.25"`
+	W       float32 `example:"0.45"`
+	H       float32 `example:"0.45"`
+	Opacity float32 `example:"1"`
+}
+
+type SourceState struct {
+	Ready            bool
+	FrameAgeMs       int64
+	DroppedFramesIn  uint64
+	DroppedFramesOut uint64
+}
+
+type SinkState struct {
+	Running          bool
+	Restarts         uint64
+	DroppedFramesIn  uint64
+	DroppedFramesOut uint64
+}
+
+type TransitionState struct {
+	Scene    string
+	Progress float32
+}
+
+// StateUpdate is sent over the websocket for the state topics. The first
+// update after subscribing is Full and contains every entry, later ones
+// only contain the entries that changed or disappeared since the last.
+// A client that fell behind and lost updates gets a Full one again, which
+// replaces whatever it had.
+type StateUpdate struct {
+	Event   string
+	Topic   string
+	Full    bool
+	Changed map[string]interface{}
+	Removed []string `json:",omitempty"`
+}
+
+type stateTopic struct {
+	name     string
+	interval time.Duration
+	sample   func(a *Api) map[string]interface{}
+
+	last map[string]interface{}
+	sync.Mutex
+}
+
+func (a *Api) newStateTopics() map[string]*stateTopic {
+	samplers := map[string]func(a *Api) map[string]interface{}{
+		TopicLayers:      sampleLayers,
+		TopicSources:     sampleSources,
+		TopicSinks:       sampleSinks,
+		TopicTransitions: sampleTransitions,
+	}
+	topics := make(map[string]*stateTopic)
+	for name, sample := range samplers {
+		rate := defaultStateRates[name]
+		if r, ok := a.cfg.StateRates[name]; ok {
+			rate = r
+		}
+		topics[name] = &stateTopic{
+			name:     name,
+			interval: time.Duration(float64(time.Second) / rate),
+			sample:   sample,
+			last:     make(map[string]interface{}),
+		}
+	}
+	return topics
+}
+
+func (a *Api) streamState(topic *stateTopic) {
+	ticker := time.NewTicker(topic.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.updateState(topic)
+	}
+}
+
+// updateState samples a topic and broadcasts what changed since the last
+// sample
+func (a *Api) updateState(topic *stateTopic) {
+	topic.Lock()
+	defer topic.Unlock()
+	current := topic.sample(a)
+	update := &StateUpdate{
+		Event:   "state",
+		Topic:   topic.name,
+		Changed: make(map[string]interface{}),
+	}
+	for key, value := range current {
+		if old, ok := topic.last[key]; !ok || old != value {
+			update.Changed[key] = value
+		}
+	}
+	for key := range topic.last {
+		if _, ok := current[key]; !ok {
+			update.Removed = append(update.Removed, key)
+		}
+	}
+	topic.last = current
+	if len(update.Changed) > 0 || len(update.Removed) > 0 {
+		a.broadcastJSON(topic.name, update)
+	}
+}
+
+// sendFullState queues the complete last known state of a topic, which
+// the client can then keep up to date with the diffs that follow
+func (a *Api) sendFullState(client *wsClient, topic *stateTopic) {
+	topic.Lock()
+	defer topic.Unlock()
+	packet, err := json.Marshal(&StateUpdate{
+		Event:   "state",
+		Topic:   topic.name,
+		Full:    true,
+		Changed: topic.last,
+	})
+	if err != nil {
+		log.Printf("could not encode %s state: %s\n", topic.name, err)
+		return
+	}
+	client.queue(packet)
+}
+
+func (a *Api) stateSnapshot(name string) (map[string]interface{}, bool) {
+	topic, ok := a.stateTopics[name]
+	if !ok {
+		return nil, false
+	}
+	topic.Lock()
+	defer topic.Unlock()
+	return topic.last, true
+}
+
+// roundState keeps float jitter from the animation from showing up as
+// a change in every single update
+func roundState(f float32) float32 {
+	return float32(math.Round(float64(f)*1e4) / 1e4)
+}
+
+func sampleLayers(a *Api) map[string]interface{} {
+	state := make(map[string]interface{})
+	a.theatre.ReadStages(func() {
+		for stageName, stage := range a.theatre.Stages {
+			for i, l := range stage.Layers {
+				state[fmt.Sprintf("%s/%d", stageName, i)] = LayerGeometry{
+					Source:  l.Name(),
+					X:       roundState(l.Position.X),
+					Y:       roundState(l.Position.Y),
+					W:       roundState(l.Size.X),
+					H:       roundState(l.Size.Y),
+					Opacity: roundState(l.Opacity),
+				}
+			}
+		}
+	})
+	return state
+}
+
+func sampleSources(a *Api) map[string]interface{} {
+	state := make(map[string]interface{})
+	for _, src := range a.theatre.SourceList {
+		frames := src.Frames()
+		state[frames.Name] = SourceState{
+			Ready:            frames.IsReady,
+			FrameAgeMs:       frames.FrameAge.Milliseconds(),
+			DroppedFramesIn:  frames.DroppedFramesIn,
+			DroppedFramesOut: frames.DroppedFramesOut,
+		}
+	}
+	return state
+}
+
+func sampleSinks(a *Api) map[string]interface{} {
+	state := make(map[string]interface{})
+	for stageName, stage := range a.theatre.Stages {
+		frames := stage.Sink.Frames()
+		sinkState := SinkState{
+			Running:          true,
+			DroppedFramesIn:  frames.DroppedFramesIn,
+			DroppedFramesOut: frames.DroppedFramesOut,
+		}
+		if sink, ok := stage.Sink.(*ffmpegsink.FFmpegSink); ok {
+			sinkState.Running = sink.Running()
+			sinkState.Restarts = sink.Restarts()
+		}
+		state[stageName] = sinkState
+	}
+	return state
+}
+
+func sampleTransitions(a *Api) map[string]interface{} {
+	state := make(map[string]interface{})
+	a.theatre.ReadStages(func() {
+		for stageName, stage := range a.theatre.Stages {
+			state[stageName] = TransitionState{
+				Scene:    stage.ActiveScene,
+				Progress: roundState(stage.TransitionProgress()),
+			}
+		}
+	})
+	return state
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+type testStateUpdate struct {
+	Topic   string
+	Full    bool
+	Changed map[string]json.RawMessage
+	Removed []string
+}
+
+// newStateClient makes an API on a test theatre with a client that is
+// only subscribed to topic, without a connection behind it
+func newStateClient(t *testing.T, topic string) (*Api, *wsClient) {
+	a := New(&config.ApiCfg{}, theatretest.New(t, nil))
+	client := newWsClient(nil, anonymous)
+	_ = client.subscribe(defaultWsTopics, false)
+	if err := client.subscribe([]string{topic}, true); err != nil {
+		t.Fatalf("could not subscribe: %s", err)
+	}
+	a.addWsClient(client)
+	return a, client
+}
+
+func nextUpdate(t *testing.T, client *wsClient) *testStateUpdate {
+	select {
+	case packet := <-client.send:
+		update := &testStateUpdate{}
+		if err := json.Unmarshal(packet, update); err != nil {
+			t.Fatalf("could not decode update %s: %s", packet, err)
+		}
+		return update
+	default:
+		t.Fatalf("no update was queued")
+		return nil
+	}
+}
+
+func TestStateDiff(t *testing.T) {
+	a, client := newStateClient(t, TopicLayers)
+	current := map[string]interface{}{"a": 1, "b": 2}
+	topic := &stateTopic{
+		name:   TopicLayers,
+		sample: func(*Api) map[string]interface{} { return current },
+		last:   make(map[string]interface{}),
+	}
+
+	a.updateState(topic)
+	update := nextUpdate(t, client)
+	if update.Topic != TopicLayers || update.Full || len(update.Changed) != 2 || len(update.Removed) != 0 {
+		t.Errorf("expected every entry in the first update, got %+v", update)
+	}
+
+	a.updateState(topic)
+	if len(client.send) != 0 {
+		t.Errorf("an unchanged state was sent again")
+	}
+
+	current = map[string]interface{}{"a": 1, "b": 3, "c": 4}
+	a.updateState(topic)
+	update = nextUpdate(t, client)
+	if len(update.Changed) != 2 || string(update.Changed["b"]) != "3" || string(update.Changed["c"]) != "4" {
+		t.Errorf("expected b and c to change, got %+v", update)
+	}
+
+	current = map[string]interface{}{"c": 4}
+	a.updateState(topic)
+	update = nextUpdate(t, client)
+	slices.Sort(update.Removed)
+	if len(update.Changed) != 0 || !slices.Equal(update.Removed, []string{"a", "b"}) {
+		t.Errorf("expected a and b to be removed, got %+v", update)
+	}
+
+	a.sendFullState(client, topic)
+	update = nextUpdate(t, client)
+	if !update.Full || len(update.Changed) != 1 || string(update.Changed["c"]) != "4" {
+		t.Errorf("expected a full update with only c, got %+v", update)
+	}
+}
+
+func TestStateSnapshot(t *testing.T) {
+	a, client := newStateClient(t, TopicTransitions)
+	topic := a.stateTopics[TopicTransitions]
+	transition := func(update *testStateUpdate) TransitionState {
+		var state TransitionState
+		_ = json.Unmarshal(update.Changed["projector"], &state)
+		return state
+	}
+
+	a.updateState(topic)
+	if state := transition(nextUpdate(t, client)); state != (TransitionState{Scene: "full-slides", Progress: 1}) {
+		t.Errorf("unexpected initial transition state %+v", state)
+	}
+
+	if err := a.theatre.SetScene("projector", "full-cam", false); err != nil {
+		t.Fatalf("could not set scene: %s", err)
+	}
+	a.updateState(topic)
+	if state := transition(nextUpdate(t, client)); state.Scene != "full-cam" {
+		t.Errorf("expected the switch to full-cam, got %+v", state)
+	}
+
+	snapshot, ok := a.stateSnapshot(TopicTransitions)
+	if !ok || snapshot["projector"] != (TransitionState{Scene: "full-cam", Progress: 1}) {
+		t.Errorf("unexpected snapshot %+v", snapshot)
+	}
+	if _, ok := a.stateSnapshot(TopicTally); ok {
+		t.Errorf("tally is not a state topic")
+	}
+}
+
+func TestStateResync(t *testing.T) {
+	a, client := newStateClient(t, TopicTransitions)
+	a.updateState(a.stateTopics[TopicTransitions])
+	nextUpdate(t, client)
+
+	if client.needsResync() {
+		t.Fatalf("resync needed before anything was dropped")
+	}
+	for range wsSendQueueLen + 1 {
+		client.queue([]byte("broadcast"))
+	}
+	if !client.needsResync() {
+		t.Fatalf("overflowing the queue did not ask for a resync")
+	}
+	if client.needsResync() {
+		t.Errorf("a resync was asked for twice")
+	}
+
+	for len(client.send) > 0 {
+		<-client.send
+	}
+	a.resync(client)
+	update := nextUpdate(t, client)
+	if !update.Full || update.Topic != TopicTransitions || len(update.Changed) != 1 {
+		t.Errorf("expected the full transitions state after a resync, got %+v", update)
+	}
+	if len(client.send) != 0 {
+		t.Errorf("topics the client is not subscribed to were resent")
+	}
+}
+
+func TestStateTopicsMatchConfig(t *testing.T) {
+	topics := slices.Sorted(maps.Keys(defaultStateRates))
+	if !slices.Equal(topics, slices.Sorted(slices.Values(config.StateTopics))) {
+		t.Errorf("the state topics %v are not the ones the config accepts rates for, %v", topics, config.StateTopics)
+	}
+}
@@ -4,25 +4,148 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+const (
+	TopicScene  = "scene"
+	TopicStats  = "stats"
+	TopicTally  = "tally"
+	TopicHealth = "health"
+	TopicLogs   = "logs"
+	// TopicSchedule carries the phase and the current and next event of
+	// the scheduler, if one is configured
+	TopicSchedule = "schedule"
+	// TopicMacros carries the progress of running macros
+	TopicMacros = "macros"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(req *http.Request) bool {
+var wsTopics = []string{
+	TopicScene, TopicStats, TopicTally, TopicHealth, TopicLogs, TopicSchedule, TopicMacros,
+	TopicLayers, TopicSources, TopicSinks, TopicTransitions,
+}
+
+// defaultWsTopics are subscribed to on connect, which keeps clients that
+// never send a subscribe command working
+var defaultWsTopics = []string{TopicScene, TopicStats}
+
+const wsSendQueueLen = 64
+
+// wsReplyTimeout is how long a command reply may wait for room in the send
+// queue before the client is given up on
+const wsReplyTimeout = 10 * time.Second
+
+type wsClient struct {
+	conn      *websocket.Conn
+	principal *Principal
+	send      chan []byte
+	done      chan struct{}
+	topics    map[string]bool
+	// dropped is set when the queue overflowed, the client then misses
+	// diffs and gets the full state again once the queue has drained
+	dropped bool
+	sync.Mutex
+}
+
+func newWsClient(conn *websocket.Conn, principal *Principal) *wsClient {
+	c := &wsClient{
+		conn:      conn,
+		principal: principal,
+		send:      make(chan []byte, wsSendQueueLen),
+		done:      make(chan struct{}),
+		topics:    make(map[string]bool),
+	}
+	for _, topic := range defaultWsTopics {
+		c.topics[topic] = true
+	}
+	return c
+}
+
+func (c *wsClient) subscribed(topic string) bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.topics[topic]
+}
+
+func (c *wsClient) subscribe(topics []string, subscribe bool) error {
+	c.Lock()
+	defer c.Unlock()
+	for _, topic := range topics {
+		if !isWsTopic(topic) {
+			return fmt.Errorf("no such topic: %s", topic)
+		}
+	}
+	for _, topic := range topics {
+		c.topics[topic] = subscribe
+	}
+	return nil
+}
+
+// queue never blocks; slow clients lose packets instead of stalling
+// whoever is broadcasting
+func (c *wsClient) queue(packet []byte) {
+	select {
+	case c.send <- packet:
+	default:
+		c.Lock()
+		c.dropped = true
+		c.Unlock()
+	}
+}
+
+// needsResync reports whether packets were dropped since the last call
+func (c *wsClient) needsResync() bool {
+	c.Lock()
+	defer c.Unlock()
+	dropped := c.dropped
+	c.dropped = false
+	return dropped
+}
+
+// reply waits for room in the queue, as a client has to get an answer to
+// every command. A client that does not make room in time is closed.
+func (c *wsClient) reply(packet []byte) bool {
+	timer := time.NewTimer(wsReplyTimeout)
+	defer timer.Stop()
+	select {
+	case c.send <- packet:
 		return true
-	},
+	case <-timer.C:
+		log.Printf("closing websocket of %s, it did not read its replies\n", c.conn.RemoteAddr())
+		_ = c.conn.Close()
+		return false
+	}
 }
 
-// @Summary	Open websocket for realtime status information
+func isWsTopic(topic string) bool {
+	for _, t := range wsTopics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// @Summary	Open websocket for realtime status information and control
+// @Description	Clients can send {"id": ..., "method": ..., "params": {...}} commands, each of which is answered by a packet with "Event": "reply" and the same id.
+// @Description	Methods: set-scene, cue, take, set-transition, lock, unlock, macro-start, macro-stop, macro-pause, macro-resume, subscribe, unsubscribe and snapshot.
+// @Description	Topics: scene, stats, tally, health, logs, schedule and macros. New connections are subscribed to scene and stats.
+// @Description	The layers, sources, sinks and transitions topics send a full StateUpdate on subscribe and diffs after that, at the rate configured in state_rates.
+// @Description	When authentication is enabled, set-scene, cue, take, set-transition, lock, unlock and the macro methods need the operator role. Browsers can pass a token as ?access_token=.
 // @Router		/api/ws [get]
 // @Param		Upgrade	header	string	true	"websocket"
 // @Tags		base
 // @Success	101
 func (a *Api) handleWebsocket(w http.ResponseWriter, req *http.Request) {
-	ws, err := upgrader.Upgrade(w, req, nil)
+	ws, err := a.upgrader.Upgrade(w, req, nil)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("couldn't make websocket: %s", err), 400)
 		return
@@ -33,30 +156,92 @@ func (a *Api) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 			log.Printf("could not close websocket: %s\n", err.Error())
 		}
 	}(ws)
-	a.wsClients[ws] = true
 
-	go a.websocketWriter(ws)
+	client := newWsClient(ws, PrincipalFrom(req.Context()))
+	a.addWsClient(client)
+	defer a.removeWsClient(client)
 
-	a.Stats.WsClients = len(a.wsClients)
+	go a.websocketWriter(client)
 
 	for {
 		_, msg, err := ws.ReadMessage()
 		if err != nil {
-			delete(a.wsClients, ws)
-			a.Stats.WsClients = len(a.wsClients)
 			break
 		}
-		fmt.Printf("Received: %s\n", msg)
+		if !client.reply(a.handleWsCommand(client, msg)) {
+			break
+		}
 	}
 }
 
-func (a *Api) websocketWriter(ws *websocket.Conn) {
+func (a *Api) addWsClient(client *wsClient) {
+	a.wsMutex.Lock()
+	defer a.wsMutex.Unlock()
+	a.wsClients[client] = true
+	a.Stats.WsClients = len(a.wsClients)
+}
 
-	for _, packet := range a.InitialState {
-		if err := ws.WriteMessage(websocket.TextMessage, packet); err != nil {
-			continue
+func (a *Api) removeWsClient(client *wsClient) {
+	a.wsMutex.Lock()
+	defer a.wsMutex.Unlock()
+	delete(a.wsClients, client)
+	a.Stats.WsClients = len(a.wsClients)
+	close(client.done)
+}
+
+func (a *Api) broadcast(topic string, packet []byte) {
+	a.wsMutex.Lock()
+	defer a.wsMutex.Unlock()
+	for client := range a.wsClients {
+		if client.subscribed(topic) {
+			client.queue(packet)
 		}
 	}
+}
+
+func (a *Api) broadcastJSON(topic string, data interface{}) {
+	packet, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("could not encode %s packet: %s\n", topic, err)
+		return
+	}
+	a.broadcast(topic, packet)
+}
+
+// publishEvent sends a theatre event to the websocket clients subscribed
+// to topic and records it in the event log for SSE clients
+func (a *Api) publishEvent(topic string, name string, event interface{}) []byte {
+	packet, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("could not encode %s event: %s\n", name, err)
+		return nil
+	}
+	a.broadcast(topic, packet)
+	a.events.append(name, packet)
+	return packet
+}
+
+// publishScene publishes a set-scene event, unless a later scene change
+// on the same stage got published already
+func (a *Api) publishScene(event theatre.EventDataSetScene) {
+	event.Event = "set-scene"
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	if event.Seq < a.sceneSeqs[event.Stage] {
+		return
+	}
+	a.sceneSeqs[event.Stage] = event.Seq
+	packet := a.publishEvent(TopicScene, event.Event, event)
+	if packet == nil {
+		return
+	}
+	a.InitialState[fmt.Sprintf("active-scene-%s", event.Stage)] = statePacket{event.Event, packet}
+}
+
+func (a *Api) websocketWriter(client *wsClient) {
+	ws := client.conn
+
+	a.queueInitialState(client)
 
 	pingTicker := time.NewTicker(2 * time.Second)
 	defer func() {
@@ -68,13 +253,24 @@ func (a *Api) websocketWriter(ws *websocket.Conn) {
 		}
 	}()
 	timeout := 10 * time.Second
-	for range pingTicker.C {
-		packet, err := json.Marshal(a.Stats)
-
-		if err != nil {
+	for {
+		var packet []byte
+		select {
+		case <-client.done:
 			return
+		case packet = <-client.send:
+		case <-pingTicker.C:
+			if !client.subscribed(TopicStats) {
+				continue
+			}
+			var err error
+			packet, err = json.Marshal(a.Stats)
+			if err != nil {
+				return
+			}
 		}
-		err = ws.SetWriteDeadline(time.Now().Add(timeout))
+
+		err := ws.SetWriteDeadline(time.Now().Add(timeout))
 		if err != nil {
 			log.Printf("could not set write deadline: %s\n", err.Error())
 			return
@@ -82,5 +278,45 @@ func (a *Api) websocketWriter(ws *websocket.Conn) {
 		if err := ws.WriteMessage(websocket.TextMessage, packet); err != nil {
 			return
 		}
+		if len(client.send) == 0 && client.needsResync() {
+			a.resync(client)
+		}
+	}
+}
+
+// statePacket is an event packet along with the name of the event, which
+// SSE clients get to see
+type statePacket struct {
+	name   string
+	packet []byte
+}
+
+// initialState copies the packets of InitialState in the order of their
+// keys, so they can be sent without holding up the event listeners
+func (a *Api) initialState() []statePacket {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	states := make([]statePacket, 0, len(a.InitialState))
+	for _, key := range slices.Sorted(maps.Keys(a.InitialState)) {
+		states = append(states, a.InitialState[key])
+	}
+	return states
+}
+
+func (a *Api) queueInitialState(client *wsClient) {
+	for _, state := range a.initialState() {
+		client.queue(state.packet)
+	}
+}
+
+// resync sends everything a client needs to be up to date again after it
+// lost packets: the active scenes and locks, and the full state of the
+// state topics it is subscribed to
+func (a *Api) resync(client *wsClient) {
+	a.queueInitialState(client)
+	for name, topic := range a.stateTopics {
+		if client.subscribed(name) {
+			a.sendFullState(client, topic)
+		}
 	}
 }
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+var testApiCfg = &config.ApiCfg{
+	Tokens: map[string]*config.ApiTokenCfg{
+		"dashboard": {Token: "view-token", Role: config.RoleViewer},
+		"desk":      {Token: "operate-token", Role: config.RoleOperator},
+	},
+}
+
+// startWsServer serves only the websocket of a fresh API on a test theatre
+func startWsServer(t *testing.T, cfg *config.ApiCfg) (*Api, *theatre.Theatre, *httptest.Server) {
+	th := theatretest.New(t, nil)
+	a := New(cfg, th)
+	srv := httptest.NewServer(a.require(RoleViewer, a.handleWebsocket))
+	t.Cleanup(srv.Close)
+	return a, th, srv
+}
+
+func dialWs(t *testing.T, srv *httptest.Server, token string) *websocket.Conn {
+	conn, resp, err := dialWsFrom(srv, token, "")
+	if err != nil {
+		t.Fatalf("could not open websocket: %s (%v)", err, resp)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func dialWsFrom(srv *httptest.Server, token string, origin string) (*websocket.Conn, *http.Response, error) {
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	return websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header)
+}
+
+// call sends a command and returns its reply, skipping the broadcasts
+// that arrive in between
+func call(t *testing.T, conn *websocket.Conn, id int, method string, params interface{}) WsReply {
+	request := map[string]interface{}{"id": id, "method": method}
+	if params != nil {
+		request["params"] = params
+	}
+	if err := conn.WriteJSON(request); err != nil {
+		t.Fatalf("could not send %s: %s", method, err)
+	}
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, packet, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("no reply to %s: %s", method, err)
+		}
+		var reply WsReply
+		_ = json.Unmarshal(packet, &reply)
+		if reply.Event == "reply" {
+			if string(reply.ID) != mustMarshal(id) {
+				t.Fatalf("reply to %s has id %s, expected %d", method, reply.ID, id)
+			}
+			return reply
+		}
+	}
+}
+
+func mustMarshal(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestWsCommands(t *testing.T) {
+	_, th, srv := startWsServer(t, &config.ApiCfg{})
+	conn := dialWs(t, srv, "")
+
+	reply := call(t, conn, 1, "set-scene", WsSceneParams{Stage: "projector", Scene: "full-cam"})
+	if !reply.Ok {
+		t.Errorf("set-scene failed: %s", reply.Error)
+	}
+	if scene := th.Stages["projector"].ActiveScene; scene != "full-cam" {
+		t.Errorf("set-scene did not switch, got %s", scene)
+	}
+
+	reply = call(t, conn, 2, "set-transition", WsTransitionParams{Stage: "projector", TransitionMs: -1})
+	if reply.Ok || reply.Error != "transition time must be nonnegative" {
+		t.Errorf("expected a negative transition to fail, got %+v", reply)
+	}
+	reply = call(t, conn, 3, "set-scene", nil)
+	if reply.Ok || reply.Error != "missing params" {
+		t.Errorf("expected missing params to fail, got %+v", reply)
+	}
+	reply = call(t, conn, 4, "rewind", nil)
+	if reply.Ok || reply.Error != "no such method: rewind" {
+		t.Errorf("expected an unknown method to fail, got %+v", reply)
+	}
+	reply = call(t, conn, 5, "subscribe", WsTopicParams{Topics: []string{"tally", "nonexistent"}})
+	if reply.Ok || reply.Error != "no such topic: nonexistent" {
+		t.Errorf("expected an unknown topic to fail, got %+v", reply)
+	}
+
+	reply = call(t, conn, 6, "snapshot", WsSnapshotParams{Topic: TopicScene})
+	if !reply.Ok {
+		t.Fatalf("snapshot failed: %s", reply.Error)
+	}
+	var states map[string]StageState
+	_ = json.Unmarshal([]byte(mustMarshal(reply.Result)), &states)
+	if states["projector"].ActiveScene != "full-cam" || states["projector"].TransitionMs != 100 {
+		t.Errorf("unexpected scene snapshot %+v", states)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+		t.Fatalf("could not send garbage: %s", err)
+	}
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var reply WsReply
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("no reply to garbage: %s", err)
+		}
+		if reply.Event == "reply" {
+			if reply.Ok || !strings.HasPrefix(reply.Error, "could not decode request") {
+				t.Errorf("expected garbage to fail to decode, got %+v", reply)
+			}
+			break
+		}
+	}
+}
+
+func TestWsRoles(t *testing.T) {
+	_, th, srv := startWsServer(t, testApiCfg)
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an anonymous websocket to be refused, got %v", err)
+	}
+
+	viewer := dialWs(t, srv, "view-token")
+	for i, method := range []string{"set-scene", "cue", "take", "set-transition", "lock", "unlock", "macro-start"} {
+		reply := call(t, viewer, i, method, WsSceneParams{Stage: "projector", Scene: "full-cam"})
+		if reply.Ok || reply.Error != "operator role required" {
+			t.Errorf("viewer %s: expected a role error, got %+v", method, reply)
+		}
+	}
+	if scene := th.Stages["projector"].ActiveScene; scene != "full-slides" {
+		t.Errorf("a viewer switched the projector to %s", scene)
+	}
+	reply := call(t, viewer, 10, "subscribe", WsTopicParams{Topics: []string{TopicTally}})
+	if !reply.Ok {
+		t.Errorf("viewer subscribe: %s", reply.Error)
+	}
+	reply = call(t, viewer, 11, "snapshot", WsSnapshotParams{Topic: TopicTally})
+	if !reply.Ok {
+		t.Errorf("viewer snapshot: %s", reply.Error)
+	}
+
+	operator := dialWs(t, srv, "operate-token")
+	reply = call(t, operator, 12, "set-scene", WsSceneParams{Stage: "projector", Scene: "full-cam"})
+	if !reply.Ok {
+		t.Errorf("operator set-scene: %s", reply.Error)
+	}
+	entries := th.Audit.Since(time.Time{})
+	if len(entries) != 1 || entries[0].User != "desk" || entries[0].Action != "set-scene" {
+		t.Errorf("expected the operator command to be audited, got %+v", entries)
+	}
+}
+
+func TestWsOrigin(t *testing.T) {
+	cfg := *testApiCfg
+	cfg.AllowedOrigins = []string{"https://dashboard.example.org"}
+	_, _, srv := startWsServer(t, &cfg)
+	_, _, open := startWsServer(t, &config.ApiCfg{})
+
+	for _, c := range []struct {
+		srv    *httptest.Server
+		origin string
+		ok     bool
+	}{
+		{srv, "", true},
+		{srv, srv.URL, true},
+		{srv, "https://dashboard.example.org", true},
+		{srv, "https://evil.example.org", false},
+		{srv, "null", false},
+		{open, "https://evil.example.org", true},
+	} {
+		conn, resp, err := dialWsFrom(c.srv, "view-token", c.origin)
+		if c.ok && err != nil {
+			t.Errorf("origin %q was refused: %s", c.origin, err)
+		}
+		if !c.ok && (err == nil || resp.StatusCode != http.StatusForbidden) {
+			t.Errorf("origin %q was let in", c.origin)
+		}
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}
+}
+
+func TestWsReplyNotDropped(t *testing.T) {
+	client := newWsClient(nil, anonymous)
+	// a queue full of broadcasts must not swallow the reply
+	for range wsSendQueueLen {
+		client.queue([]byte("broadcast"))
+	}
+	replied := make(chan bool)
+	go func() { replied <- client.reply([]byte("reply")) }()
+	select {
+	case <-replied:
+		t.Fatalf("reply went past a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-client.send
+	if !<-replied {
+		t.Errorf("reply failed once there was room")
+	}
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+type WsRequest struct {
+	ID     json.RawMessage
+	Method string
+	Params json.RawMessage
+}
+
+type WsReply struct {
+	Event  string
+	ID     json.RawMessage
+	Ok     bool
+	Error  string      `json:",omitempty"`
+	Result interface{} `json:",omitempty"`
+}
+
+type WsSceneParams struct {
+	Stage      string `example:"projector"`
+	Scene      string `example:"side-by-side"`
+	Transition *bool
+	// Force switches even if someone else holds the lock on the stage
+	Force bool
+}
+
+type WsLockParams struct {
+	Stage string `example:"projector"`
+	Force bool
+}
+
+type WsTransitionParams struct {
+	Stage        string `example:"projector"`
+	TransitionMs int    `example:"1500"`
+}
+
+type WsTopicParams struct {
+	Topics []string `example:"tally,health"`
+}
+
+type WsSnapshotParams struct {
+	Topic string `example:"tally"`
+}
+
+type StageState struct {
+	ActiveScene  string
+	CuedScene    string
+	TransitionMs int64
+	LockedBy     string
+}
+
+type wsCommand struct {
+	role Role
+	run  func(a *Api, client *wsClient, params json.RawMessage) (interface{}, error)
+}
+
+var wsCommands = map[string]wsCommand{
+	"set-scene":      {RoleOperator, wsSetScene},
+	"cue":            {RoleOperator, wsCue},
+	"take":           {RoleOperator, wsTake},
+	"set-transition": {RoleOperator, wsSetTransition},
+	"lock":           {RoleOperator, wsLock},
+	"unlock":         {RoleOperator, wsUnlock},
+	"macro-start":    {RoleOperator, wsMacroCommand("start")},
+	"macro-stop":     {RoleOperator, wsMacroCommand("stop")},
+	"macro-pause":    {RoleOperator, wsMacroCommand("pause")},
+	"macro-resume":   {RoleOperator, wsMacroCommand("resume")},
+	"subscribe":      {RoleViewer, wsSubscribe},
+	"unsubscribe":    {RoleViewer, wsUnsubscribe},
+	"snapshot":       {RoleViewer, wsSnapshot},
+}
+
+func (a *Api) handleWsCommand(client *wsClient, msg []byte) []byte {
+	reply := &WsReply{Event: "reply"}
+
+	var req WsRequest
+	err := json.Unmarshal(msg, &req)
+	if err == nil {
+		reply.ID = req.ID
+		command, ok := wsCommands[req.Method]
+		if !ok {
+			err = fmt.Errorf("no such method: %s", req.Method)
+		} else if client.principal.Role < command.role {
+			err = fmt.Errorf("%s role required", command.role)
+		} else {
+			reply.Result, err = command.run(a, client, req.Params)
+			if command.role >= RoleOperator {
+				a.auditWs(client, req.Method, req.Params, err)
+			}
+		}
+	} else {
+		err = fmt.Errorf("could not decode request: %w", err)
+	}
+
+	if err != nil {
+		reply.Error = err.Error()
+	} else {
+		reply.Ok = true
+	}
+
+	packet, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("could not encode websocket reply: %s\n", err)
+		return []byte(`{"Event":"reply","Ok":false}`)
+	}
+	return packet
+}
+
+func decodeParams(params json.RawMessage, into interface{}) error {
+	if len(params) == 0 {
+		return fmt.Errorf("missing params")
+	}
+	err := json.Unmarshal(params, into)
+	if err != nil {
+		return fmt.Errorf("could not decode params: %w", err)
+	}
+	return nil
+}
+
+func wsSetScene(a *Api, client *wsClient, params json.RawMessage) (interface{}, error) {
+	var p WsSceneParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return nil, a.theatre.SetSceneAs(a.wsCaller(client, p.Force), p.Stage, p.Scene, p.Transition == nil || *p.Transition)
+}
+
+func wsCue(a *Api, _ *wsClient, params json.RawMessage) (interface{}, error) {
+	var p WsSceneParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return nil, a.theatre.Cue(p.Stage, p.Scene)
+}
+
+func wsTake(a *Api, client *wsClient, params json.RawMessage) (interface{}, error) {
+	var p WsSceneParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return nil, a.theatre.TakeAs(a.wsCaller(client, p.Force), p.Stage, p.Transition == nil || *p.Transition)
+}
+
+func wsLock(a *Api, client *wsClient, params json.RawMessage) (interface{}, error) {
+	var p WsLockParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return nil, a.theatre.Lock(a.wsCaller(client, p.Force), p.Stage)
+}
+
+func wsUnlock(a *Api, client *wsClient, params json.RawMessage) (interface{}, error) {
+	var p WsLockParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return nil, a.theatre.Unlock(a.wsCaller(client, p.Force), p.Stage)
+}
+
+func wsSetTransition(a *Api, _ *wsClient, params json.RawMessage) (interface{}, error) {
+	var p WsTransitionParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.TransitionMs < 0 {
+		return nil, fmt.Errorf("transition time must be nonnegative")
+	}
+	return nil, a.theatre.SetTransitionSpeed(p.Stage, time.Duration(p.TransitionMs)*time.Millisecond)
+}
+
+func wsSubscribe(a *Api, client *wsClient, params json.RawMessage) (interface{}, error) {
+	var p WsTopicParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	err := client.subscribe(p.Topics, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range p.Topics {
+		if topic, ok := a.stateTopics[name]; ok {
+			a.sendFullState(client, topic)
+		}
+	}
+	return nil, nil
+}
+
+func wsUnsubscribe(_ *Api, client *wsClient, params json.RawMessage) (interface{}, error) {
+	var p WsTopicParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return nil, client.subscribe(p.Topics, false)
+}
+
+func wsSnapshot(a *Api, _ *wsClient, params json.RawMessage) (interface{}, error) {
+	var p WsSnapshotParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	switch p.Topic {
+	case TopicScene:
+		return a.stageStates(), nil
+	case TopicStats:
+		return a.Stats, nil
+	case TopicTally:
+		return a.theatre.Tally(), nil
+	case TopicHealth:
+		return a.theatre.SourceHealth(), nil
+	case TopicSchedule:
+		return a.theatre.Schedule(), nil
+	case TopicMacros:
+		return a.theatre.MacroStatuses(), nil
+	case "config":
+		return a.buildConfig(), nil
+	default:
+		if state, ok := a.stateSnapshot(p.Topic); ok {
+			return state, nil
+		}
+		return nil, fmt.Errorf("no snapshot available for topic %s", p.Topic)
+	}
+}
+
+func (a *Api) stageStates() map[string]StageState {
+	states := make(map[string]StageState)
+	a.theatre.ReadStages(func() {
+		for name, stage := range a.theatre.Stages {
+			states[name] = StageState{
+				ActiveScene:  stage.ActiveScene,
+				CuedScene:    stage.CuedScene,
+				TransitionMs: stage.TransitionTime.Milliseconds(),
+				LockedBy:     stage.LockedBy,
+			}
+		}
+	})
+	return states
+}
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+)
+
+const defaultKeep = 1000
+
+// Entry is one state-changing action, as written to the JSON-lines file
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Origin is the control surface the action came through: api, kbdctl,
+	// osc or mqtt
+	Origin string `json:"origin"`
+	// Client is the remote address, if the origin has one
+	Client string `json:"client,omitempty"`
+	// User is the API user or token name, if the origin has one
+	User   string                 `json:"user,omitempty"`
+	Action string                 `json:"action"`
+	Params map[string]interface{} `json:"params,omitempty"`
+	Ok     bool                   `json:"ok"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// Log keeps the most recent entries in memory and, if configured, appends
+// every entry to a file. A nil *Log drops everything, which keeps callers
+// simple.
+type Log struct {
+	file    *os.File
+	keep    int
+	entries []*Entry
+	mutex   sync.Mutex
+	logger  *slog.Logger
+}
+
+// Open creates the log; cfg may be nil to only keep entries in memory
+func Open(cfg *config.AuditCfg) (*Log, error) {
+	l := &Log{keep: defaultKeep}
+	l.logger = slog.Default().With(slog.String("module", "audit"))
+	if cfg == nil {
+		return l, nil
+	}
+	if cfg.Keep > 0 {
+		l.keep = cfg.Keep
+	}
+	if cfg.Path != "" {
+		f, err := os.OpenFile(string(cfg.Path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("could not open audit log: %w", err)
+		}
+		l.file = f
+	}
+	return l, nil
+}
+
+// Record adds an entry, filling in the time and the result from err
+func (l *Log) Record(entry Entry, err error) {
+	if l == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Ok = err == nil
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = append(l.entries, &entry)
+	if len(l.entries) > l.keep {
+		l.entries = l.entries[len(l.entries)-l.keep:]
+	}
+	if l.file == nil {
+		return
+	}
+	line, err := json.Marshal(&entry)
+	if err != nil {
+		l.logger.Error(fmt.Sprintf("could not encode audit entry: %s", err))
+		return
+	}
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		l.logger.Error(fmt.Sprintf("could not write audit entry: %s", err))
+	}
+}
+
+// Since returns the entries kept in memory that happened after t, oldest
+// first
+func (l *Log) Since(t time.Time) []*Entry {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	result := []*Entry{}
+	for _, entry := range l.entries {
+		if entry.Time.After(t) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Close flushes the log file to disk and closes it. Entries recorded
+// after that are only kept in memory.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Sync()
+	closeErr := l.file.Close()
+	l.file = nil
+	if err != nil {
+		return fmt.Errorf("could not sync audit log: %w", err)
+	}
+	return closeErr
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(&config.AuditCfg{Path: config.CfgPath(path), Keep: 2})
+	if err != nil {
+		t.Fatalf("could not open log: %s", err)
+	}
+
+	start := time.Now()
+	l.Record(Entry{Origin: "api", User: "alice", Action: "set-scene", Params: map[string]interface{}{"scene": "a"}}, nil)
+	l.Record(Entry{Origin: "kbdctl", Action: "set-scene"}, errors.New("no such scene: b"))
+	l.Record(Entry{Origin: "api", Action: "kill"}, nil)
+	err = l.Close()
+	if err != nil {
+		t.Fatalf("could not close log: %s", err)
+	}
+	recent := l.Since(start.Add(-time.Second))
+	if len(recent) != 2 || recent[0].Origin != "kbdctl" || recent[1].Action != "kill" {
+		t.Errorf("expected the last 2 entries in memory, got %+v", recent)
+	}
+	if recent[0].Ok || recent[0].Error != "no such scene: b" || !recent[1].Ok {
+		t.Errorf("results not recorded: %+v", recent)
+	}
+	if len(l.Since(time.Now().Add(time.Second))) != 0 {
+		t.Errorf("expected no entries from the future")
+	}
+
+	// entries that come in after shutdown are only kept in memory
+	l.Record(Entry{Origin: "api", Action: "cue"}, nil)
+	if recent := l.Since(start.Add(-time.Second)); recent[1].Action != "cue" {
+		t.Errorf("late entry was not kept in memory: %+v", recent)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("closing twice: %s", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("could not open log file: %s", err)
+	}
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Fatalf("invalid line %q: %s", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 || entries[0].User != "alice" || entries[0].Params["scene"] != "a" {
+		t.Errorf("expected every entry in the file, got %+v", entries)
+	}
+
+	// reopening appends instead of truncating
+	l, err = Open(&config.AuditCfg{Path: config.CfgPath(path)})
+	if err != nil {
+		t.Fatalf("could not reopen log: %s", err)
+	}
+	l.Record(Entry{Origin: "api", Action: "kill"}, nil)
+	_ = l.Close()
+	data, _ := os.ReadFile(path)
+	if countLines(data) != 4 {
+		t.Errorf("expected 4 lines after reopening, got %d", countLines(data))
+	}
+}
+
+func countLines(data []byte) int {
+	n := 0
+	for _, b := range data {
+		if b == '\n' {
+			n++
+		}
+	}
+	return n
+}
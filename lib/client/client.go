@@ -0,0 +1,391 @@
+// Package client talks to the fazantix web API. It only depends on the wire
+// format, so it can be used from tools that do not link the mixer itself.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+type Client struct {
+	BaseURL *url.URL
+	HTTP    *http.Client
+	// Token is sent as a bearer token if set. For basic auth, put the
+	// credentials in the URL instead.
+	Token string
+}
+
+type Config struct {
+	Stages []StageInfo `json:"stages"`
+	Scenes []SceneInfo `json:"scenes"`
+}
+
+type StageInfo struct {
+	Name       string
+	PreviewFor string
+	LockedBy   string
+}
+
+type SceneInfo struct {
+	Code  string
+	Tag   string
+	Label string
+}
+
+type StageState struct {
+	ActiveScene  string
+	CuedScene    string
+	TransitionMs int64
+	LockedBy     string
+}
+
+type SourceHealth struct {
+	Name       string
+	Ready      bool
+	FrameAgeMs int64
+}
+
+type MacroStatus struct {
+	Name  string
+	Label string
+	// State is idle, running or paused
+	State string
+	Step  int
+	Steps int
+}
+
+type Stats struct {
+	TextureUpload      uint64  `json:"texture_upload"`
+	TextureUploadAvgGb float64 `json:"texture_upload_avg_gb"`
+	Uptime             float64 `json:"uptime"`
+	FPS                uint64  `json:"fps"`
+	WsClients          int     `json:"ws_clients"`
+	// UploadMs is the average time each source took to upload a frame to
+	// the GPU over the last second
+	UploadMs map[string]float64 `json:"upload_ms"`
+}
+
+// Error is returned when the API answers with an error status or a failed
+// websocket reply
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Status == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.Status, http.StatusText(e.Status))
+}
+
+// New creates a client for the API at baseURL, as in http://localhost:8000
+func New(baseURL string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s: %w", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("url %s must be http or https", baseURL)
+	}
+	return &Client{BaseURL: u, HTTP: http.DefaultClient}, nil
+}
+
+func (c *Client) url(path string) string {
+	path, query, _ := strings.Cut(path, "?")
+	u := c.BaseURL.JoinPath(path)
+	u.RawQuery = query
+	u.User = nil
+	return u.String()
+}
+
+func (c *Client) authHeader() http.Header {
+	header := http.Header{}
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	} else if c.BaseURL.User != nil {
+		password, _ := c.BaseURL.User.Password()
+		credentials := c.BaseURL.User.Username() + ":" + password
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	return header
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.authHeader()
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	return data, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, into interface{}) error {
+	data, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, into)
+	if err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+	return nil
+}
+
+// Config returns the stages and scenes
+func (c *Client) Config(ctx context.Context) (*Config, error) {
+	cfg := &Config{}
+	return cfg, c.getJSON(ctx, "/api/config", cfg)
+}
+
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	stats := &Stats{}
+	return stats, c.getJSON(ctx, "/api/stats", stats)
+}
+
+// SetScene starts a transition to the scene on the stage
+func (c *Client) SetScene(ctx context.Context, stage string, scene string) error {
+	_, err := c.do(ctx, http.MethodPost, "/api/scene", map[string]string{
+		"Stage": stage,
+		"Scene": scene,
+	})
+	return err
+}
+
+// Still fetches the current frame of a source, or of a sink if sink is
+// set, encoded as format (jpeg or png)
+func (c *Client) Still(ctx context.Context, name string, sink bool, format string) ([]byte, error) {
+	kind := "source"
+	if sink {
+		kind = "sink"
+	}
+	path := "/api/media/" + kind + "/" + url.PathEscape(name)
+	if format != "" {
+		path += "/" + format
+	}
+	return c.do(ctx, http.MethodGet, path, nil)
+}
+
+// Stages returns the active scene, cued scene and transition time of
+// every stage
+func (c *Client) Stages(ctx context.Context) (map[string]StageState, error) {
+	var states map[string]StageState
+	return states, c.Call(ctx, "snapshot", map[string]string{"Topic": "scene"}, &states)
+}
+
+// Sources returns the readiness and frame age of every source
+func (c *Client) Sources(ctx context.Context) ([]SourceHealth, error) {
+	var health []SourceHealth
+	return health, c.Call(ctx, "snapshot", map[string]string{"Topic": "health"}, &health)
+}
+
+func (c *Client) SetTransition(ctx context.Context, stage string, transitionMs int) error {
+	return c.Call(ctx, "set-transition", map[string]interface{}{
+		"Stage":        stage,
+		"TransitionMs": transitionMs,
+	}, nil)
+}
+
+func (c *Client) Cue(ctx context.Context, stage string, scene string) error {
+	return c.Call(ctx, "cue", map[string]string{"Stage": stage, "Scene": scene}, nil)
+}
+
+func (c *Client) Take(ctx context.Context, stage string) error {
+	return c.Call(ctx, "take", map[string]string{"Stage": stage}, nil)
+}
+
+func (c *Client) Macros(ctx context.Context) ([]MacroStatus, error) {
+	var macros []MacroStatus
+	return macros, c.getJSON(ctx, "/api/macros", &macros)
+}
+
+// Macro starts, stops, pauses or resumes a macro
+func (c *Client) Macro(ctx context.Context, name string, action string) error {
+	_, err := c.do(ctx, http.MethodPost, "/api/macro/"+url.PathEscape(name)+"/"+url.PathEscape(action), nil)
+	return err
+}
+
+// Lock refuses scene changes on the stage from anyone but this client's
+// user (or address, if the API has no authentication). With force, it
+// takes over the lock of someone else.
+func (c *Client) Lock(ctx context.Context, stage string, force bool) error {
+	_, err := c.do(ctx, http.MethodPost, lockPath(stage, force), nil)
+	return err
+}
+
+// Unlock releases the lock on the stage. With force, it also releases the
+// lock of someone else.
+func (c *Client) Unlock(ctx context.Context, stage string, force bool) error {
+	_, err := c.do(ctx, http.MethodDelete, lockPath(stage, force), nil)
+	return err
+}
+
+func lockPath(stage string, force bool) string {
+	path := "/api/stage/" + url.PathEscape(stage) + "/lock"
+	if force {
+		path += "?force=true"
+	}
+	return path
+}
+
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	u := *c.BaseURL
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/ws"
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), c.authHeader())
+	if err != nil {
+		if resp != nil {
+			return nil, &Error{Status: resp.StatusCode, Message: "could not open websocket"}
+		}
+		return nil, fmt.Errorf("could not open websocket: %w", err)
+	}
+	return conn, nil
+}
+
+// closeOnDone closes the connection when ctx is cancelled, so blocking
+// reads return
+func closeOnDone(ctx context.Context, conn *websocket.Conn) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+type wsReply struct {
+	Event  string
+	ID     json.RawMessage
+	Ok     bool
+	Error  string
+	Result json.RawMessage
+}
+
+// Call runs a websocket command and decodes its result into result,
+// which may be nil
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	err = conn.WriteJSON(map[string]interface{}{
+		"ID":     1,
+		"Method": method,
+		"Params": params,
+	})
+	if err != nil {
+		return fmt.Errorf("could not send request: %w", err)
+	}
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("could not read reply: %w", err)
+		}
+		var reply wsReply
+		if json.Unmarshal(msg, &reply) != nil || reply.Event != "reply" || string(reply.ID) != "1" {
+			continue
+		}
+		if !reply.Ok {
+			return &Error{Message: reply.Error}
+		}
+		if result == nil || len(reply.Result) == 0 {
+			return nil
+		}
+		err = json.Unmarshal(reply.Result, result)
+		if err != nil {
+			return fmt.Errorf("could not decode result: %w", err)
+		}
+		return nil
+	}
+}
+
+// Tail calls fn for every packet sent over the websocket until ctx is
+// cancelled or fn returns an error. If topics is not empty, they are
+// subscribed to on top of the default ones.
+func (c *Client) Tail(ctx context.Context, topics []string, fn func(packet json.RawMessage) error) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	if len(topics) > 0 {
+		err = conn.WriteJSON(map[string]interface{}{
+			"Method": "subscribe",
+			"Params": map[string][]string{"Topics": topics},
+		})
+		if err != nil {
+			return fmt.Errorf("could not subscribe: %w", err)
+		}
+	}
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("could not read event: %w", err)
+		}
+		var reply wsReply
+		if json.Unmarshal(msg, &reply) == nil && reply.Event == "reply" {
+			if !reply.Ok {
+				return &Error{Message: reply.Error}
+			}
+			continue
+		}
+		err = fn(msg)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/api"
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+func startApi(t *testing.T) *Client {
+	return startApiWith(t, &config.ApiCfg{})
+}
+
+func startApiWith(t *testing.T, apiCfg *config.ApiCfg) *Client {
+	th := theatretest.Build(t, theatretest.Config())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not find a free port: %s", err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	apiCfg.Bind = addr
+	a := api.New(apiCfg, th)
+	go func() { _ = a.Serve() }()
+	err = th.ResetToDefaultScenes()
+	if err != nil {
+		t.Fatalf("could not set default scenes: %s", err)
+	}
+
+	c, err := New("http://" + addr)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+	for range 50 {
+		var conn net.Conn
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			return c
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("api did not come up: %s", err)
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	c := startApi(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cfg, err := c.Config(ctx)
+	if err != nil {
+		t.Fatalf("could not get config: %s", err)
+	}
+	if len(cfg.Stages) != 1 || cfg.Stages[0].Name != "projector" || len(cfg.Scenes) != 2 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	sources, err := c.Sources(ctx)
+	if err != nil {
+		t.Fatalf("could not get sources: %s", err)
+	}
+	if len(sources) != 2 {
+		t.Errorf("expected 2 sources, got %+v", sources)
+	}
+
+	err = c.SetScene(ctx, "projector", "full-cam")
+	if err != nil {
+		t.Fatalf("could not set scene: %s", err)
+	}
+	err = c.SetTransition(ctx, "projector", 250)
+	if err != nil {
+		t.Fatalf("could not set transition: %s", err)
+	}
+	stages, err := c.Stages(ctx)
+	if err != nil {
+		t.Fatalf("could not get stages: %s", err)
+	}
+	if s := stages["projector"]; s.ActiveScene != "full-cam" || s.TransitionMs != 250 {
+		t.Errorf("unexpected stage state %+v", s)
+	}
+
+	var apiErr *Error
+	err = c.SetScene(ctx, "projector", "nonexistent")
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Errorf("expected a bad request error, got %v", err)
+	}
+	err = c.Take(ctx, "projector")
+	if !errors.As(err, &apiErr) || apiErr.Status != 0 {
+		t.Errorf("expected a websocket error, got %v", err)
+	}
+	_, err = c.Still(ctx, "nonexistent", true, "png")
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestTail(t *testing.T) {
+	c := startApi(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- c.Tail(ctx, []string{"tally"}, func(packet json.RawMessage) error {
+			var event struct{ Event string }
+			_ = json.Unmarshal(packet, &event)
+			if event.Event == "tally" {
+				return errors.New("done")
+			}
+			return nil
+		})
+	}()
+
+	// keep switching until the tail has subscribed and sees a tally update
+	scenes := []string{"full-cam", "full-slides"}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		select {
+		case err := <-done:
+			if err == nil || err.Error() != "done" {
+				t.Errorf("tail stopped with %v", err)
+			}
+			return
+		case <-ticker.C:
+			err := c.SetScene(ctx, "projector", scenes[i%2])
+			if err != nil {
+				t.Fatalf("could not set scene: %s", err)
+			}
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	base := startApiWith(t, &config.ApiCfg{
+		Users: map[string]*config.ApiUserCfg{
+			"alice": {Password: "hunter2", Role: config.RoleAdmin},
+		},
+		Tokens: map[string]*config.ApiTokenCfg{
+			"dashboard": {Token: "view-token", Role: config.RoleViewer},
+			"desk":      {Token: "operate-token", Role: config.RoleOperator},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	as := func(token string) *Client {
+		c := *base
+		c.Token = token
+		return &c
+	}
+	status := func(err error) int {
+		var apiErr *Error
+		if err == nil {
+			return http.StatusOK
+		}
+		if errors.As(err, &apiErr) {
+			return apiErr.Status
+		}
+		t.Fatalf("unexpected error %s", err)
+		return 0
+	}
+
+	anonymous := as("")
+	_, err := anonymous.Config(ctx)
+	if status(err) != http.StatusUnauthorized {
+		t.Errorf("anonymous config: expected 401, got %v", err)
+	}
+	_, err = anonymous.Stages(ctx)
+	if status(err) != http.StatusUnauthorized {
+		t.Errorf("anonymous websocket: expected 401, got %v", err)
+	}
+	_, err = as("wrong").Config(ctx)
+	if status(err) != http.StatusUnauthorized {
+		t.Errorf("wrong token: expected 401, got %v", err)
+	}
+
+	viewer := as("view-token")
+	_, err = viewer.Stages(ctx)
+	if err != nil {
+		t.Errorf("viewer snapshot: %s", err)
+	}
+	err = viewer.SetScene(ctx, "projector", "full-cam")
+	if status(err) != http.StatusForbidden {
+		t.Errorf("viewer set scene: expected 403, got %v", err)
+	}
+	err = viewer.SetTransition(ctx, "projector", 10)
+	if err == nil || err.Error() != "operator role required" {
+		t.Errorf("viewer websocket command: expected role error, got %v", err)
+	}
+	_, err = viewer.do(ctx, http.MethodGet, "/metrics", nil)
+	if err != nil {
+		t.Errorf("viewer metrics: %s", err)
+	}
+	_, err = anonymous.do(ctx, http.MethodGet, "/metrics", nil)
+	if status(err) != http.StatusUnauthorized {
+		t.Errorf("anonymous metrics: expected 401, got %v", err)
+	}
+
+	operator := as("operate-token")
+	err = operator.SetScene(ctx, "projector", "full-cam")
+	if err != nil {
+		t.Errorf("operator set scene: %s", err)
+	}
+	err = operator.SetTransition(ctx, "projector", 10)
+	if err != nil {
+		t.Errorf("operator websocket command: %s", err)
+	}
+	_, err = operator.do(ctx, http.MethodPut, "/api/media/source/slides", nil)
+	if status(err) != http.StatusForbidden {
+		t.Errorf("operator media upload: expected 403, got %v", err)
+	}
+	data, err := operator.do(ctx, http.MethodGet, "/api/audit?since=1h", nil)
+	if err != nil {
+		t.Fatalf("could not get audit log: %s", err)
+	}
+	var entries []struct {
+		User   string
+		Action string
+		Ok     bool
+	}
+	_ = json.Unmarshal(data, &entries)
+	if len(entries) != 2 || entries[0].Action != "set-scene" || entries[1].Action != "set-transition" || entries[0].User != "desk" {
+		t.Errorf("unexpected audit entries %s", data)
+	}
+	_, err = viewer.do(ctx, http.MethodGet, "/api/audit", nil)
+	if status(err) != http.StatusForbidden {
+		t.Errorf("viewer audit: expected 403, got %v", err)
+	}
+
+	u := *base.BaseURL
+	u.User = url.UserPassword("alice", "hunter2")
+	admin := &Client{BaseURL: &u, HTTP: http.DefaultClient}
+	_, err = admin.do(ctx, http.MethodPut, "/api/media/source/slides", nil)
+	if status(err) != http.StatusBadRequest {
+		t.Errorf("admin media upload of nothing: expected 400, got %v", err)
+	}
+	u.User = url.UserPassword("alice", "wrong")
+	_, err = admin.Config(ctx)
+	if status(err) != http.StatusUnauthorized {
+		t.Errorf("wrong password: expected 401, got %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	base := startApiWith(t, &config.ApiCfg{
+		Tokens: map[string]*config.ApiTokenCfg{
+			"director": {Token: "director-token", Role: config.RoleOperator},
+			"desk":     {Token: "desk-token", Role: config.RoleOperator},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	director := *base
+	director.Token = "director-token"
+	desk := *base
+	desk.Token = "desk-token"
+
+	err := director.Lock(ctx, "projector", false)
+	if err != nil {
+		t.Fatalf("could not lock: %s", err)
+	}
+	cfg, err := desk.Config(ctx)
+	if err != nil {
+		t.Fatalf("could not get config: %s", err)
+	}
+	if len(cfg.Stages) != 1 || cfg.Stages[0].LockedBy != "director" {
+		t.Errorf("expected the projector to be locked by director in the config, got %+v", cfg.Stages)
+	}
+	stages, err := desk.Stages(ctx)
+	if err != nil {
+		t.Fatalf("could not get stages: %s", err)
+	}
+	if stages["projector"].LockedBy != "director" {
+		t.Errorf("expected the projector to be locked by director over the websocket, got %+v", stages["projector"])
+	}
+
+	var apiErr *Error
+	err = desk.SetScene(ctx, "projector", "full-cam")
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict {
+		t.Errorf("set scene on a locked stage: expected 409, got %v", err)
+	}
+	err = desk.Cue(ctx, "projector", "full-cam")
+	if err != nil {
+		t.Errorf("cue on a locked stage: %s", err)
+	}
+	err = desk.Take(ctx, "projector")
+	if err == nil || err.Error() != "stage projector is locked by director" {
+		t.Errorf("take on a locked stage: expected lock error, got %v", err)
+	}
+	err = desk.Unlock(ctx, "projector", false)
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict {
+		t.Errorf("unlock of someone else's lock: expected 409, got %v", err)
+	}
+
+	err = director.SetScene(ctx, "projector", "full-cam")
+	if err != nil {
+		t.Errorf("set scene by the holder: %s", err)
+	}
+	_, err = desk.do(ctx, http.MethodPost, "/api/scene/projector/full-slides?force=true", nil)
+	if err != nil {
+		t.Errorf("forced set scene: %s", err)
+	}
+
+	err = desk.Lock(ctx, "projector", true)
+	if err != nil {
+		t.Fatalf("could not take over the lock: %s", err)
+	}
+	err = desk.Unlock(ctx, "projector", false)
+	if err != nil {
+		t.Fatalf("could not unlock: %s", err)
+	}
+	err = director.SetScene(ctx, "projector", "full-cam")
+	if err != nil {
+		t.Errorf("set scene after unlock: %s", err)
+	}
+}
@@ -2,8 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/fosdem/fazantix/lib/encdec"
@@ -22,6 +25,15 @@ type Config struct {
 	BGColour       string               `yaml:"bg_colour"`
 	BaseFramerate  float64              `yaml:"base_framerate"`
 	Api            *ApiCfg
+	Osc            *OscCfg
+	Mqtt           *MqttCfg
+	Webhooks       map[string]*WebhookCfg
+	Audit          *AuditCfg
+	State          *StateCfg
+	Schedule       *ScheduleCfg
+	Macros         map[string]*MacroCfg
+	Automation     map[string]*AutomationRuleCfg
+	Scripts        map[string]*ScriptCfg
 }
 
 func Parse(filename string) (*Config, error) {
@@ -94,6 +106,102 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.Api != nil {
+		err = c.Api.Validate()
+		if err != nil {
+			return fmt.Errorf("api config is invalid: %w", err)
+		}
+	}
+
+	if c.Osc != nil {
+		err = c.Osc.Validate()
+		if err != nil {
+			return fmt.Errorf("osc config is invalid: %w", err)
+		}
+	}
+
+	if c.Mqtt != nil {
+		err = c.Mqtt.Validate()
+		if err != nil {
+			return fmt.Errorf("mqtt config is invalid: %w", err)
+		}
+	}
+
+	if c.State != nil {
+		err = c.State.Validate()
+		if err != nil {
+			return fmt.Errorf("state config is invalid: %w", err)
+		}
+	}
+
+	if c.Schedule != nil {
+		err = c.Schedule.Validate()
+		if err != nil {
+			return fmt.Errorf("schedule config is invalid: %w", err)
+		}
+		for stageName, scenes := range c.Schedule.Stages {
+			if _, ok := c.Stages[stageName]; !ok {
+				return fmt.Errorf("schedule refers to sink %s, which does not exist", stageName)
+			}
+			for _, scene := range []string{scenes.Holding, scenes.Talk, scenes.Ending} {
+				if scene != "" && !c.sceneExists(scene) {
+					return fmt.Errorf("schedule refers to scene %s, which does not exist", scene)
+				}
+			}
+		}
+	}
+
+	for k, v := range c.Macros {
+		err = v.Validate()
+		if err != nil {
+			return fmt.Errorf("macro %s is invalid: %w", k, err)
+		}
+		for i, step := range v.Steps {
+			for _, sink := range slices.Concat(step.Sinks, v.Sinks) {
+				if _, ok := c.Stages[sink]; !ok {
+					return fmt.Errorf("macro %s step %d refers to sink %s, which does not exist", k, i, sink)
+				}
+			}
+			if step.Scene != "" && !c.sceneExists(step.Scene) {
+				return fmt.Errorf("macro %s step %d refers to scene %s, which does not exist", k, i, step.Scene)
+			}
+		}
+	}
+
+	for k, v := range c.Automation {
+		err = v.Validate()
+		if err != nil {
+			return fmt.Errorf("automation rule %s is invalid: %w", k, err)
+		}
+		if _, ok := c.Sources[v.Source]; !ok {
+			return fmt.Errorf("automation rule %s watches source %s, which does not exist", k, v.Source)
+		}
+		for _, sink := range v.Sinks {
+			if _, ok := c.Stages[sink]; !ok {
+				return fmt.Errorf("automation rule %s refers to sink %s, which does not exist", k, sink)
+			}
+		}
+		for _, scene := range []string{v.Scene, v.RecoverScene} {
+			if scene != "" && !c.sceneExists(scene) {
+				return fmt.Errorf("automation rule %s refers to scene %s, which does not exist", k, scene)
+			}
+		}
+	}
+
+	for k, v := range c.Scripts {
+		err = v.Validate()
+		if err != nil {
+			return fmt.Errorf("script %s is invalid: %w", k, err)
+		}
+	}
+
+	for k, v := range c.Webhooks {
+		err = v.Validate()
+		if err != nil {
+			return fmt.Errorf("webhook %s is invalid: %w", k, err)
+		}
+	}
+
 	if c.FallbackColour == "" {
 		return fmt.Errorf("please set fallback_colour in the config")
 	}
@@ -185,11 +293,21 @@ type FFmpegSourceCfg struct {
 	encdec.FrameCfg `yaml:"frames"`
 	Cmd             string
 	LogFrameInfo    bool `yaml:"log_frame_info"`
+	// PixFmt is the raw pixel format ffmpeg writes, yuv422p by default
+	PixFmt string `yaml:"pix_fmt"`
+	// BlockMs is how long to wait for a free frame before dropping one
+	// from ffmpeg, by default it is dropped right away
+	BlockMs          int `yaml:"block_ms"`
+	encdec.ColourCfg `yaml:",inline"`
 }
 
 type FFmpegSinkCfg struct {
 	Cmd          string
 	LogFrameInfo bool `yaml:"log_frame_info"`
+	// PixFmt is the raw pixel format ffmpeg gets, rgba by default. YUV
+	// formats are converted on the GPU.
+	PixFmt           string `yaml:"pix_fmt"`
+	encdec.ColourCfg `yaml:",inline"`
 }
 
 type OmtSourceCfg struct {
@@ -225,6 +343,7 @@ type V4LSourceCfg struct {
 	Fmt                string
 	NumFramesInWriting int    `yaml:"num_frames_in_writing"`
 	FPS                uint32 `yaml:"fps"`
+	encdec.ColourCfg   `yaml:",inline"`
 }
 
 func (s *SourceCfg) UnmarshalYAML(b []byte) error {
@@ -286,6 +405,342 @@ func (s *StageCfg) UnmarshalYAML(b []byte) error {
 type ApiCfg struct {
 	Bind           string
 	EnableProfiler bool `yaml:"enable_profiler"`
+	// StateRates overrides how many times per second each websocket
+	// state topic (layers, sources, sinks, transitions) is sampled
+	StateRates map[string]float64 `yaml:"state_rates"`
+	// Users can log in with basic auth, keyed by username
+	Users map[string]*ApiUserCfg
+	// Tokens can be sent as a bearer token, keyed by a name that shows up
+	// in the logs
+	Tokens map[string]*ApiTokenCfg
+	// AllowedOrigins are the origins, as in https://dashboard.example.org,
+	// of web pages elsewhere that may open the websocket when users or
+	// tokens are configured. Pages served by the API itself always can.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	Tls            *ApiTlsCfg
+}
+
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var Roles = []string{RoleViewer, RoleOperator, RoleAdmin}
+
+// StateTopics are the websocket state topics StateRates can be set for
+var StateTopics = []string{"layers", "sources", "sinks", "transitions"}
+
+type ApiUserCfg struct {
+	Password string
+	Role     string
+}
+
+type ApiTokenCfg struct {
+	Token string
+	Role  string
+}
+
+type ApiTlsCfg struct {
+	Cert CfgPath
+	Key  CfgPath
+}
+
+func (a *ApiCfg) Validate() error {
+	for topic, rate := range a.StateRates {
+		if !slices.Contains(StateTopics, topic) {
+			return fmt.Errorf("unknown state topic %s in state_rates, must be one of %s", topic, strings.Join(StateTopics, ", "))
+		}
+		if rate <= 0 {
+			return fmt.Errorf("state rate for %s must be positive", topic)
+		}
+	}
+	for name, user := range a.Users {
+		if user.Password == "" {
+			return fmt.Errorf("user %s has no password", name)
+		}
+		if !slices.Contains(Roles, user.Role) {
+			return fmt.Errorf("user %s has unknown role %s, must be one of %s", name, user.Role, strings.Join(Roles, ", "))
+		}
+	}
+	for name, token := range a.Tokens {
+		if token.Token == "" {
+			return fmt.Errorf("token %s is empty", name)
+		}
+		if !slices.Contains(Roles, token.Role) {
+			return fmt.Errorf("token %s has unknown role %s, must be one of %s", name, token.Role, strings.Join(Roles, ", "))
+		}
+	}
+	if a.Tls != nil && (a.Tls.Cert == "" || a.Tls.Key == "") {
+		return fmt.Errorf("tls needs both a cert and a key")
+	}
+	return nil
+}
+
+type OscCfg struct {
+	Bind string
+	// Feedback lists host:port pairs that get sent the active scene and
+	// tally state whenever it changes
+	Feedback []string
+}
+
+func (o *OscCfg) Validate() error {
+	if o.Bind == "" {
+		return fmt.Errorf("bind address must be specified")
+	}
+	for _, peer := range o.Feedback {
+		_, _, err := net.SplitHostPort(peer)
+		if err != nil {
+			return fmt.Errorf("invalid feedback peer %s: %w", peer, err)
+		}
+	}
+	return nil
+}
+
+type MqttCfg struct {
+	// Broker is the URL of the broker, as in tcp://localhost:1883
+	Broker string
+	// ClientID defaults to fazantix
+	ClientID string `yaml:"client_id"`
+	Username string
+	Password string
+	// TopicPrefix is prepended to every topic that gets published,
+	// defaults to fazantix
+	TopicPrefix string `yaml:"topic_prefix"`
+	// CommandTopic is where commands are accepted, defaults to
+	// {topic_prefix}/command
+	CommandTopic string `yaml:"command_topic"`
+	// StatsIntervalMs is how often the stats get published, defaults to
+	// 5000
+	StatsIntervalMs int `yaml:"stats_interval_ms"`
+	// MaxReconnectIntervalMs caps the backoff between reconnection
+	// attempts when the broker goes away, defaults to 10000
+	MaxReconnectIntervalMs int `yaml:"max_reconnect_interval_ms"`
+}
+
+func (m *MqttCfg) Validate() error {
+	if m.Broker == "" {
+		return fmt.Errorf("broker must be specified")
+	}
+	if m.StatsIntervalMs < 0 || m.MaxReconnectIntervalMs < 0 {
+		return fmt.Errorf("intervals must be nonnegative")
+	}
+	for _, topic := range []string{m.TopicPrefix, m.CommandTopic} {
+		if strings.ContainsAny(topic, "#+") {
+			return fmt.Errorf("topic %s must not contain wildcards", topic)
+		}
+	}
+	return nil
+}
+
+type AuditCfg struct {
+	// Path is the JSON-lines file every state-changing action gets
+	// appended to
+	Path CfgPath
+	// Keep is how many entries are kept in memory for /api/audit,
+	// defaults to 1000
+	Keep int
+}
+
+// sceneExists also knows about the scenes made for sources with make_scene
+func (c *Config) sceneExists(name string) bool {
+	if _, ok := c.Scenes[name]; ok {
+		return true
+	}
+	source, ok := c.Sources[name]
+	return ok && source.MakeScene
+}
+
+type ScheduleCfg struct {
+	// Path is a Pentabarf XML or iCalendar (.ics) file
+	Path CfgPath
+	// Room only keeps the events in this room, as named in the schedule.
+	// All events are used if it is empty.
+	Room string
+	// EndingMs is how long the ending scene is shown after an event before
+	// switching to the holding scene, defaults to 60000
+	EndingMs int `yaml:"ending_ms"`
+	// Stages lists the scenes to switch every scheduled stage to
+	Stages map[string]*ScheduleScenesCfg `yaml:"sinks"`
+}
+
+type ScheduleScenesCfg struct {
+	// Holding is shown between events
+	Holding string
+	// Talk is shown while an event is running
+	Talk string
+	// Ending is shown right after an event, it is skipped if empty
+	Ending string
+}
+
+func (s *ScheduleCfg) Validate() error {
+	if s.Path == "" {
+		return fmt.Errorf("path must be specified")
+	}
+	if s.EndingMs < 0 {
+		return fmt.Errorf("ending_ms must be nonnegative")
+	}
+	if len(s.Stages) < 1 {
+		return fmt.Errorf("at least one sink should be scheduled")
+	}
+	for name, scenes := range s.Stages {
+		if scenes == nil || scenes.Holding == "" || scenes.Talk == "" {
+			return fmt.Errorf("sink %s needs a holding and a talk scene", name)
+		}
+	}
+	return nil
+}
+
+type MacroCfg struct {
+	Label string
+	// Sinks are the stages the steps act on, unless a step lists its own
+	Sinks []string
+	Steps []*MacroStepCfg
+}
+
+// MacroStepCfg either waits, or sets the transition time and/or switches
+// the scene of its sinks, in that order
+type MacroStepCfg struct {
+	Sinks        []string
+	Scene        string
+	TransitionMs *int `yaml:"transition_ms"`
+	WaitMs       int  `yaml:"wait_ms"`
+}
+
+func (m *MacroCfg) Validate() error {
+	if len(m.Steps) < 1 {
+		return fmt.Errorf("at least one step should be defined")
+	}
+	for i, step := range m.Steps {
+		if step == nil {
+			return fmt.Errorf("step %d is empty", i)
+		}
+		changes := step.Scene != "" || step.TransitionMs != nil
+		if step.WaitMs < 0 {
+			return fmt.Errorf("step %d: wait_ms must be nonnegative", i)
+		}
+		if step.WaitMs > 0 && changes {
+			return fmt.Errorf("step %d: a step either waits or changes the scene or transition", i)
+		}
+		if step.WaitMs == 0 && !changes {
+			return fmt.Errorf("step %d does nothing", i)
+		}
+		if step.TransitionMs != nil && *step.TransitionMs <= 0 {
+			return fmt.Errorf("step %d: transition_ms must be positive", i)
+		}
+		if changes && len(step.Sinks) == 0 && len(m.Sinks) == 0 {
+			return fmt.Errorf("step %d has no sinks, and the macro has none either", i)
+		}
+	}
+	return nil
+}
+
+// AutomationRuleCfg switches sinks to another scene while a source has
+// lost its signal
+type AutomationRuleCfg struct {
+	// Source is the source that is watched
+	Source string
+	// Sinks are switched when the source is lost
+	Sinks []string
+	// Scene is switched to when the source is lost
+	Scene string
+	// RecoverScene is switched to when the source is back, by default the
+	// scene that was showing before
+	RecoverScene string `yaml:"recover_scene"`
+	// MaxFrameAgeMs also counts the source as lost when its last frame is
+	// older than this, even if it is still considered ready
+	MaxFrameAgeMs int `yaml:"max_frame_age_ms"`
+	// LostMs is how long the source has to be lost before switching
+	LostMs int `yaml:"lost_ms"`
+	// RecoverMs is how long the source has to be back before switching
+	// back
+	RecoverMs int `yaml:"recover_ms"`
+}
+
+func (a *AutomationRuleCfg) Validate() error {
+	if a.Source == "" {
+		return fmt.Errorf("source must be specified")
+	}
+	if len(a.Sinks) < 1 {
+		return fmt.Errorf("at least one sink should be specified")
+	}
+	if a.Scene == "" {
+		return fmt.Errorf("scene must be specified")
+	}
+	if a.MaxFrameAgeMs < 0 || a.LostMs < 0 || a.RecoverMs < 0 {
+		return fmt.Errorf("durations must be nonnegative")
+	}
+	return nil
+}
+
+type ScriptCfg struct {
+	// Path is the Lua file to run, it is reloaded when it changes
+	Path CfgPath
+	// TimeoutMs is how long a single call into the script may take before
+	// it is aborted, defaults to 1000
+	TimeoutMs int `yaml:"timeout_ms"`
+}
+
+func (s *ScriptCfg) Validate() error {
+	if s.Path == "" {
+		return fmt.Errorf("path must be specified")
+	}
+	if s.TimeoutMs < 0 {
+		return fmt.Errorf("timeout_ms must be nonnegative")
+	}
+	return nil
+}
+
+type StateCfg struct {
+	// Path is the JSON file the active scenes and transition times are
+	// kept in. Images uploaded through the API are stored as PNG files in
+	// a directory next to it, named after it with .images appended.
+	Path CfgPath
+}
+
+func (s *StateCfg) Validate() error {
+	if s.Path == "" {
+		return fmt.Errorf("path must be specified")
+	}
+	return nil
+}
+
+// WebhookEvents lists the event names a webhook can be filtered on
+var WebhookEvents = []string{"scene", "source-lost", "source-recovered", "crash", "shutdown"}
+
+type WebhookCfg struct {
+	Url string
+	// Secret is used to sign every payload with HMAC-SHA256, the
+	// signature is sent in the X-Fazantix-Signature header
+	Secret string
+	// Events limits which events get posted, all of them by default
+	Events []string
+	// QueueSize is how many undelivered payloads are kept before new ones
+	// get dropped, defaults to 64
+	QueueSize int `yaml:"queue_size"`
+	// Retries is how many more times a failed delivery is attempted,
+	// defaults to 3
+	Retries   *int
+	TimeoutMs int `yaml:"timeout_ms"`
+}
+
+func (w *WebhookCfg) Validate() error {
+	u, err := url.Parse(w.Url)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url must be http or https")
+	}
+	for _, event := range w.Events {
+		if !slices.Contains(WebhookEvents, event) {
+			return fmt.Errorf("unknown event %s, must be one of %s", event, strings.Join(WebhookEvents, ", "))
+		}
+	}
+	if w.QueueSize < 0 || w.TimeoutMs < 0 || (w.Retries != nil && *w.Retries < 0) {
+		return fmt.Errorf("queue_size, retries and timeout_ms must be nonnegative")
+	}
+	return nil
 }
 
 func (s *StageCfg) Validate() error {
@@ -307,6 +762,11 @@ func (s *StageCfg) Validate() error {
 	if err != nil {
 		return fmt.Errorf("invalid frame config: %w", err)
 	}
+	if sc, ok := s.SinkCfg.(*FFmpegSinkCfg); ok && sc.PixFmt != "" && sc.PixFmt != "rgba" {
+		if s.Width%2 != 0 || s.Height%2 != 0 {
+			return fmt.Errorf("%s output needs an even width and height", sc.PixFmt)
+		}
+	}
 	return s.SinkCfg.Validate()
 }
 
@@ -350,6 +810,17 @@ func (s *FFmpegSourceCfg) Validate() error {
 	if s.Cmd == "" {
 		return fmt.Errorf("ffmpeg cmd must be specified")
 	}
+	if s.BlockMs < 0 {
+		return fmt.Errorf("block_ms must not be negative")
+	}
+	if s.PixFmt != "" {
+		if _, err := encdec.ParsePixFmt(s.PixFmt); err != nil {
+			return err
+		}
+	}
+	if err := s.ColourCfg.Validate(); err != nil {
+		return err
+	}
 	return s.FrameCfg.Validate(false)
 }
 
@@ -357,7 +828,12 @@ func (s *FFmpegSinkCfg) Validate() error {
 	if s.Cmd == "" {
 		return fmt.Errorf("ffmpeg cmd must be specified")
 	}
-	return nil
+	switch s.PixFmt {
+	case "", "rgba", "yuv420p", "nv12", "yuv422p":
+	default:
+		return fmt.Errorf("unsupported ffmpeg sink pix_fmt %s, expected rgba, yuv420p, nv12 or yuv422p", s.PixFmt)
+	}
+	return s.ColourCfg.Validate()
 }
 
 func (s *OmtSourceCfg) Validate() error {
@@ -404,5 +880,5 @@ func (s *V4LSourceCfg) Validate() error {
 	if s.FPS == 0 {
 		return fmt.Errorf("v4l sources must have an fps field")
 	}
-	return nil
+	return s.ColourCfg.Validate()
 }
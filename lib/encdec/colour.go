@@ -0,0 +1,204 @@
+package encdec
+
+import "fmt"
+
+// Colorspace is the matrix that turns YUV samples of a source into RGB.
+// The zero value is what sources used before it could be picked.
+type Colorspace int
+
+const (
+	BT601 Colorspace = iota
+	BT709
+	BT2020
+)
+
+// ColorRange tells whether YUV samples use all of their values or leave
+// headroom and footroom, as broadcast video does
+type ColorRange int
+
+const (
+	FullRange ColorRange = iota
+	LimitedRange
+)
+
+// ColourCfg is how a YUV source wants its samples converted to RGB
+type ColourCfg struct {
+	// Colorspace is bt601 (the default), bt709 or bt2020
+	Colorspace string
+	// Range is full (the default) or limited
+	Range string
+}
+
+func (c *ColourCfg) Validate() error {
+	_, _, err := c.Parse()
+	return err
+}
+
+func (c *ColourCfg) Parse() (Colorspace, ColorRange, error) {
+	var colorspace Colorspace
+	switch c.Colorspace {
+	case "", "bt601":
+		colorspace = BT601
+	case "bt709":
+		colorspace = BT709
+	case "bt2020":
+		colorspace = BT2020
+	default:
+		return 0, 0, fmt.Errorf("unknown colorspace %s, expected bt601, bt709 or bt2020", c.Colorspace)
+	}
+
+	var colorRange ColorRange
+	switch c.Range {
+	case "", "full":
+		colorRange = FullRange
+	case "limited":
+		colorRange = LimitedRange
+	default:
+		return 0, 0, fmt.Errorf("unknown range %s, expected limited or full", c.Range)
+	}
+	return colorspace, colorRange, nil
+}
+
+// coefficients are the luma weights of red and blue, and how samples are
+// scaled and offset from 0 to 1, for the colorspace and range of a frame
+func (i *FrameInfo) coefficients() (kr, kb, yScale, yOffset, cScale, cOffset float32) {
+	switch i.Colorspace {
+	case BT709:
+		kr, kb = 0.2126, 0.0722
+	case BT2020:
+		kr, kb = 0.2627, 0.0593
+	default:
+		kr, kb = 0.299, 0.114
+	}
+
+	yScale, yOffset, cScale, cOffset = 1, 0, 1, 0.5
+	if i.Range == LimitedRange {
+		yScale, yOffset = 255.0/219.0, 16.0/255.0
+		cScale, cOffset = 255.0/224.0, 128.0/255.0
+	}
+	return
+}
+
+// YUVMatrix returns the column-major 4x4 matrix that turns (Y, Cb, Cr, 1),
+// with every sample between 0 and 1, into (R, G, B, 1). Gray frames have
+// no chroma, so theirs only looks at Y.
+func (i *FrameInfo) YUVMatrix() [16]float32 {
+	kr, kb, yScale, yOffset, cScale, cOffset := i.coefficients()
+	kg := 1 - kr - kb
+
+	crToR := 2 * (1 - kr)
+	cbToG := 2 * kb * (1 - kb) / kg
+	crToG := 2 * kr * (1 - kr) / kg
+	cbToB := 2 * (1 - kb)
+	y := yScale * yOffset
+	c := cScale * cOffset
+
+	if i.FrameType == GrayFrames {
+		return [16]float32{
+			yScale, yScale, yScale, 0,
+			0, 0, 0, 0,
+			0, 0, 0, 0,
+			-y, -y, -y, 1,
+		}
+	}
+
+	return [16]float32{
+		// Y
+		yScale, yScale, yScale, 0,
+		// Cb
+		0, -cbToG * cScale, cbToB * cScale, 0,
+		// Cr
+		crToR * cScale, -crToG * cScale, 0, 0,
+		// 1
+		-y - crToR*c, -y + (cbToG+crToG)*c, -y - cbToB*c, 1,
+	}
+}
+
+// RGBMatrix is the inverse of YUVMatrix, it turns (R, G, B, 1) into
+// (Y, Cb, Cr, 1)
+func (i *FrameInfo) RGBMatrix() [16]float32 {
+	kr, kb, yScale, yOffset, cScale, cOffset := i.coefficients()
+	kg := 1 - kr - kb
+
+	ys := 1 / yScale
+	cbs := 1 / cScale / (2 * (1 - kb))
+	crs := 1 / cScale / (2 * (1 - kr))
+
+	return [16]float32{
+		// R
+		kr * ys, -kr * cbs, (1 - kr) * crs, 0,
+		// G
+		kg * ys, -kg * cbs, -kg * crs, 0,
+		// B
+		kb * ys, (1 - kb) * cbs, -kb * crs, 0,
+		// 1
+		yOffset, cOffset, cOffset, 1,
+	}
+}
+
+// ChromaSubsampling is how many pixels across and down share their chroma
+// in a YUV frame type
+func ChromaSubsampling(t FrameType) (int, int) {
+	switch t {
+	case YUV420Frames, NV12Frames, P010Frames:
+		return 2, 2
+	case YUV422Frames, YUV422pFrames, YUV422p10Frames, V210Frames:
+		return 2, 1
+	default:
+		return 1, 1
+	}
+}
+
+// RGBAToYUV converts rgba pixels into the planes of a yuv420p, nv12 or
+// yuv422p frame the same way sinks do it on the GPU. Chroma is the average
+// of the pixels that share it.
+func RGBAToYUV(rgba []byte, info *FrameInfo, into []byte) {
+	m := info.RGBMatrix()
+	w, h := info.Width, info.Height
+	sx, sy := ChromaSubsampling(info.FrameType)
+	cw, ch := w/sx, h/sy
+
+	convert := func(r, g, b float32, component int) uint8 {
+		rgb := [4]float32{r, g, b, 1}
+		var v float32
+		for k := range 4 {
+			v += m[k*4+component] * rgb[k]
+		}
+		return uint8(min(max(v*255+0.5, 0), 255))
+	}
+	pixel := func(x, y int) (float32, float32, float32) {
+		i := (min(y, h-1)*w + min(x, w-1)) * 4
+		return float32(rgba[i]) / 255, float32(rgba[i+1]) / 255, float32(rgba[i+2]) / 255
+	}
+
+	for y := range h {
+		for x := range w {
+			r, g, b := pixel(x, y)
+			into[y*w+x] = convert(r, g, b, 0)
+		}
+	}
+
+	chroma := into[w*h:]
+	for cy := range ch {
+		for cx := range cw {
+			var r, g, b float32
+			for dy := range sy {
+				for dx := range sx {
+					pr, pg, pb := pixel(cx*sx+dx, cy*sy+dy)
+					r, g, b = r+pr, g+pg, b+pb
+				}
+			}
+			n := float32(sx * sy)
+			r, g, b = r/n, g/n, b/n
+
+			c := cy*cw + cx
+			if info.FrameType == NV12Frames {
+				chroma[c*2] = convert(r, g, b, 1)
+				chroma[c*2+1] = convert(r, g, b, 2)
+			} else {
+				chroma[c] = convert(r, g, b, 1)
+				chroma[cw*ch+c] = convert(r, g, b, 2)
+			}
+		}
+	}
+}
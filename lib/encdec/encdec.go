@@ -16,6 +16,12 @@ const (
 	RGBAFrames
 	BGRAFrames
 	RGBFrames
+	YUV420Frames
+	NV12Frames
+	GrayFrames
+	P010Frames
+	YUV422p10Frames
+	V210Frames
 )
 
 type Frame struct {
@@ -101,6 +107,116 @@ func PrepareYUYV422p(into *Frame) error {
 	return nil
 }
 
+func PrepareYUV420p(into *Frame) error {
+	into.Clear()
+
+	numPixels := into.Width * into.Height
+	numChromaPixels := numPixels / 4 // the chroma planes are half-sized both ways
+
+	into.MakeTexture(numPixels, into.Width, into.Height)
+	into.MakeTexture(numChromaPixels, into.Width/2, into.Height/2)
+	into.MakeTexture(numChromaPixels, into.Width/2, into.Height/2)
+
+	return nil
+}
+
+// PrepareNV12 sets up a full-size luma plane followed by a plane of
+// interleaved Cb and Cr samples at half the size both ways
+func PrepareNV12(into *Frame) error {
+	into.Clear()
+
+	numPixels := into.Width * into.Height
+
+	into.MakeTexture(numPixels, into.Width, into.Height)
+	into.MakeTexture(numPixels/2, into.Width/2, into.Height/2)
+
+	return nil
+}
+
+func PrepareGray(into *Frame) error {
+	into.Clear()
+	into.MakeTexture(into.Width*into.Height, into.Width, into.Height)
+	return nil
+}
+
+// PrepareP010 is PrepareNV12 with 16-bit little-endian samples that have
+// their 10 bits in the high end
+func PrepareP010(into *Frame) error {
+	into.Clear()
+
+	numPixels := into.Width * into.Height
+
+	into.MakeTexture(numPixels*2, into.Width, into.Height)
+	into.MakeTexture(numPixels, into.Width/2, into.Height/2)
+
+	return nil
+}
+
+// PrepareYUV422p10 is PrepareYUYV422p with 16-bit little-endian samples
+// that have their 10 bits in the low end
+func PrepareYUV422p10(into *Frame) error {
+	into.Clear()
+
+	numPixels := into.Width * into.Height
+
+	into.MakeTexture(numPixels*2, into.Width, into.Height)
+	into.MakeTexture(numPixels, into.Width/2, into.Height)
+	into.MakeTexture(numPixels, into.Width/2, into.Height)
+
+	return nil
+}
+
+// PrepareV210 sets up a single plane of packed 10-bit 4:2:2, in which
+// every 6 pixels take up 4 32-bit words and every line is padded to
+// V210Stride. The plane is as wide as the words that hold pixels.
+func PrepareV210(into *Frame) error {
+	into.Clear()
+
+	stride := V210Stride(into.Width)
+	into.MakeTexture(stride*into.Height, V210Words(into.Width), into.Height)
+
+	return nil
+}
+
+// V210Stride is the number of bytes in a line of v210, which holds groups
+// of 48 pixels in 128 bytes
+func V210Stride(width int) int {
+	return (width + 47) / 48 * 128
+}
+
+// V210Words is the number of 32-bit words in a line of v210 that hold
+// pixels, as opposed to padding
+func V210Words(width int) int {
+	return (width + 5) / 6 * 4
+}
+
+// Prepare sets up the planes of a frame that is about to be filled with raw
+// data of its type
+func Prepare(into *Frame) error {
+	switch into.Type {
+	case YUV422Frames:
+		return PrepareYUYV422p(into)
+	case YUV422pFrames:
+		return PrepareYUYV(into)
+	case YUV420Frames:
+		return PrepareYUV420p(into)
+	case NV12Frames:
+		return PrepareNV12(into)
+	case GrayFrames:
+		return PrepareGray(into)
+	case P010Frames:
+		return PrepareP010(into)
+	case YUV422p10Frames:
+		return PrepareYUV422p10(into)
+	case V210Frames:
+		return PrepareV210(into)
+	case RGBAFrames, BGRAFrames:
+		return PrepareRGBA(into)
+	default:
+		return fmt.Errorf("cannot prepare frames of type %s", into.Type)
+	}
+}
+
 func PrepareYUYV(into *Frame) error {
 	into.Clear()
 
@@ -162,6 +278,18 @@ func (f FrameType) String() string {
 		return "RGBA"
 	case RGBFrames:
 		return "RGB"
+	case YUV420Frames:
+		return "YUV420"
+	case NV12Frames:
+		return "NV12"
+	case GrayFrames:
+		return "GRAY"
+	case P010Frames:
+		return "P010"
+	case YUV422p10Frames:
+		return "YUV422P10"
+	case V210Frames:
+		return "V210"
 	default:
 		panic("unknown frame type")
 	}
@@ -179,7 +307,46 @@ func ParseFrameType(name string) FrameType {
 		return BGRAFrames
 	case "RGB":
 		return RGBFrames
+	case "YUV420":
+		return YUV420Frames
+	case "NV12":
+		return NV12Frames
+	case "GRAY":
+		return GrayFrames
+	case "P010":
+		return P010Frames
+	case "YUV422P10":
+		return YUV422p10Frames
+	case "V210":
+		return V210Frames
 	default:
 		panic(fmt.Sprintf("%s is not a frame type", name))
 	}
 }
+
+// ParsePixFmt returns the frame type for raw video in one of ffmpeg's
+// pixel formats
+func ParsePixFmt(pixFmt string) (FrameType, error) {
+	switch pixFmt {
+	case "yuv422p":
+		return YUV422Frames, nil
+	case "yuv420p":
+		return YUV420Frames, nil
+	case "nv12":
+		return NV12Frames, nil
+	case "rgba":
+		return RGBAFrames, nil
+	case "bgra":
+		return BGRAFrames, nil
+	case "gray":
+		return GrayFrames, nil
+	case "p010le":
+		return P010Frames, nil
+	case "yuv422p10le":
+		return YUV422p10Frames, nil
+	case "v210":
+		return V210Frames, nil
+	default:
+		return 0, fmt.Errorf("unsupported pixel format %s, expected yuv422p, yuv420p, nv12, rgba, bgra, gray, p010le, yuv422p10le or v210", pixFmt)
+	}
+}
@@ -0,0 +1,175 @@
+package encdec
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func newTestFrame(t *testing.T, pixFmt string, width int, height int) *Frame {
+	frameType, err := ParsePixFmt(pixFmt)
+	if err != nil {
+		t.Fatalf("could not parse %s: %s", pixFmt, err)
+	}
+	alloc := &DumbFrameAllocator{}
+	frame := alloc.NewFrame(&FrameInfo{
+		FrameCfg:  FrameCfg{Width: width, Height: height},
+		FrameType: frameType,
+	})
+	err = Prepare(frame)
+	if err != nil {
+		t.Fatalf("could not prepare %s frame: %s", pixFmt, err)
+	}
+	return frame
+}
+
+func TestPrepareFillsFrame(t *testing.T) {
+	planes := map[string]int{
+		"yuv422p":     3,
+		"yuv420p":     3,
+		"nv12":        2,
+		"rgba":        1,
+		"bgra":        1,
+		"gray":        1,
+		"p010le":      2,
+		"yuv422p10le": 3,
+		"v210":        1,
+	}
+	for pixFmt, numPlanes := range planes {
+		frame := newTestFrame(t, pixFmt, 8, 4)
+		if frame.NumTextures != numPlanes {
+			t.Errorf("%s: expected %d planes, got %d", pixFmt, numPlanes, frame.NumTextures)
+		}
+		if frame.LastOffset != len(frame.Data) {
+			t.Errorf("%s: planes cover %d of %d bytes", pixFmt, frame.LastOffset, len(frame.Data))
+		}
+	}
+	if _, err := ParsePixFmt("yuv444p"); err == nil {
+		t.Errorf("expected an error for an unsupported pixel format")
+	}
+}
+
+func TestFrameToImage(t *testing.T) {
+	frame := newTestFrame(t, "nv12", 4, 2)
+	for i := range frame.Data {
+		frame.Data[i] = byte(i)
+	}
+	img, err := FrameToImage(frame, nil)
+	if err != nil {
+		t.Fatalf("could not convert nv12 frame: %s", err)
+	}
+	ycbcr := img.(*image.YCbCr)
+	if ycbcr.Y[7] != 7 || ycbcr.Cb[1] != 10 || ycbcr.Cr[1] != 11 {
+		t.Errorf("nv12 planes were not split, got Y %v Cb %v Cr %v", ycbcr.Y, ycbcr.Cb, ycbcr.Cr)
+	}
+
+	frame = newTestFrame(t, "bgra", 1, 1)
+	copy(frame.Data, []byte{1, 2, 3, 4})
+	img, err = FrameToImage(frame, nil)
+	if err != nil {
+		t.Fatalf("could not convert bgra frame: %s", err)
+	}
+	if got := img.At(0, 0); got != (color.NRGBA{R: 3, G: 2, B: 1, A: 4}) {
+		t.Errorf("bgra was not swapped, got %v", got)
+	}
+
+	// 10-bit samples come out as their 8 most significant bits
+	frame = newTestFrame(t, "v210", 6, 1)
+	for w := range 4 {
+		var word uint32
+		for c := range 3 {
+			word |= uint32(10+w*3+c) << 2 << (c * 10)
+		}
+		binary.LittleEndian.PutUint32(frame.Data[w*4:], word)
+	}
+	img, err = FrameToImage(frame, nil)
+	if err != nil {
+		t.Fatalf("could not convert v210 frame: %s", err)
+	}
+	ycbcr = img.(*image.YCbCr)
+	if string(ycbcr.Y) != string([]byte{11, 13, 15, 17, 19, 21}) ||
+		string(ycbcr.Cb) != string([]byte{10, 14, 18}) ||
+		string(ycbcr.Cr) != string([]byte{12, 16, 20}) {
+		t.Errorf("v210 was not unpacked, got Y %v Cb %v Cr %v", ycbcr.Y, ycbcr.Cb, ycbcr.Cr)
+	}
+}
+
+func TestColourConversion(t *testing.T) {
+	// limited range black and white become the ends of full range
+	frame := newTestFrame(t, "gray", 2, 1)
+	copy(frame.Data, []byte{16, 235})
+	img, err := FrameToImage(frame, &FrameInfo{FrameType: GrayFrames, Range: LimitedRange})
+	if err != nil {
+		t.Fatalf("could not convert gray frame: %s", err)
+	}
+	if black, white := img.At(0, 0), img.At(1, 0); black != (color.NRGBA{A: 255}) ||
+		white != (color.NRGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("limited range was not stretched, got %v and %v", black, white)
+	}
+
+	// pure red in bt709 limited range
+	frame = newTestFrame(t, "yuv422p", 2, 1)
+	copy(frame.Data, []byte{63, 63, 102, 240})
+	info := &FrameInfo{FrameType: YUV422Frames, Colorspace: BT709, Range: LimitedRange}
+	img, err = FrameToImage(frame, info)
+	if err != nil {
+		t.Fatalf("could not convert yuv422p frame: %s", err)
+	}
+	r, g, b, _ := img.At(0, 0).RGBA()
+	if r>>8 < 253 || g>>8 > 2 || b>>8 > 2 {
+		t.Errorf("expected red, got %d %d %d", r>>8, g>>8, b>>8)
+	}
+
+	for _, cfg := range []ColourCfg{{Colorspace: "bt470"}, {Range: "tv"}} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestRGBAToYUV(t *testing.T) {
+	// 2x2 blocks of one colour survive the chroma subsampling
+	colours := []color.NRGBA{
+		{R: 255, A: 255},
+		{G: 255, A: 255},
+		{B: 255, A: 255},
+		{R: 255, G: 255, B: 255, A: 255},
+	}
+	width, height := len(colours)*2, 2
+	rgba := make([]byte, width*height*4)
+	for y := range height {
+		for x := range width {
+			c := colours[x/2]
+			copy(rgba[(y*width+x)*4:], []byte{c.R, c.G, c.B, c.A})
+		}
+	}
+
+	for _, pixFmt := range []string{"yuv420p", "nv12", "yuv422p"} {
+		for _, colorRange := range []ColorRange{FullRange, LimitedRange} {
+			frame := newTestFrame(t, pixFmt, width, height)
+			info := &FrameInfo{
+				FrameCfg:   FrameCfg{Width: width, Height: height},
+				FrameType:  frame.Type,
+				Colorspace: BT709,
+				Range:      colorRange,
+			}
+			RGBAToYUV(rgba, info, frame.Data)
+
+			img, err := FrameToImage(frame, info)
+			if err != nil {
+				t.Fatalf("could not convert %s frame: %s", pixFmt, err)
+			}
+			for i, want := range colours {
+				r, g, b, _ := img.At(i*2, 1).RGBA()
+				got := [3]int{int(r >> 8), int(g >> 8), int(b >> 8)}
+				for c, v := range []uint8{want.R, want.G, want.B} {
+					if diff := got[c] - int(v); diff < -2 || diff > 2 {
+						t.Errorf("%s range %d: expected %v, got %v", pixFmt, colorRange, want, got)
+						break
+					}
+				}
+			}
+		}
+	}
+}
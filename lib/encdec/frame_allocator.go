@@ -19,6 +19,10 @@ type FrameInfo struct {
 	FrameType FrameType
 	PixFmt    []uint8
 	Swizzle   SwizzleConfig
+
+	// Colorspace and Range only matter for YUV frames
+	Colorspace Colorspace
+	Range      ColorRange
 }
 
 type FrameAllocator interface {
@@ -114,6 +118,12 @@ func (f *FrameCfg) Validate(isWindow bool) error {
 	return nil
 }
 
+// FrameSize is the number of bytes in a frame described by info
+func FrameSize(info *FrameInfo) int {
+	n, _, _ := calcFrameSize(info)
+	return n
+}
+
 func calcFrameSize(info *FrameInfo) (int, int, int) {
 	t := info.FrameType
 	w := info.Width
@@ -130,6 +140,16 @@ func calcFrameSize(info *FrameInfo) (int, int, int) {
 		return w * h * 4, w, h
 	case RGBFrames:
 		return w * h * 3, w, h
+	case YUV420Frames, NV12Frames:
+		return w * h * 3 / 2, w, h
+	case GrayFrames:
+		return w * h, w, h
+	case P010Frames:
+		return w * h * 3, w, h
+	case YUV422p10Frames:
+		return w * h * 4, w, h
+	case V210Frames:
+		return V210Stride(w) * h, w, h
 	default:
 		panic("unknown frame type")
 	}
@@ -0,0 +1,178 @@
+package encdec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+)
+
+// FrameToImage copies a frame into an image, for encoding it as a still.
+// YUV frames are converted with the colorspace and range in info, image
+// only knows about full range bt601 itself.
+func FrameToImage(frame *Frame, info *FrameInfo) (image.Image, error) {
+	img, err := frameToImage(frame)
+	if err != nil || info == nil {
+		return img, err
+	}
+	if info.Colorspace == BT601 && info.Range == FullRange {
+		return img, nil
+	}
+	return convertYUV(img, info), nil
+}
+
+// convertYUV turns a YCbCr or gray image into RGB through the matrix the
+// shader uses for the same frame
+func convertYUV(img image.Image, info *FrameInfo) image.Image {
+	var sample func(x, y int) (uint8, uint8, uint8)
+	switch img := img.(type) {
+	case *image.YCbCr:
+		sample = func(x, y int) (uint8, uint8, uint8) {
+			c := img.YCbCrAt(x, y)
+			return c.Y, c.Cb, c.Cr
+		}
+	case *image.Gray:
+		sample = func(x, y int) (uint8, uint8, uint8) {
+			return img.GrayAt(x, y).Y, 0, 0
+		}
+	default:
+		return img
+	}
+
+	m := info.YUVMatrix()
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			Y, Cb, Cr := sample(x, y)
+			yuv := [4]float32{float32(Y) / 255, float32(Cb) / 255, float32(Cr) / 255, 1}
+			i := out.PixOffset(x, y)
+			for c := range 3 {
+				var v float32
+				for k := range 4 {
+					v += m[k*4+c] * yuv[k]
+				}
+				out.Pix[i+c] = uint8(min(max(v*255+0.5, 0), 255))
+			}
+			out.Pix[i+3] = 255
+		}
+	}
+	return out
+}
+
+func frameToImage(frame *Frame) (image.Image, error) {
+	bounds := image.Rectangle{
+		Min: image.Point{},
+		Max: image.Point{X: frame.Width, Y: frame.Height},
+	}
+
+	switch frame.Type {
+	case RGBAFrames:
+		img := image.NewNRGBA(bounds)
+		copy(img.Pix, frame.Data)
+		return img, nil
+	case BGRAFrames:
+		img := image.NewNRGBA(bounds)
+		for i := range min(len(frame.Data), len(img.Pix)) / 4 {
+			img.Pix[i*4+0] = frame.Data[i*4+2]
+			img.Pix[i*4+1] = frame.Data[i*4+1]
+			img.Pix[i*4+2] = frame.Data[i*4+0]
+			img.Pix[i*4+3] = frame.Data[i*4+3]
+		}
+		return img, nil
+	case RGBFrames:
+		img := image.NewNRGBA(bounds)
+		for i := range len(frame.Data) / 3 {
+			img.Pix[i*4+0] = frame.Data[i*3+0]
+			img.Pix[i*4+1] = frame.Data[i*3+1]
+			img.Pix[i*4+2] = frame.Data[i*3+2]
+			img.Pix[i*4+3] = 255
+		}
+		return img, nil
+	case GrayFrames:
+		img := image.NewGray(bounds)
+		textureY, _, _ := frame.Texture(0)
+		copy(img.Pix, textureY)
+		return img, nil
+	case YUV422Frames:
+		img := image.NewYCbCr(bounds, image.YCbCrSubsampleRatio422)
+		textureY, _, _ := frame.Texture(0)
+		textureCb, _, _ := frame.Texture(1)
+		textureCr, _, _ := frame.Texture(2)
+		copy(img.Y, textureY)
+		copy(img.Cb, textureCb)
+		copy(img.Cr, textureCr)
+		return img, nil
+	case YUV420Frames:
+		img := image.NewYCbCr(bounds, image.YCbCrSubsampleRatio420)
+		textureY, _, _ := frame.Texture(0)
+		textureCb, _, _ := frame.Texture(1)
+		textureCr, _, _ := frame.Texture(2)
+		copy(img.Y, textureY)
+		copy(img.Cb, textureCb)
+		copy(img.Cr, textureCr)
+		return img, nil
+	case NV12Frames:
+		img := image.NewYCbCr(bounds, image.YCbCrSubsampleRatio420)
+		textureY, _, _ := frame.Texture(0)
+		textureCbCr, _, _ := frame.Texture(1)
+		copy(img.Y, textureY)
+		for i := range min(len(textureCbCr)/2, len(img.Cb)) {
+			img.Cb[i] = textureCbCr[i*2]
+			img.Cr[i] = textureCbCr[i*2+1]
+		}
+		return img, nil
+	case P010Frames:
+		img := image.NewYCbCr(bounds, image.YCbCrSubsampleRatio420)
+		textureY, _, _ := frame.Texture(0)
+		textureCbCr, _, _ := frame.Texture(1)
+		for i := range min(len(textureY)/2, len(img.Y)) {
+			img.Y[i] = textureY[i*2+1]
+		}
+		for i := range min(len(textureCbCr)/4, len(img.Cb)) {
+			img.Cb[i] = textureCbCr[i*4+1]
+			img.Cr[i] = textureCbCr[i*4+3]
+		}
+		return img, nil
+	case YUV422p10Frames:
+		img := image.NewYCbCr(bounds, image.YCbCrSubsampleRatio422)
+		planes := [][]byte{img.Y, img.Cb, img.Cr}
+		for p, plane := range planes {
+			texture, _, _ := frame.Texture(p)
+			for i := range min(len(texture)/2, len(plane)) {
+				plane[i] = uint8(binary.LittleEndian.Uint16(texture[i*2:]) >> 2)
+			}
+		}
+		return img, nil
+	case V210Frames:
+		img := image.NewYCbCr(bounds, image.YCbCrSubsampleRatio422)
+		stride := V210Stride(frame.Width)
+		for y := range frame.Height {
+			line := frame.Data[y*stride : (y+1)*stride]
+			for group := 0; group*6 < frame.Width; group++ {
+				var samples [12]uint8
+				for w := range 4 {
+					word := binary.LittleEndian.Uint32(line[group*16+w*4:])
+					samples[w*3+0] = uint8(word >> 2)
+					samples[w*3+1] = uint8(word >> 12)
+					samples[w*3+2] = uint8(word >> 22)
+				}
+				// Cb Y Cr Y, Cb Y Cr Y, Cb Y Cr Y
+				for pair := range 3 {
+					x := group*6 + pair*2
+					if x >= frame.Width {
+						break
+					}
+					img.Cb[img.COffset(x, y)] = samples[pair*4+0]
+					img.Y[img.YOffset(x, y)] = samples[pair*4+1]
+					img.Cr[img.COffset(x, y)] = samples[pair*4+2]
+					if x+1 < frame.Width {
+						img.Y[img.YOffset(x+1, y)] = samples[pair*4+3]
+					}
+				}
+			}
+		}
+		return img, nil
+	default:
+		return nil, fmt.Errorf("unhandled frame type %s", frame.Type)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"maps"
 	"slices"
 
+	"github.com/fosdem/fazantix/lib/audit"
 	"github.com/fosdem/fazantix/lib/sink/windowsink"
 	"github.com/fosdem/fazantix/lib/theatre"
 	"github.com/go-gl/glfw/v3.3/glfw"
@@ -31,6 +32,11 @@ func keyCallback(theatre *theatre.Theatre, stageName string) func(w *glfw.Window
 				mods&glfw.ModShift != 0 {
 				slog.Warn("told to quit, exiting")
 				theatre.ShutdownRequested = true
+				theatre.Audit.Record(audit.Entry{
+					Origin: "kbdctl",
+					Action: "kill",
+					Params: map[string]interface{}{"key": "ctrl+shift+q"},
+				}, nil)
 			}
 		}
 		if action == glfw.Press {
@@ -42,6 +48,15 @@ func keyCallback(theatre *theatre.Theatre, stageName string) func(w *glfw.Window
 				}
 				slog.Debug(fmt.Sprintf("set scene %s", names[selected]))
 				err := theatre.SetScene(stageName, names[selected], mods&glfw.ModShift != 0)
+				theatre.Audit.Record(audit.Entry{
+					Origin: "kbdctl",
+					Action: "set-scene",
+					Params: map[string]interface{}{
+						"key":   selected,
+						"stage": stageName,
+						"scene": names[selected],
+					},
+				}, err)
 				if err != nil {
 					log.Println(err)
 					return
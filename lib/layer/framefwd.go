@@ -153,7 +153,25 @@ func (f *FrameForwarder) GetFrameForWriting() *encdec.Frame {
 		f.metrics.FramesDropped.Inc()
 		return nil
 	}
+	return f.takeFrame()
+}
+
+// GetFrameForWritingOrDrop is GetFrameForWriting for writers that throw
+// the incoming frame away when there is no frame for it, which counts as
+// a dropped incoming frame
+func (f *FrameForwarder) GetFrameForWritingOrDrop() *encdec.Frame {
+	f.Lock()
+	defer f.Unlock()
 
+	if len(f.bin) == 0 {
+		f.DroppedFramesIn += 1
+		f.metrics.FramesDropped.Inc()
+		return nil
+	}
+	return f.takeFrame()
+}
+
+func (f *FrameForwarder) takeFrame() *encdec.Frame {
 	frame := f.bin[len(f.bin)-1]
 	f.bin = f.bin[:len(f.bin)-1]
 
@@ -200,6 +218,21 @@ func (f *FrameForwarder) FailedWriting(frame *encdec.Frame) {
 	f.recycleFrame(frame)
 }
 
+// GetFrameForWritingWithin is like GetFrameForWritingOrDrop, but waits up
+// to timeout for a reader to give a frame back if none is free
+func (f *FrameForwarder) GetFrameForWritingWithin(timeout time.Duration) *encdec.Frame {
+	deadline := time.Now().Add(timeout)
+	for {
+		f.Lock()
+		available := len(f.bin) > 0
+		f.Unlock()
+		if available || !time.Now().Before(deadline) {
+			return f.GetFrameForWritingOrDrop()
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func (f *FrameForwarder) AvailableFramesForWriting() int {
 	return len(f.bin)
 }
@@ -128,6 +128,15 @@ func (s *Layer) Animate(delta float32, speed float32) {
 	s.Opacity = ramp(s.Opacity, s.targetTransform.Opacity, delta, speed)
 }
 
+// Visible tells whether the layer shows on its stage, or is about to as it
+// fades in. Layers below 1/256 opacity do not change the output.
+func (s *Layer) Visible() bool {
+	if s.Opacity >= 1.0/256.0 {
+		return true
+	}
+	return s.targetTransform != nil && s.targetTransform.Opacity >= 1.0/256.0
+}
+
 func (s *Layer) Frames() *FrameForwarder {
 	return s.Source.Frames()
 }
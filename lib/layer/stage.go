@@ -20,6 +20,16 @@ type Stage struct {
 	PreviewFor   string
 	Speed        float32
 
+	ActiveScene     string
+	CuedScene       string
+	TransitionTime  time.Duration
+	TransitionStart time.Time
+
+	// LockedBy is who holds the lock on this stage, if anyone. Scene
+	// changes from anyone else are refused unless forced.
+	LockedBy string
+	LockedAt time.Time
+
 	RateDivisor uint
 	RateOffset  uint
 }
@@ -31,6 +41,7 @@ type Sink interface {
 }
 
 func (s *Stage) SetSpeed(d time.Duration) {
+	s.TransitionTime = d
 	s.Speed = float32(7.0 / d.Seconds())
 }
 
@@ -44,3 +55,17 @@ func (s *Stage) StageData() uint32 {
 	}
 	return data
 }
+
+// TransitionProgress estimates how far along the current transition is,
+// from 0 to 1. The layers approach their target exponentially and are
+// within a thousandth of it once TransitionTime has passed.
+func (s *Stage) TransitionProgress() float32 {
+	if s.TransitionStart.IsZero() || s.TransitionTime <= 0 {
+		return 1
+	}
+	progress := float32(time.Since(s.TransitionStart).Seconds() / s.TransitionTime.Seconds())
+	if progress > 1 {
+		return 1
+	}
+	return progress
+}
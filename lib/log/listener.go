@@ -0,0 +1,56 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	Time    time.Time
+	Level   string
+	Module  string
+	Message string
+}
+
+// Listener gets called synchronously for every log record, so it must
+// not block or log anything itself
+type Listener func(entry *Entry)
+
+var listeners = struct {
+	sync.Mutex
+	byID   map[uint64]Listener
+	lastID uint64
+}{byID: make(map[uint64]Listener)}
+
+// AddListener registers a callback for all log records handled by a
+// LogHandler and returns an ID that can be passed to RemoveListener
+func AddListener(l Listener) uint64 {
+	listeners.Lock()
+	defer listeners.Unlock()
+	listeners.lastID++
+	listeners.byID[listeners.lastID] = l
+	return listeners.lastID
+}
+
+func RemoveListener(id uint64) {
+	listeners.Lock()
+	defer listeners.Unlock()
+	delete(listeners.byID, id)
+}
+
+func notifyListeners(entry *Entry) {
+	listeners.Lock()
+	defer listeners.Unlock()
+	for _, l := range listeners.byID {
+		l(entry)
+	}
+}
+
+func newEntry(t time.Time, level string, module any, msg string) *Entry {
+	e := &Entry{Time: t, Level: level, Message: msg}
+	if module != nil {
+		e.Module = fmt.Sprintf("%s", module)
+	}
+	return e
+}
@@ -78,6 +78,8 @@ func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
 		fmt.Print(colorize(lightGray, fmt.Sprintf("[%s] ", attrs["module"])))
 	}
 	fmt.Println(r.Message)
+
+	notifyListeners(newEntry(r.Time, r.Level.String(), attrs["module"], r.Message))
 	return nil
 }
 
@@ -25,6 +25,44 @@ var (
 		Name: "fazantix_stream_frames_dropped_total",
 		Help: "Total number of frames dropped as part of stream",
 	}, []string{"name"})
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fazantix_webhook_deliveries_total",
+		Help: "Total number of webhook payloads delivered",
+	}, []string{"name"})
+	WebhookRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fazantix_webhook_retries_total",
+		Help: "Total number of webhook delivery attempts that failed and were retried",
+	}, []string{"name"})
+	WebhookFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fazantix_webhook_failures_total",
+		Help: "Total number of webhook payloads given up on after all retries",
+	}, []string{"name"})
+	WebhookDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fazantix_webhook_dropped_total",
+		Help: "Total number of webhook payloads dropped because the queue was full",
+	}, []string{"name"})
+	ScriptErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fazantix_script_errors_total",
+		Help: "Total number of errors raised by scripts, including failed loads",
+	}, []string{"name"})
+	SourceUploadTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fazantix_source_upload_seconds",
+		Help:    "Time taken to stage and upload a frame of a source to the GPU",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 12),
+	}, []string{"name"})
+	ReadbackLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fazantix_readback_latency_seconds",
+		Help:    "Time from starting to read a sink's frame from the GPU until it reaches the sink",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 10),
+	}, []string{"name"})
+	ReadbackLatencyFrames = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fazantix_readback_latency_frames",
+		Help: "Number of frames a sink's last frame was rendered before it reached the sink",
+	}, []string{"name"})
+	ReadbackStalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fazantix_readback_stalls_total",
+		Help: "Total number of times rendering waited for the GPU because all readback buffers were in use",
+	}, []string{"name"})
 )
 
 type StreamMetrics struct {
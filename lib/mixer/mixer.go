@@ -2,15 +2,24 @@ package mixer
 
 import (
 	"log"
+	"time"
 
 	"github.com/fosdem/fazantix/lib/api"
 	"github.com/fosdem/fazantix/lib/config"
 	"github.com/fosdem/fazantix/lib/encdec"
 	"github.com/fosdem/fazantix/lib/kbdctl"
+	"github.com/fosdem/fazantix/lib/layer"
+	"github.com/fosdem/fazantix/lib/mqttbridge"
+	"github.com/fosdem/fazantix/lib/oscctl"
 	"github.com/fosdem/fazantix/lib/rendering"
 	"github.com/fosdem/fazantix/lib/rendering/shaders"
+	"github.com/fosdem/fazantix/lib/schedule"
+	"github.com/fosdem/fazantix/lib/scripting"
+	"github.com/fosdem/fazantix/lib/statefile"
+	"github.com/fosdem/fazantix/lib/stats"
 	"github.com/fosdem/fazantix/lib/theatre"
 	"github.com/fosdem/fazantix/lib/utils"
+	"github.com/fosdem/fazantix/lib/webhooks"
 )
 
 func MakeWindowAndMix(cfg *config.Config) {
@@ -26,8 +35,19 @@ func MakeWindowAndMix(cfg *config.Config) {
 		log.Fatalf("could not initialise renderer: %s", err)
 	}
 
-	api := api.ServeInBackground(theatre, cfg.Api)
+	scripts := scripting.New(cfg.Scripts, theatre)
+	api := api.ServeInBackground(theatre, cfg.Api, scripts)
+	oscctl.ServeInBackground(theatre, cfg.Osc)
+	var apiStats *stats.Stats
+	if api != nil {
+		apiStats = api.Stats
+	}
+	bridge := mqttbridge.StartInBackground(theatre, cfg.Mqtt, apiStats)
+	hooks := webhooks.StartInBackground(theatre, cfg.Webhooks)
+	statefile.StartInBackground(theatre, cfg.State)
 	theatre.Start()
+	schedule.StartInBackground(theatre, cfg.Schedule)
+	scripts.Start()
 
 	program, err := shaders.BuildGLProgram(theatre.ShaderData())
 	if err != nil {
@@ -37,7 +57,7 @@ func MakeWindowAndMix(cfg *config.Config) {
 	rendering.SetVsync(theatre.VSyncEnabled)
 
 	glvars := rendering.NewGLVars(
-		program, int32(theatre.LayersPerStage),
+		program,
 		theatre.SourceList, theatre.FallbackSourceIndices,
 		utils.ColourParse(cfg.BGColour),
 	)
@@ -55,30 +75,45 @@ func MakeWindowAndMix(cfg *config.Config) {
 		kbdctl.SetupShortcutKeys(theatre, sink)
 	}
 
-	for _, stage := range theatre.NonWindowStageList {
-		rendering.SetupTextures(stage.Sink.Frames())
-		rendering.UseAsFramebuffer(stage.Sink.Frames())
+	glvars.Start()
+
+	yuvProgram, err := shaders.BuildYUVProgram()
+	if err != nil {
+		log.Fatalf("could not init YUV GL program: %s", err)
+	}
+	readbacks := make([]*rendering.Readback, len(theatre.NonWindowStageList))
+	for i, stage := range theatre.NonWindowStageList {
+		readbacks[i] = rendering.NewReadback(stage.Sink.Frames(), yuvProgram, glvars)
 	}
 
-	glvars.Start()
+	uploader := rendering.NewUploader(theatre.SourceList, theatre.FallbackSourceIndices)
 
 	var deltaTimer utils.DeltaTimer
+	var drawn []*layer.Stage
 	frameIndex := uint64(0)
 	for !theatre.ShutdownRequested {
 		frameIndex++
 		glvars.StartFrame()
 		dt := deltaTimer.Next()
 
-		rendering.SendFramesToGPU(theatre.SourceList, dt)
+		drawn = append(drawn[:0], theatre.WindowStageList...)
+		for _, stage := range theatre.NonWindowStageList {
+			if (frameIndex+uint64(stage.RateOffset))%uint64(stage.RateDivisor) == 0 {
+				drawn = append(drawn, stage)
+			}
+		}
+		uploader.SendFramesToGPU(dt, drawn)
 
 		for _, stage := range theatre.WindowStageList {
+			uploader.CatchUp(stage)
 			glvars.DrawStage(stage)
 		}
 
-		for _, stage := range theatre.NonWindowStageList {
+		for i, stage := range theatre.NonWindowStageList {
 			if (frameIndex+uint64(stage.RateOffset))%uint64(stage.RateDivisor) == 0 {
+				uploader.CatchUp(stage)
 				glvars.DrawStage(stage)
-				rendering.GetFrameFromGPUInto(stage.Sink)
+				readbacks[i].ReadInto(stage.Sink)
 			}
 		}
 
@@ -93,7 +128,23 @@ func MakeWindowAndMix(cfg *config.Config) {
 
 		// Maintenance
 		theatre.Animate(float32(dt.Nanoseconds()) * 1e-9)
+		theatre.CheckSourceHealth()
+		theatre.CheckAutomation()
+		theatre.CheckCrashes()
 		api.Stats.Update()
 		kbdctl.Poll()
 	}
+
+	// the last frames read back should still reach the sinks
+	for i, stage := range theatre.NonWindowStageList {
+		readbacks[i].Flush(stage.Sink)
+	}
+
+	scripts.Stop()
+	err = theatre.Audit.Close()
+	if err != nil {
+		log.Printf("could not close the audit log: %s", err)
+	}
+	bridge.Stop()
+	hooks.Shutdown(5 * time.Second)
 }
@@ -0,0 +1,281 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/fosdem/fazantix/lib/audit"
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/stats"
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+// Bridge publishes the theatre state to an MQTT broker as retained
+// messages and accepts scene commands from it. The broker does not need
+// to be up when fazantix starts; the client keeps reconnecting in the
+// background and republishes the full state every time it gets through.
+type Bridge struct {
+	cfg     *config.MqttCfg
+	theatre *theatre.Theatre
+	stats   *stats.Stats
+	client  mqtt.Client
+	logger  *slog.Logger
+
+	prefix        string
+	commandTopic  string
+	statsInterval time.Duration
+
+	// retained holds the last payload for every state topic so it can be
+	// republished after a reconnect
+	retained map[string][]byte
+	mutex    sync.Mutex
+	done     chan struct{}
+}
+
+// New sets up a bridge; stats may be nil if the api is disabled
+func New(cfg *config.MqttCfg, t *theatre.Theatre, s *stats.Stats) *Bridge {
+	b := &Bridge{
+		cfg:           cfg,
+		theatre:       t,
+		stats:         s,
+		prefix:        cfg.TopicPrefix,
+		commandTopic:  cfg.CommandTopic,
+		statsInterval: time.Duration(cfg.StatsIntervalMs) * time.Millisecond,
+		retained:      make(map[string][]byte),
+		done:          make(chan struct{}),
+	}
+	if b.prefix == "" {
+		b.prefix = "fazantix"
+	}
+	if b.commandTopic == "" {
+		b.commandTopic = b.prefix + "/command"
+	}
+	if b.statsInterval == 0 {
+		b.statsInterval = 5 * time.Second
+	}
+	b.logger = slog.Default().With(slog.String("module", "mqtt"))
+
+	clientID := cfg.ClientID
+	if clientID == "" {
+		clientID = "fazantix"
+	}
+	maxReconnect := time.Duration(cfg.MaxReconnectIntervalMs) * time.Millisecond
+	if maxReconnect == 0 {
+		maxReconnect = 10 * time.Second
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(clientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(time.Second).
+		SetMaxReconnectInterval(maxReconnect).
+		SetWill(b.topic("status"), "offline", 1, true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			b.logger.Warn(fmt.Sprintf("lost connection to %s: %s", cfg.Broker, err))
+		})
+	b.client = mqtt.NewClient(opts)
+	return b
+}
+
+// Start seeds the retained state from the theatre, hooks up the event
+// listeners and starts connecting. It does not wait for the connection.
+func (b *Bridge) Start() {
+	for name, stage := range b.theatre.Stages {
+		b.publishString(b.topic("stage", name, "scene"), stage.ActiveScene)
+		b.publishString(b.topic("stage", name, "cued_scene"), stage.CuedScene)
+		b.publishString(b.topic("stage", name, "transition_ms"), strconv.FormatInt(stage.TransitionTime.Milliseconds(), 10))
+	}
+	b.publishTally(b.theatre.Tally())
+	for _, health := range b.theatre.SourceHealth() {
+		b.publishString(b.topic("source", health.Name, "ready"), strconv.FormatBool(health.Ready))
+	}
+	b.addListeners()
+
+	b.client.Connect()
+	if b.stats != nil {
+		go b.publishStats()
+	}
+}
+
+// Stop publishes the offline status and disconnects
+func (b *Bridge) Stop() {
+	if b == nil {
+		return
+	}
+	close(b.done)
+	if b.client.IsConnectionOpen() {
+		b.client.Publish(b.topic("status"), 1, true, "offline").WaitTimeout(time.Second)
+	}
+	b.client.Disconnect(250)
+}
+
+func (b *Bridge) topic(parts ...string) string {
+	return b.prefix + "/" + strings.Join(parts, "/")
+}
+
+func (b *Bridge) onConnect(client mqtt.Client) {
+	b.logger.Info(fmt.Sprintf("connected to %s", b.cfg.Broker))
+	client.Subscribe(b.commandTopic+"/#", 1, b.handleMessage)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	client.Publish(b.topic("status"), 1, true, "online")
+	for _, topic := range slices.Sorted(maps.Keys(b.retained)) {
+		b.send(topic, b.retained[topic])
+	}
+}
+
+func (b *Bridge) addListeners() {
+	b.theatre.AddEventListener("set-scene", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSetScene)
+		b.publishString(b.topic("stage", event.Stage, "scene"), event.Scene)
+	})
+	b.theatre.AddEventListener("cue", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataCue)
+		b.publishString(b.topic("stage", event.Stage, "cued_scene"), event.Scene)
+	})
+	b.theatre.AddEventListener("set-transition", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSetTransition)
+		b.publishString(b.topic("stage", event.Stage, "transition_ms"), strconv.FormatInt(event.TransitionMs, 10))
+	})
+	b.theatre.AddEventListener("tally", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataTally)
+		b.publishTally(event.Sources)
+	})
+	b.theatre.AddEventListener("source-health", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSourceHealth)
+		b.publishString(b.topic("source", event.Source, "ready"), strconv.FormatBool(event.Ready))
+	})
+}
+
+func (b *Bridge) publishTally(tally map[string][]string) {
+	for source, stages := range tally {
+		payload, err := json.Marshal(stages)
+		if err != nil {
+			b.logger.Error(fmt.Sprintf("could not encode tally: %s", err))
+			return
+		}
+		b.publish(b.topic("tally", source), payload)
+	}
+}
+
+func (b *Bridge) publishStats() {
+	ticker := time.NewTicker(b.statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			payload, err := json.Marshal(b.stats)
+			if err != nil {
+				b.logger.Error(fmt.Sprintf("could not encode stats: %s", err))
+				continue
+			}
+			b.publish(b.topic("stats"), payload)
+		}
+	}
+}
+
+func (b *Bridge) publishString(topic string, payload string) {
+	b.publish(topic, []byte(payload))
+}
+
+// publish records the payload as the retained state of the topic and
+// sends it if the broker is currently reachable
+func (b *Bridge) publish(topic string, payload []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.retained[topic] = payload
+	if b.client.IsConnectionOpen() {
+		b.send(topic, payload)
+	}
+}
+
+func (b *Bridge) send(topic string, payload []byte) {
+	token := b.client.Publish(topic, 1, true, payload)
+	go func() {
+		token.Wait()
+		if token.Error() != nil {
+			b.logger.Warn(fmt.Sprintf("could not publish %s: %s", topic, token.Error()))
+		}
+	}()
+}
+
+func (b *Bridge) handleMessage(_ mqtt.Client, msg mqtt.Message) {
+	if msg.Retained() {
+		// the broker would hand a retained command to us again on every
+		// reconnect, switching the stage back to whatever it says
+		b.logger.Warn(fmt.Sprintf("ignoring retained command on %s", msg.Topic()))
+		return
+	}
+	payload := strings.TrimSpace(string(msg.Payload()))
+	err := b.handleCommand(msg.Topic(), payload)
+	b.theatre.Audit.Record(audit.Entry{
+		Origin: "mqtt",
+		Action: msg.Topic(),
+		Params: map[string]interface{}{"payload": payload},
+	}, err)
+	if err != nil {
+		b.logger.Error(fmt.Sprintf("%s: %s", msg.Topic(), err))
+	}
+}
+
+// handleCommand routes commands of the following forms, relative to the
+// command topic:
+//
+//	{stage}/scene      payload: scene name
+//	{stage}/cue        payload: scene name
+//	{stage}/take
+//	{stage}/transition_ms  payload: integer
+func (b *Bridge) handleCommand(topic string, payload string) error {
+	parts := strings.Split(strings.TrimPrefix(topic, b.commandTopic+"/"), "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("unknown topic")
+	}
+	stage := parts[0]
+
+	switch parts[1] {
+	case "scene":
+		return b.theatre.SetScene(stage, payload, true)
+	case "cue":
+		return b.theatre.Cue(stage, payload)
+	case "take":
+		return b.theatre.Take(stage, true)
+	case "transition_ms":
+		ms, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			return fmt.Errorf("could not parse transition time: %w", err)
+		}
+		if ms < 0 {
+			return fmt.Errorf("transition time must be nonnegative")
+		}
+		return b.theatre.SetTransitionSpeed(stage, time.Duration(ms)*time.Millisecond)
+	default:
+		return fmt.Errorf("unknown command %s", parts[1])
+	}
+}
+
+func StartInBackground(t *theatre.Theatre, cfg *config.MqttCfg, s *stats.Stats) *Bridge {
+	if cfg == nil {
+		return nil
+	}
+	b := New(cfg, t, s)
+	b.Start()
+	b.logger.Info(fmt.Sprintf("bridging to MQTT broker %s", cfg.Broker))
+	return b
+}
@@ -0,0 +1,162 @@
+package mqttbridge
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not find a free port: %s", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func startBroker(t *testing.T, addr string) {
+	server := broker.New(&broker.Options{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	err := server.AddHook(new(auth.AllowHook), nil)
+	if err != nil {
+		t.Fatalf("could not add auth hook: %s", err)
+	}
+	err = server.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: addr}))
+	if err != nil {
+		t.Fatalf("could not add listener: %s", err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatalf("could not start broker: %s", err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+}
+
+func connect(t *testing.T, addr string) mqtt.Client {
+	client := mqtt.NewClient(mqtt.NewClientOptions().
+		AddBroker("tcp://" + addr).
+		SetClientID("test"))
+	token := client.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("could not connect to broker: %v", token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(100) })
+	return client
+}
+
+func expect(t *testing.T, messages chan string, want string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-messages:
+			if got == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("did not receive %q", want)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	th := theatretest.Build(t, theatretest.Config())
+	addr := freeAddr(t)
+
+	// the bridge has to cope with the broker not being up yet
+	b := New(&config.MqttCfg{
+		Broker:                 "tcp://" + addr,
+		MaxReconnectIntervalMs: 500,
+	}, th, nil)
+	b.Start()
+	t.Cleanup(b.Stop)
+
+	err := th.ResetToDefaultScenes()
+	if err != nil {
+		t.Fatalf("could not set default scenes: %s", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	startBroker(t, addr)
+	client := connect(t, addr)
+
+	scenes := make(chan string, 16)
+	client.Subscribe("fazantix/stage/projector/scene", 1, func(_ mqtt.Client, msg mqtt.Message) {
+		scenes <- string(msg.Payload())
+	}).Wait()
+	tally := make(chan string, 16)
+	client.Subscribe("fazantix/tally/camera", 1, func(_ mqtt.Client, msg mqtt.Message) {
+		tally <- string(msg.Payload())
+	}).Wait()
+
+	t.Run("retained state after connect", func(t *testing.T) {
+		expect(t, scenes, "full-slides")
+		expect(t, tally, "[]")
+	})
+
+	t.Run("scene command", func(t *testing.T) {
+		client.Publish("fazantix/command/projector/scene", 1, false, "full-cam")
+		expect(t, scenes, "full-cam")
+		expect(t, tally, `["projector"]`)
+	})
+
+	t.Run("bad command", func(t *testing.T) {
+		client.Publish("fazantix/command/projector/scene", 1, false, "nonexistent")
+		client.Publish("fazantix/command/projector/cue", 1, false, "full-slides")
+		client.Publish("fazantix/command/projector/take", 1, false, "")
+		expect(t, scenes, "full-slides")
+	})
+	t.Run("retained command", func(t *testing.T) {
+		client.Publish("fazantix/command/projector/scene", 1, true, "full-cam").Wait()
+
+		// a second bridge on the same command topic gets the retained
+		// command as soon as it subscribes
+		th := theatretest.New(t, nil)
+		changes := make(chan string, 16)
+		th.AddEventListener("set-scene", func(_ *theatre.Theatre, data interface{}) {
+			changes <- "set-scene " + data.(theatre.EventDataSetScene).Scene
+		})
+		th.AddEventListener("cue", func(_ *theatre.Theatre, data interface{}) {
+			changes <- "cue " + data.(theatre.EventDataCue).Scene
+		})
+		second := New(&config.MqttCfg{
+			Broker:       "tcp://" + addr,
+			ClientID:     "second",
+			TopicPrefix:  "second",
+			CommandTopic: "fazantix/command",
+		}, th, nil)
+		second.Start()
+		t.Cleanup(second.Stop)
+
+		// commands are handled in order, so once a live one got through,
+		// the retained one has been seen as well
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case change := <-changes:
+				if change != "cue full-cam" {
+					t.Fatalf("retained command was run: %s", change)
+				}
+				return
+			case <-ticker.C:
+				client.Publish("fazantix/command/projector/cue", 1, false, "full-cam")
+			case <-timeout:
+				t.Fatalf("second bridge did not handle the live command")
+			}
+		}
+	})
+}
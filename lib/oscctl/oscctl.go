@@ -0,0 +1,267 @@
+package oscctl
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hypebeast/go-osc/osc"
+
+	"github.com/fosdem/fazantix/lib/audit"
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+// AddressPrefix is the first part of every OSC address fazantix handles
+// or sends, as in /fazantix/{stage}/scene
+const AddressPrefix = "fazantix"
+
+// Server accepts OSC control messages on a UDP socket and, if peers are
+// configured, sends them feedback about the theatre state from that same
+// socket
+type Server struct {
+	cfg     *config.OscCfg
+	theatre *theatre.Theatre
+	conn    net.PacketConn
+	peers   []net.Addr
+	logger  *slog.Logger
+}
+
+func New(cfg *config.OscCfg, t *theatre.Theatre) *Server {
+	s := &Server{cfg: cfg, theatre: t}
+	s.logger = slog.Default().With(slog.String("module", "osc"))
+	return s
+}
+
+// Listen opens the UDP socket and resolves the feedback peers
+func (s *Server) Listen() error {
+	for _, peer := range s.cfg.Feedback {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return fmt.Errorf("could not resolve feedback peer %s: %w", peer, err)
+		}
+		s.peers = append(s.peers, addr)
+	}
+
+	conn, err := net.ListenPacket("udp", s.cfg.Bind)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", s.cfg.Bind, err)
+	}
+	s.conn = conn
+
+	if len(s.peers) > 0 {
+		s.addFeedbackListeners()
+	}
+	return nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+// Serve handles incoming packets until the socket is closed. Malformed
+// packets are logged and skipped.
+func (s *Server) Serve() error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.logger.Warn(fmt.Sprintf("could not read OSC packet: %s", err))
+			continue
+		}
+		packet, err := osc.ParsePacket(string(buf[:n]))
+		if err != nil {
+			s.logger.Warn(fmt.Sprintf("could not parse OSC packet from %s: %s", addr, err))
+			continue
+		}
+		s.dispatch(packet, addr)
+	}
+}
+
+func (s *Server) dispatch(packet osc.Packet, from net.Addr) {
+	switch p := packet.(type) {
+	case *osc.Message:
+		err := s.handleMessage(p)
+		s.theatre.Audit.Record(audit.Entry{
+			Origin: "osc",
+			Client: from.String(),
+			Action: p.Address,
+			Params: map[string]interface{}{"args": p.Arguments},
+		}, err)
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("%s: %s", p.Address, err))
+		}
+	case *osc.Bundle:
+		for _, msg := range p.Messages {
+			s.dispatch(msg, from)
+		}
+		for _, bundle := range p.Bundles {
+			s.dispatch(bundle, from)
+		}
+	}
+}
+
+// handleMessage routes messages of the following forms:
+//
+//	/fazantix/{stage}/scene {name}
+//	/fazantix/{stage}/scene/{name}
+//	/fazantix/{stage}/cue {name}
+//	/fazantix/{stage}/cue/{name}
+//	/fazantix/{stage}/take
+//	/fazantix/{stage}/transition_ms {int}
+//
+// The name can be put in the address for desks that can only send
+// messages without arguments
+func (s *Server) handleMessage(msg *osc.Message) error {
+	parts := strings.Split(strings.TrimPrefix(msg.Address, "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] != AddressPrefix {
+		return fmt.Errorf("unknown address")
+	}
+	stage := parts[1]
+	command := parts[2]
+
+	switch command {
+	case "scene", "cue":
+		var scene string
+		if len(parts) == 4 {
+			scene = parts[3]
+		} else {
+			var err error
+			scene, err = stringArg(msg)
+			if err != nil {
+				return err
+			}
+		}
+		if command == "cue" {
+			return s.theatre.Cue(stage, scene)
+		}
+		return s.theatre.SetScene(stage, scene, true)
+	case "take":
+		if len(parts) != 3 {
+			return fmt.Errorf("unknown address")
+		}
+		return s.theatre.Take(stage, true)
+	case "transition_ms":
+		if len(parts) != 3 {
+			return fmt.Errorf("unknown address")
+		}
+		ms, err := intArg(msg)
+		if err != nil {
+			return err
+		}
+		if ms < 0 {
+			return fmt.Errorf("transition time must be nonnegative")
+		}
+		return s.theatre.SetTransitionSpeed(stage, time.Duration(ms)*time.Millisecond)
+	default:
+		return fmt.Errorf("unknown command %s", command)
+	}
+}
+
+func stringArg(msg *osc.Message) (string, error) {
+	if len(msg.Arguments) != 1 {
+		return "", fmt.Errorf("expected exactly one argument")
+	}
+	str, ok := msg.Arguments[0].(string)
+	if !ok {
+		return "", fmt.Errorf("expected a string argument")
+	}
+	return str, nil
+}
+
+func intArg(msg *osc.Message) (int64, error) {
+	if len(msg.Arguments) != 1 {
+		return 0, fmt.Errorf("expected exactly one argument")
+	}
+	switch v := msg.Arguments[0].(type) {
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float32:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	default:
+		return 0, fmt.Errorf("expected a numeric argument")
+	}
+}
+
+func (s *Server) addFeedbackListeners() {
+	s.theatre.AddEventListener("set-scene", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSetScene)
+		s.send(osc.NewMessage(address(event.Stage, "active_scene"), event.Scene))
+	})
+	s.theatre.AddEventListener("cue", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataCue)
+		s.send(osc.NewMessage(address(event.Stage, "cued_scene"), event.Scene))
+	})
+	s.theatre.AddEventListener("set-transition", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSetTransition)
+		s.send(osc.NewMessage(address(event.Stage, "transition_ms"), int32(event.TransitionMs)))
+	})
+	s.theatre.AddEventListener("tally", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataTally)
+		bundle := osc.NewBundle(time.Now())
+		for _, source := range slices.Sorted(maps.Keys(event.Sources)) {
+			for stage := range t.Stages {
+				on := int32(0)
+				if slices.Contains(event.Sources[source], stage) {
+					on = 1
+				}
+				_ = bundle.Append(osc.NewMessage(address("tally", source, stage), on))
+			}
+		}
+		s.send(bundle)
+	})
+}
+
+func address(parts ...string) string {
+	return "/" + AddressPrefix + "/" + strings.Join(parts, "/")
+}
+
+func (s *Server) send(packet osc.Packet) {
+	data, err := packet.MarshalBinary()
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("could not encode OSC feedback: %s", err))
+		return
+	}
+	for _, peer := range s.peers {
+		_, err := s.conn.WriteTo(data, peer)
+		if err != nil {
+			s.logger.Warn(fmt.Sprintf("could not send OSC feedback to %s: %s", peer, err))
+		}
+	}
+}
+
+func ServeInBackground(t *theatre.Theatre, cfg *config.OscCfg) *Server {
+	if cfg == nil {
+		return nil
+	}
+	s := New(cfg, t)
+	err := s.Listen()
+	if err != nil {
+		log.Fatalf("could not start OSC server: %s", err)
+	}
+	s.logger.Info(fmt.Sprintf("listening for OSC on %s", s.Addr()))
+	go func() {
+		err := s.Serve()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logger.Error(fmt.Sprintf("OSC server stopped: %s", err))
+		}
+	}()
+	return s
+}
@@ -0,0 +1,125 @@
+package oscctl
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hypebeast/go-osc/osc"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+func startServer(t *testing.T, th *theatre.Theatre, feedback []string) *Server {
+	s := New(&config.OscCfg{Bind: "127.0.0.1:0", Feedback: feedback}, th)
+	err := s.Listen()
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	go func() { _ = s.Serve() }()
+	return s
+}
+
+func send(t *testing.T, s *Server, packet osc.Packet) {
+	data, err := packet.MarshalBinary()
+	if err != nil {
+		t.Fatalf("could not encode packet: %s", err)
+	}
+	sendRaw(t, s, data)
+}
+
+func sendRaw(t *testing.T, s *Server, data []byte) {
+	conn, err := net.Dial("udp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("could not dial: %s", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write(data)
+	if err != nil {
+		t.Fatalf("could not send: %s", err)
+	}
+}
+
+// watch reports the scene, cue and transition events of the theatre as
+// short descriptions on the returned channel
+func watch(th *theatre.Theatre) <-chan string {
+	events := make(chan string, 16)
+	th.AddEventListener("set-scene", func(_ *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSetScene)
+		events <- fmt.Sprintf("set-scene %s %s", event.Stage, event.Scene)
+	})
+	th.AddEventListener("cue", func(_ *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataCue)
+		events <- fmt.Sprintf("cue %s %s", event.Stage, event.Scene)
+	})
+	th.AddEventListener("set-transition", func(_ *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSetTransition)
+		events <- fmt.Sprintf("set-transition %s %d", event.Stage, event.TransitionMs)
+	})
+	return events
+}
+
+func expect(t *testing.T, events <-chan string, want string) {
+	select {
+	case event := <-events:
+		if event != want {
+			t.Fatalf("expected %s, got %s", want, event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", want)
+	}
+}
+
+func TestControl(t *testing.T) {
+	th := theatretest.New(t, nil)
+	events := watch(th)
+	s := startServer(t, th, nil)
+
+	send(t, s, osc.NewMessage("/fazantix/projector/scene", "full-cam"))
+	expect(t, events, "set-scene projector full-cam")
+
+	// garbage must not take the server down
+	sendRaw(t, s, []byte("definitely not osc"))
+
+	send(t, s, osc.NewMessage("/fazantix/projector/cue/full-slides"))
+	expect(t, events, "cue projector full-slides")
+	send(t, s, osc.NewMessage("/fazantix/projector/take"))
+	expect(t, events, "set-scene projector full-slides")
+
+	send(t, s, osc.NewMessage("/fazantix/projector/transition_ms", int32(250)))
+	expect(t, events, "set-transition projector 250")
+}
+
+func TestFeedback(t *testing.T) {
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	defer peer.Close()
+
+	th := theatretest.New(t, nil)
+	s := startServer(t, th, []string{peer.LocalAddr().String()})
+
+	send(t, s, osc.NewMessage("/fazantix/projector/scene", "full-cam"))
+
+	var parser osc.Server
+	_ = peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		packet, err := parser.ReceivePacket(peer)
+		if err != nil {
+			t.Fatalf("no active scene feedback: %s", err)
+		}
+		msg, ok := packet.(*osc.Message)
+		if !ok || msg.Address != "/fazantix/projector/active_scene" {
+			continue
+		}
+		if len(msg.Arguments) != 1 || msg.Arguments[0] != "full-cam" {
+			t.Fatalf("unexpected feedback: %s", msg)
+		}
+		return
+	}
+}
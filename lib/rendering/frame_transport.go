@@ -1,70 +1,62 @@
 package rendering
 
 import (
-	"time"
-
 	"github.com/fosdem/fazantix/lib/encdec"
 	"github.com/fosdem/fazantix/lib/layer"
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
 
-func SendFrameToGPU(frame *encdec.Frame, textureIDs [3]uint32, offset int) {
-	channelType := uint32(gl.RED)
-	if frame.Type == encdec.RGBFrames {
-		channelType = gl.RGB
-	}
-	if frame.Type == encdec.RGBAFrames || frame.Type == encdec.BGRAFrames || frame.Type == encdec.YUV422pFrames {
-		channelType = gl.RGBA
+// pixelLayout is the channels and data type of the pixels in a plane of a
+// frame, as passed to glTexSubImage2D
+func pixelLayout(frameType encdec.FrameType, plane int) (uint32, uint32) {
+	switch frameType {
+	case encdec.RGBFrames:
+		return gl.RGB, gl.UNSIGNED_BYTE
+	case encdec.RGBAFrames, encdec.YUV422pFrames:
+		return gl.RGBA, gl.UNSIGNED_BYTE
+	case encdec.BGRAFrames:
+		return gl.BGRA, gl.UNSIGNED_BYTE
+	case encdec.NV12Frames:
+		if plane == 1 {
+			return gl.RG, gl.UNSIGNED_BYTE
+		}
+	case encdec.P010Frames:
+		if plane == 1 {
+			return gl.RG, gl.UNSIGNED_SHORT
+		}
+		return gl.RED, gl.UNSIGNED_SHORT
+	case encdec.YUV422p10Frames:
+		return gl.RED, gl.UNSIGNED_SHORT
+	case encdec.V210Frames:
+		return gl.RGBA, gl.UNSIGNED_INT_2_10_10_10_REV
 	}
+	return gl.RED, gl.UNSIGNED_BYTE
+}
 
+// SendFrameToGPU uploads the planes of a frame into their textures. When
+// staged, the frame has been copied into the bound pixel unpack buffer and
+// is uploaded from there.
+func SendFrameToGPU(frame *encdec.Frame, textureIDs [3]uint32, staged bool) {
+	if frame.Type == encdec.V210Frames {
+		// lines of v210 are padded beyond the words that hold pixels
+		gl.PixelStorei(gl.UNPACK_ROW_LENGTH, int32(encdec.V210Stride(frame.Width)/4))
+		defer gl.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+	}
 	for j := 0; j < frame.NumTextures; j++ {
-		dataPtr, w, h := frame.Texture(j)
+		data, w, h := frame.Texture(j)
+		pixels := gl.Ptr(data)
+		if staged {
+			pixels = gl.PtrOffset(frame.TextureOffsets[j][0])
+		}
+		channelType, pixelType := pixelLayout(frame.Type, j)
 		SendTextureToGPU(
-			textureIDs[j], offset*3+j,
-			w, h, channelType,
-			dataPtr,
+			textureIDs[j],
+			w, h, channelType, pixelType,
+			pixels, len(data),
 		)
 	}
 }
 
-func GetFrameFromGPU(frame *encdec.Frame) {
-	packing := uint32(0)
-	switch frame.Type {
-	case encdec.RGBAFrames:
-		packing = gl.RGBA
-	case encdec.BGRAFrames:
-		packing = gl.BGRA
-	default:
-		panic("Unsupported packing for ReadPixels")
-	}
-	gl.ReadPixels(0, 0, int32(frame.Width), int32(frame.Height), packing, gl.UNSIGNED_BYTE, gl.Ptr(frame.Data))
-}
-
 type ThingWithFrames interface {
 	Frames() *layer.FrameForwarder
 }
-
-func GetFrameFromGPUInto(into ThingWithFrames) {
-	frames := into.Frames()
-	frame := frames.GetFrameForWriting()
-	if frame == nil {
-		return // we are instructed to drop the frame
-	}
-	GetFrameFromGPU(frame)
-	frames.FinishedWriting(frame)
-}
-
-func SendFramesToGPU[F ThingWithFrames](from []F, dt time.Duration) {
-	for i, thing := range from {
-		frames := thing.Frames()
-
-		frames.Age(dt)
-
-		frame := frames.GetFreshFrameForReading()
-		if frame == nil {
-			continue // we are instructed to drop the frame
-		}
-		SendFrameToGPU(frame, frames.TextureIDs, int(i))
-		frames.FinishedReading(frame)
-	}
-}
@@ -6,17 +6,13 @@ import (
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
 
-const f32 = 4
-
+// GLVars draws stages one layer at a time. Every visible layer is a quad
+// that only covers its own part of the stage, with only its own textures
+// bound, and is blended over the layers below it. Hidden layers cost
+// nothing, and the program does not change with the number of layers.
 type GLVars struct {
-	LayerPos      []float32
-	LayerData     []float32
-	StageData     uint32
-	SourceIndices []int32
-	SourceTypes   []uint32
-
+	// NumTextures is the number of texture units a layer uses
 	NumTextures int32
-	NumLayers   int32
 	Sources     []layer.Source
 
 	// FallbackIndices stores an index for each source which acts as
@@ -28,21 +24,20 @@ type GLVars struct {
 	BGColour utils.Colour
 
 	// GL IDs
-	VAO                  uint32
-	VBO                  uint32
-	Textures             []int32
-	LayerDataUniform     int32
-	LayerPosUniform      int32
-	StageDataUniform     int32
-	SourceIndicesUniform int32
-	SourceTypesUniform   int32
-	TexUniform           int32
+	VAO                uint32
+	Textures           []int32
+	LayerPosUniform    int32
+	OpacityUniform     int32
+	StageDataUniform   int32
+	SourceIndexUniform int32
+	SourceTypeUniform  int32
+	YUVMatrixUniform   int32
+	TexUniform         int32
 }
 
-func NewGLVars(program uint32, numLayers int32, sources []layer.Source, fallbackSourceIndices []int32, bgColour utils.Colour) *GLVars {
+func NewGLVars(program uint32, sources []layer.Source, fallbackSourceIndices []int32, bgColour utils.Colour) *GLVars {
 	g := &GLVars{}
 
-	g.NumLayers = numLayers
 	g.Sources = sources
 	g.FallbackIndices = fallbackSourceIndices
 	g.Program = program
@@ -67,84 +62,61 @@ func (g *GLVars) DrawStage(stage *layer.Stage) {
 
 	gl.BindFramebuffer(gl.FRAMEBUFFER, frames.FramebufferID)
 	gl.Viewport(0,0, int32(frames.Width), int32(frames.Height))
-
-	// push vars related to the window stage
-	g.loadStage(stage)
-	g.pushStageVars()
+	gl.Clear(gl.COLOR_BUFFER_BIT)
+
+	// mixing each layer in by its alpha, the stage stays opaque
+	gl.Enable(gl.BLEND)
+	gl.BlendFuncSeparate(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA, gl.ONE, gl.ONE_MINUS_SRC_ALPHA)
+	gl.Uniform1ui(g.StageDataUniform, stage.StageData())
+	for i, l := range stage.Layers {
+		if l.Visible() {
+			g.drawLayer(stage, i, l)
+		}
+	}
+	gl.Disable(gl.BLEND)
 }
 
 func (g *GLVars) allocate() {
-
-	// Configure the vertex data
+	// the vertices are made up by the vertex shader
 	gl.GenVertexArrays(1, &g.VAO)
 	gl.BindVertexArray(g.VAO)
-	stride := int32(4 * f32)
-
-	vertAttrib := uint32(gl.GetAttribLocation(g.Program, gl.Str("position\x00")))
-	gl.EnableVertexAttribArray(vertAttrib)
-	gl.VertexAttribPointerWithOffset(vertAttrib, 2, gl.FLOAT, false, stride, 0)
 
-	texCoordAttrib := uint32(gl.GetAttribLocation(g.Program, gl.Str("uv\x00")))
-	gl.EnableVertexAttribArray(texCoordAttrib)
-	gl.VertexAttribPointerWithOffset(texCoordAttrib, 2, gl.FLOAT, false, stride, 2*f32)
-
-	g.LayerPos = make([]float32, g.NumLayers*4)
 	g.LayerPosUniform = gl.GetUniformLocation(g.Program, gl.Str("layerPosition\x00"))
-	gl.Uniform4fv(g.LayerPosUniform, g.NumLayers, &g.LayerPos[0])
-
-	g.LayerData = make([]float32, g.NumLayers*4)
-	g.LayerDataUniform = gl.GetUniformLocation(g.Program, gl.Str("layerData\x00"))
-	gl.Uniform4fv(g.LayerDataUniform, g.NumLayers, &g.LayerData[0])
-
-	g.SourceIndices = make([]int32, g.NumLayers)
-	g.SourceIndicesUniform = gl.GetUniformLocation(g.Program, gl.Str("sourceIndices\x00"))
-	gl.Uniform1iv(g.SourceIndicesUniform, g.NumLayers, &g.SourceIndices[0])
-
-	g.SourceTypes = make([]uint32, len(g.Sources))
-	g.SourceTypesUniform = gl.GetUniformLocation(g.Program, gl.Str("sourceTypes\x00"))
-	gl.Uniform1uiv(g.SourceTypesUniform, int32(len(g.Sources)), &g.SourceTypes[0])
-
+	g.OpacityUniform = gl.GetUniformLocation(g.Program, gl.Str("opacity\x00"))
 	g.StageDataUniform = gl.GetUniformLocation(g.Program, gl.Str("stageData\x00"))
-	gl.Uniform1ui(g.StageDataUniform, 0)
+	g.SourceIndexUniform = gl.GetUniformLocation(g.Program, gl.Str("sourceIndex\x00"))
+	g.SourceTypeUniform = gl.GetUniformLocation(g.Program, gl.Str("sourceType\x00"))
+	g.YUVMatrixUniform = gl.GetUniformLocation(g.Program, gl.Str("yuvMatrix\x00"))
 
-	// Allocate 3 textures for every source in case of planar YUV
-	g.NumTextures = int32(len(g.Sources) * 3)
+	// Allocate 3 textures in case of planar YUV
+	g.NumTextures = 3
 	g.Textures = make([]int32, g.NumTextures)
 	for i := range g.NumTextures {
 		g.Textures[i] = int32(i)
 	}
 	g.TexUniform = gl.GetUniformLocation(g.Program, gl.Str("tex\x00"))
-	gl.Uniform1iv(g.TexUniform, g.NumTextures, &g.Textures[0])
 }
 
-func (g *GLVars) loadStage(stage *layer.Stage) {
-	layers := stage.Layers
-	for i := range g.NumLayers {
-		g.LayerPos[(i*4)+0] = layers[i].Position.X
-		g.LayerPos[(i*4)+1] = layers[i].Position.Y
-		g.LayerPos[(i*4)+2] = layers[i].Size.X
-		g.LayerPos[(i*4)+3] = layers[i].Size.Y
-		g.LayerData[(i*4)+0] = layers[i].Opacity
-
-		sourceIndex := stage.SourceIndices[i]
-		for sourceIndex != -1 && !g.Sources[sourceIndex].Frames().IsReady {
-			sourceIndex = g.FallbackIndices[sourceIndex]
-		}
-		g.SourceIndices[i] = sourceIndex
+func (g *GLVars) drawLayer(stage *layer.Stage, i int, l *layer.Layer) {
+	sourceIndex := stage.SourceIndices[i]
+	for sourceIndex != -1 && !g.Sources[sourceIndex].Frames().IsReady {
+		sourceIndex = g.FallbackIndices[sourceIndex]
 	}
-	for i := range len(g.Sources) {
-		g.SourceTypes[i] = uint32(stage.SourceTypes[i])
-	}
-	g.StageData = stage.StageData()
-}
 
-func (g *GLVars) pushStageVars() {
-	gl.Uniform1ui(g.StageDataUniform, g.StageData)
-	gl.Uniform4fv(g.LayerDataUniform, g.NumLayers, &g.LayerData[0])
-	gl.Uniform4fv(g.LayerPosUniform, g.NumLayers, &g.LayerPos[0])
-	gl.Uniform1iv(g.SourceIndicesUniform, g.NumLayers, &g.SourceIndices[0])
-	gl.Uniform1uiv(g.SourceTypesUniform, int32(len(g.Sources)), &g.SourceTypes[0])
+	gl.Uniform4f(g.LayerPosUniform, l.Position.X, l.Position.Y, l.Size.X, l.Size.Y)
+	gl.Uniform1f(g.OpacityUniform, l.Opacity)
+	gl.Uniform1i(g.SourceIndexUniform, sourceIndex)
+	if sourceIndex != -1 {
+		frames := g.Sources[sourceIndex].Frames()
+		gl.Uniform1ui(g.SourceTypeUniform, uint32(stage.SourceTypes[sourceIndex]))
+		// sources like v4l only know their colour settings once started
+		matrix := frames.YUVMatrix()
+		gl.UniformMatrix4fv(g.YUVMatrixUniform, 1, false, &matrix[0])
+		for j := range g.NumTextures {
+			gl.ActiveTexture(uint32(gl.TEXTURE0 + j))
+			gl.BindTexture(gl.TEXTURE_2D, frames.TextureIDs[j])
+		}
+	}
 
-	// draw vertices on the window stage
-	gl.DrawArrays(gl.TRIANGLES, 0, 1*3)
+	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
 }
@@ -0,0 +1,97 @@
+package rendering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/encdec"
+	"github.com/fosdem/fazantix/lib/layer"
+	"github.com/fosdem/fazantix/lib/rendering/shaders"
+	"github.com/fosdem/fazantix/lib/utils"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+type testStageSink struct {
+	testSource
+}
+
+func (s *testStageSink) SetRate(rate float64) {}
+
+func TestDrawLayers(t *testing.T) {
+	withGL(t, func() {
+		// more sources than there are texture units
+		const numSources = 40
+		sources := newTestSources(numSources)
+		fallbacks := make([]int32, numSources)
+		for i, source := range sources {
+			fallbacks[i] = -1
+			frames := source.Frames()
+			frame := frames.GetFrameForWriting()
+			if err := encdec.Prepare(frame); err != nil {
+				t.Fatalf("could not prepare frame: %s", err)
+			}
+			for j := 0; j < len(frame.Data); j += 4 {
+				copy(frame.Data[j:], []byte{byte(i * 4), 0, 0, 255})
+			}
+			frames.FinishedWriting(frame)
+		}
+
+		program, err := shaders.BuildGLProgram(&shaders.ShaderData{
+			FallbackColour: utils.ColourParse("#ff0000"),
+		})
+		if err != nil {
+			t.Fatalf("could not build program: %s", err)
+		}
+		glvars := NewGLVars(program, sources, fallbacks, utils.ColourParse("#0000ff"))
+		glvars.Start()
+		uploader := NewUploader(sources, fallbacks)
+
+		sink := &testStageSink{}
+		sink.frames.Init("sink", &encdec.FrameInfo{
+			FrameCfg:  encdec.FrameCfg{Width: 8, Height: 4, NumAllocatedFrames: 2},
+			FrameType: encdec.RGBAFrames,
+		}, &encdec.DumbFrameAllocator{})
+		SetupTextures(sink.Frames())
+		UseAsFramebuffer(sink.Frames())
+		stage := testStage(sources, make([]float32, numSources)...)
+		stage.Sink = sink
+
+		// draw shows the layers at the given opacities, and returns the red
+		// and blue of the left and right half of the stage
+		draw := func(opacities map[int]float32) [2][2]int {
+			for i, l := range stage.Layers {
+				l.Opacity = opacities[i]
+			}
+			glvars.StartFrame()
+			uploader.SendFramesToGPU(time.Millisecond, []*layer.Stage{stage})
+			glvars.DrawStage(stage)
+			var halves [2][2]int
+			for h, x := range []int32{1, 6} {
+				pixel := make([]byte, 4)
+				gl.ReadPixels(x, 2, 1, 1, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(pixel))
+				halves[h] = [2]int{int(pixel[0]), int(pixel[2])}
+			}
+			return halves
+		}
+		expect := func(what string, got [2][2]int, want [2][2]int) {
+			t.Helper()
+			for h := range got {
+				for c := range got[h] {
+					if diff := got[h][c] - want[h][c]; diff < -1 || diff > 1 {
+						t.Errorf("%s: expected %v, got %v", what, want, got)
+						return
+					}
+				}
+			}
+		}
+
+		expect("nothing visible", draw(nil), [2][2]int{{0, 255}, {0, 255}})
+		expect("top layer", draw(map[int]float32{5: 1, 33: 1}), [2][2]int{{132, 0}, {132, 0}})
+
+		// a layer that covers the left half only is blended over the rest
+		stage.Layers[33].Size.X = 0.5
+		expect("half a layer", draw(map[int]float32{5: 1, 33: 1}), [2][2]int{{132, 0}, {20, 0}})
+		expect("half opacity", draw(map[int]float32{5: 1, 33: 0.5}), [2][2]int{{76, 0}, {20, 0}})
+		expect("over the background", draw(map[int]float32{33: 0.5}), [2][2]int{{66, 128}, {0, 255}})
+	})
+}
@@ -0,0 +1,233 @@
+package rendering
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/fosdem/fazantix/lib/encdec"
+	"github.com/fosdem/fazantix/lib/layer"
+	"github.com/fosdem/fazantix/lib/metrics"
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// readbackBuffers is how many frames of a sink can be on their way back
+// from the GPU. Usually only one is, the others are there so rendering does
+// not have to wait when the GPU falls behind.
+const readbackBuffers = 3
+
+type pendingRead struct {
+	buffer uint32
+	fence  uintptr
+	issued time.Time
+	index  uint64
+}
+
+// Readback gets the output of a stage from the GPU into its sink. Stages
+// are always drawn in RGBA, sinks that want YUV get it converted on the GPU
+// first, so that only the YUV planes have to be read back.
+//
+// Reads go into pixel buffer objects and are only copied into the sink the
+// next time the stage is read, so the GPU can copy frame N while frame N+1
+// is being rendered. This adds a frame of latency to every sink but window
+// sinks, which fazantix_readback_latency_frames shows.
+type Readback struct {
+	frames *layer.FrameForwarder
+	size   int
+
+	free    []uint32
+	pending []pendingRead
+	reads   uint64
+
+	latency       prometheus.Observer
+	latencyFrames prometheus.Gauge
+	stalls        prometheus.Counter
+
+	// only used for YUV sinks
+	yuvProgram     uint32
+	restoreProgram uint32
+	texture        uint32
+	framebuffer    uint32
+	rows           int32
+	unit           int32
+}
+
+// NewReadback sets up the textures a sink is drawn into. It has to be
+// called after glvars.Start, which the readback returns to when it is done.
+func NewReadback(frames *layer.FrameForwarder, yuvProgram uint32, glvars *GLVars) *Readback {
+	r := &Readback{
+		frames:        frames,
+		size:          encdec.FrameSize(&frames.FrameInfo),
+		latency:       metrics.ReadbackLatency.WithLabelValues(frames.Name),
+		latencyFrames: metrics.ReadbackLatencyFrames.WithLabelValues(frames.Name),
+		stalls:        metrics.ReadbackStalls.WithLabelValues(frames.Name),
+	}
+	r.stalls.Add(0)
+
+	r.free = make([]uint32, readbackBuffers)
+	gl.GenBuffers(readbackBuffers, &r.free[0])
+	for _, buffer := range r.free {
+		gl.BindBuffer(gl.PIXEL_PACK_BUFFER, buffer)
+		gl.BufferData(gl.PIXEL_PACK_BUFFER, r.size, nil, gl.STREAM_READ)
+	}
+	gl.BindBuffer(gl.PIXEL_PACK_BUFFER, 0)
+
+	if frames.FrameType == encdec.RGBAFrames || frames.FrameType == encdec.BGRAFrames {
+		SetupTextures(frames)
+		UseAsFramebuffer(frames)
+		return r
+	}
+
+	frames.TextureIDs[0] = SetupRGBATexture(frames.Width, frames.Height, gl.RGBA)
+	frames.FramebufferID = UseTextureAsFramebuffer(frames.TextureIDs[0])
+
+	// the frame is converted into a single-channel texture that is as wide
+	// as the frame, and tall enough to hold all of its planes. Frames have
+	// an even width and height, so the planes fill whole lines.
+	r.rows = int32(r.size / frames.Width)
+	gl.GenTextures(1, &r.texture)
+	gl.BindTexture(gl.TEXTURE_2D, r.texture)
+	gl.TexImage2D(
+		gl.TEXTURE_2D,
+		0,
+		gl.R8,
+		int32(frames.Width),
+		r.rows,
+		0,
+		gl.RED,
+		gl.UNSIGNED_BYTE,
+		gl.Ptr(nil),
+	)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	r.framebuffer = UseTextureAsFramebuffer(r.texture)
+
+	r.yuvProgram = yuvProgram
+	r.restoreProgram = glvars.Program
+	// the unit after the ones of the layers, so their textures stay bound
+	r.unit = glvars.NumTextures
+	sx, sy := encdec.ChromaSubsampling(frames.FrameType)
+	matrix := frames.RGBMatrix()
+	interleaved := int32(0)
+	if frames.FrameType == encdec.NV12Frames {
+		interleaved = 1
+	}
+
+	gl.UseProgram(r.yuvProgram)
+	gl.Uniform1i(gl.GetUniformLocation(r.yuvProgram, gl.Str("rendered\x00")), r.unit)
+	gl.UniformMatrix4fv(gl.GetUniformLocation(r.yuvProgram, gl.Str("rgbMatrix\x00")), 1, false, &matrix[0])
+	gl.Uniform2i(gl.GetUniformLocation(r.yuvProgram, gl.Str("subsampling\x00")), int32(sx), int32(sy))
+	gl.Uniform1i(gl.GetUniformLocation(r.yuvProgram, gl.Str("interleaved\x00")), interleaved)
+	gl.UseProgram(r.restoreProgram)
+
+	return r
+}
+
+// ReadInto starts reading the stage that was just drawn, and hands the
+// frames of earlier reads that the GPU has finished to the sink
+func (r *Readback) ReadInto(into ThingWithFrames) {
+	frames := into.Frames()
+
+	r.deliver(frames, false)
+	if len(r.free) == 0 {
+		// the GPU is readbackBuffers frames behind
+		r.stalls.Inc()
+		r.deliver(frames, true)
+	}
+
+	buffer := r.free[len(r.free)-1]
+	r.free = r.free[:len(r.free)-1]
+
+	if r.yuvProgram != 0 {
+		gl.BindFramebuffer(gl.FRAMEBUFFER, r.framebuffer)
+		gl.Viewport(0, 0, int32(frames.Width), r.rows)
+		gl.UseProgram(r.yuvProgram)
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + r.unit))
+		gl.BindTexture(gl.TEXTURE_2D, frames.TextureIDs[0])
+		gl.DrawArrays(gl.TRIANGLES, 0, 1*3)
+	}
+
+	gl.BindBuffer(gl.PIXEL_PACK_BUFFER, buffer)
+	if r.yuvProgram != 0 {
+		gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+		gl.ReadPixels(0, 0, int32(frames.Width), r.rows, gl.RED, gl.UNSIGNED_BYTE, nil)
+		gl.PixelStorei(gl.PACK_ALIGNMENT, 4)
+		gl.UseProgram(r.restoreProgram)
+	} else {
+		gl.ReadPixels(0, 0, int32(frames.Width), int32(frames.Height), readPacking(frames.FrameType), gl.UNSIGNED_BYTE, nil)
+	}
+	gl.BindBuffer(gl.PIXEL_PACK_BUFFER, 0)
+
+	r.pending = append(r.pending, pendingRead{
+		buffer: buffer,
+		fence:  gl.FenceSync(gl.SYNC_GPU_COMMANDS_COMPLETE, 0),
+		issued: time.Now(),
+		index:  r.reads,
+	})
+	r.reads++
+	gl.Flush()
+}
+
+// Flush waits for every read that is still on its way and hands it to
+// the sink
+func (r *Readback) Flush(into ThingWithFrames) {
+	for len(r.pending) > 0 {
+		r.deliver(into.Frames(), true)
+	}
+}
+
+// deliver copies finished reads into frames of the sink, oldest first. When
+// wait is set, the oldest read is delivered even if it is not finished yet.
+func (r *Readback) deliver(frames *layer.FrameForwarder, wait bool) {
+	for len(r.pending) > 0 {
+		read := r.pending[0]
+		if !wait {
+			status := gl.ClientWaitSync(read.fence, 0, 0)
+			if status != gl.ALREADY_SIGNALED && status != gl.CONDITION_SATISFIED {
+				return
+			}
+		}
+		wait = false
+		r.pending = r.pending[1:]
+		gl.DeleteSync(read.fence)
+
+		// mapping waits for the read if it is not done yet
+		gl.BindBuffer(gl.PIXEL_PACK_BUFFER, read.buffer)
+		data := gl.MapBufferRange(gl.PIXEL_PACK_BUFFER, 0, r.size, gl.MAP_READ_BIT)
+		frame := frames.GetFrameForWriting()
+		if frame != nil {
+			// the planes have to be set up for anything that reads the
+			// frame plane by plane, like the media API
+			err := encdec.Prepare(frame)
+			if err != nil {
+				frames.Error("could not prepare %s buffer: %s", frame.Type, err)
+			}
+			if data != nil && err == nil {
+				copy(frame.Data, unsafe.Slice((*byte)(data), r.size))
+				frames.FinishedWriting(frame)
+			} else {
+				frames.FailedWriting(frame)
+			}
+		}
+		if data != nil {
+			gl.UnmapBuffer(gl.PIXEL_PACK_BUFFER)
+		}
+		gl.BindBuffer(gl.PIXEL_PACK_BUFFER, 0)
+
+		r.free = append(r.free, read.buffer)
+		r.latency.Observe(time.Since(read.issued).Seconds())
+		r.latencyFrames.Set(float64(r.reads - read.index))
+	}
+}
+
+// readPacking is the pixel format RGBA sinks are read back in
+func readPacking(frameType encdec.FrameType) uint32 {
+	switch frameType {
+	case encdec.RGBAFrames:
+		return gl.RGBA
+	case encdec.BGRAFrames:
+		return gl.BGRA
+	default:
+		panic("Unsupported packing for ReadPixels")
+	}
+}
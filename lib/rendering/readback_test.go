@@ -0,0 +1,133 @@
+package rendering
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/fosdem/fazantix/lib/encdec"
+	"github.com/fosdem/fazantix/lib/layer"
+	"github.com/fosdem/fazantix/lib/rendering/shaders"
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// withGL runs f with a hidden window's GL context, or skips the test when
+// there is no display to make one on
+func withGL(t *testing.T, f func()) {
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		t.Skip("no display for a GL context")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := glfw.Init(); err != nil {
+		t.Skipf("could not init glfw: %s", err)
+	}
+	defer glfw.Terminate()
+	glfw.WindowHint(glfw.Visible, glfw.False)
+	glfw.WindowHint(glfw.ContextVersionMajor, 4)
+	glfw.WindowHint(glfw.ContextVersionMinor, 1)
+	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+	window, err := glfw.CreateWindow(16, 16, "test", nil, nil)
+	if err != nil {
+		t.Skipf("could not make a window: %s", err)
+	}
+	defer window.Destroy()
+	window.MakeContextCurrent()
+	if err := Init(); err != nil {
+		t.Skipf("could not init GL: %s", err)
+	}
+	f()
+}
+
+type testSink struct {
+	frames layer.FrameForwarder
+}
+
+func (s *testSink) Frames() *layer.FrameForwarder {
+	return &s.frames
+}
+
+func TestReadbackMatchesCPU(t *testing.T) {
+	withGL(t, func() {
+		yuvProgram, err := shaders.BuildYUVProgram()
+		if err != nil {
+			t.Fatalf("could not build YUV program: %s", err)
+		}
+		var vao uint32
+		gl.GenVertexArrays(1, &vao)
+		gl.BindVertexArray(vao)
+
+		// not a multiple of 4 wide, to catch row alignment
+		width, height := 22, 6
+		rgba := make([]byte, width*height*4)
+		for i := range rgba {
+			rgba[i] = byte(i * 37)
+		}
+
+		for _, pixFmt := range []string{"rgba", "yuv420p", "nv12", "yuv422p"} {
+			frameType, _ := encdec.ParsePixFmt(pixFmt)
+			info := &encdec.FrameInfo{
+				FrameCfg:   encdec.FrameCfg{Width: width, Height: height, NumAllocatedFrames: 2},
+				FrameType:  frameType,
+				Colorspace: encdec.BT709,
+				Range:      encdec.LimitedRange,
+			}
+			sink := &testSink{}
+			sink.frames.Init(pixFmt, info, &encdec.DumbFrameAllocator{})
+
+			readback := NewReadback(sink.Frames(), yuvProgram, &GLVars{})
+			gl.BindTexture(gl.TEXTURE_2D, sink.frames.TextureIDs[0])
+			gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, int32(width), int32(height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(rgba))
+			readback.ReadInto(sink)
+			if frame := sink.frames.GetAnyFrameForReading(); frame != nil {
+				t.Fatalf("%s: the read reached the sink without a frame of latency", pixFmt)
+			}
+			readback.Flush(sink)
+
+			frame := sink.frames.GetAnyFrameForReading()
+			if frame == nil {
+				t.Fatalf("%s: nothing was read back", pixFmt)
+			}
+			want := rgba
+			if frameType != encdec.RGBAFrames {
+				want = make([]byte, len(frame.Data))
+				encdec.RGBAToYUV(rgba, info, want)
+			}
+			for i := range want {
+				if diff := int(frame.Data[i]) - int(want[i]); diff < -1 || diff > 1 {
+					t.Errorf("%s: byte %d is %d on the GPU and %d on the CPU", pixFmt, i, frame.Data[i], want[i])
+					break
+				}
+			}
+			// the planes of the frame must be laid out like those of a
+			// frame filled on the CPU
+			reference := (&encdec.DumbFrameAllocator{}).NewFrame(info)
+			copy(reference.Data, want)
+			if err := encdec.Prepare(reference); err != nil {
+				t.Fatalf("%s: could not prepare the reference frame: %s", pixFmt, err)
+			}
+			img, err := encdec.FrameToImage(frame, nil)
+			if err != nil {
+				t.Fatalf("%s: could not turn the frame into an image: %s", pixFmt, err)
+			}
+			wantImg, _ := encdec.FrameToImage(reference, nil)
+		compare:
+			for y := range height {
+				for x := range width {
+					r, g, b, _ := img.At(x, y).RGBA()
+					wr, wg, wb, _ := wantImg.At(x, y).RGBA()
+					for c, diff := range []int{int(r>>8) - int(wr>>8), int(g>>8) - int(wg>>8), int(b>>8) - int(wb>>8)} {
+						if diff < -4 || diff > 4 {
+							t.Errorf("%s: channel %d of pixel %d,%d is off by %d in the image", pixFmt, c, x, y, diff)
+							break compare
+						}
+					}
+				}
+			}
+			sink.frames.FinishedReading(frame)
+		}
+	})
+}
@@ -11,20 +11,22 @@ import (
 
 var shaderCache map[string]uint32
 
+// BuildGLProgram builds the program that draws a layer of a stage. It does
+// not depend on how many sources or layers there are.
 func BuildGLProgram(shaderData *ShaderData) (uint32, error) {
 	shaderer, err := NewShaderer()
 	if err != nil {
 		return 0, fmt.Errorf("could not get shaders: %w", err)
 	}
 
-	vertexShader, err := shaderer.GetShaderSource("screen.vert", shaderData)
+	vertexShader, err := shaderer.GetShaderSource("layer.vert", shaderData)
 	if err != nil {
 		return 0, fmt.Errorf("could not get vertex shader: %w", err)
 	}
 
-	fragmentShader, err := shaderer.GetShaderSource("composite.frag", shaderData)
+	fragmentShader, err := shaderer.GetShaderSource("layer.frag", shaderData)
 	if err != nil {
-		return 0, fmt.Errorf("could not get vertex shader: %w", err)
+		return 0, fmt.Errorf("could not get fragment shader: %w", err)
 	}
 
 	writeFileDebug("/tmp/shader.vert", vertexShader)
@@ -38,6 +40,32 @@ func BuildGLProgram(shaderData *ShaderData) (uint32, error) {
 	return program, nil
 }
 
+// BuildYUVProgram builds the program that converts the output of a stage
+// to YUV for sinks that want it
+func BuildYUVProgram() (uint32, error) {
+	shaderer, err := NewShaderer()
+	if err != nil {
+		return 0, fmt.Errorf("could not get shaders: %w", err)
+	}
+
+	vertexShader, err := shaderer.GetShaderSource("screen.vert", &ShaderData{})
+	if err != nil {
+		return 0, fmt.Errorf("could not get vertex shader: %w", err)
+	}
+
+	fragmentShader, err := shaderer.GetShaderSource("yuv.frag", &ShaderData{})
+	if err != nil {
+		return 0, fmt.Errorf("could not get fragment shader: %w", err)
+	}
+
+	program, err := newProgram(vertexShader, fragmentShader)
+	if err != nil {
+		return 0, fmt.Errorf("could not init shader: %w", err)
+	}
+
+	return program, nil
+}
+
 func newProgram(vertexShaderSource, fragmentShaderSource string) (uint32, error) {
 	// FIXME: do we need this cache at all? isn't this called only once?
 	// If we do, maybe we should put it into the shaderer
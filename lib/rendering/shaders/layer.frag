@@ -0,0 +1,143 @@
+#version 400
+
+// tpos runs from 0 to 1 over the layer
+in vec2 tpos;
+
+out vec4 color;
+
+// the layer's source, or -1 when it shows the fallback colour
+uniform int sourceIndex;
+uniform uint sourceType;
+uniform sampler2D tex[3];
+uniform mat4 yuvMatrix;
+uniform float opacity;
+
+// yuvToRGB takes samples between 0 and 1 through the colorspace and range
+// of the source
+vec3 yuvToRGB(vec3 yuv) {
+	return (yuvMatrix * vec4(yuv, 1.0)).rgb;
+}
+
+// scale stretches samples that do not fill their texture's range, such as
+// 10-bit ones in the low end of 16 bits
+vec4 sampleLayerYUV422(float scale) {
+	float Y = texture(tex[0], tpos).r * scale;
+	float Cb = texture(tex[1], tpos).r * scale;
+	float Cr = texture(tex[2], tpos).r * scale;
+	vec3 yuv = vec3(Y, Cb, Cr);
+	vec3 col = yuvToRGB(yuv);
+	return vec4(col.r, col.g, col.b, opacity);
+}
+
+vec4 sampleLayerDebugBBox() {
+	return vec4(0, 0.1 * sourceIndex, tpos.x, 1.0);
+}
+
+vec4 sampleLayerYUYV() {
+	vec4 src = texture(tex[0], tpos);
+	int width = textureSize(tex[0], 0).x;
+	float fpix = fract(tpos.x * width);
+	float Y = fpix * src.b + (1.0-fpix) * src.r;
+	vec3 yuv = vec3(Y, src.g, src.a);
+	vec3 col = yuvToRGB(yuv);
+	return vec4(col.r, col.g, col.b, opacity);
+}
+
+vec4 sampleLayerNV12() {
+	float Y = texture(tex[0], tpos).r;
+	vec2 CbCr = texture(tex[1], tpos).rg;
+	vec3 yuv = vec3(Y, CbCr.x, CbCr.y);
+	vec3 col = yuvToRGB(yuv);
+	return vec4(col.r, col.g, col.b, opacity);
+}
+
+// sampleLayerV210 unpacks 6 pixels from every 4 texels, which hold
+// Cb0 Y0 Cr0 | Y1 Cb2 Y2 | Cr2 Y3 Cb4 | Y4 Cr4 Y5 in their red, green and
+// blue. Lines that are not a multiple of 6 pixels get stretched a little
+// to the last whole group.
+vec4 sampleLayerV210() {
+	ivec2 size = textureSize(tex[0], 0);
+	int width = size.x / 4 * 6;
+	int x = clamp(int(tpos.x * width), 0, width - 1);
+	int y = clamp(int(tpos.y * size.y), 0, size.y - 1);
+	int word = x / 6 * 4;
+	vec3 w0 = texelFetch(tex[0], ivec2(word, y), 0).rgb;
+	vec3 w1 = texelFetch(tex[0], ivec2(word + 1, y), 0).rgb;
+	vec3 w2 = texelFetch(tex[0], ivec2(word + 2, y), 0).rgb;
+	vec3 w3 = texelFetch(tex[0], ivec2(word + 3, y), 0).rgb;
+	float Ys[6] = float[](w0.g, w1.r, w1.b, w2.g, w3.r, w3.b);
+	float Cbs[3] = float[](w0.r, w1.g, w2.b);
+	float Crs[3] = float[](w0.b, w2.r, w3.g);
+	int i = x - x / 6 * 6;
+	vec3 yuv = vec3(Ys[i], Cbs[i / 2], Crs[i / 2]);
+	vec3 col = yuvToRGB(yuv);
+	return vec4(col.r, col.g, col.b, opacity);
+}
+
+vec4 sampleLayerGray() {
+	float Y = texture(tex[0], tpos).r;
+	// gray sources get a matrix that leaves chroma out
+	vec3 col = yuvToRGB(vec3(Y, 0, 0));
+	return vec4(col.r, col.g, col.b, opacity);
+}
+
+vec4 sampleLayerRGBA() {
+	vec4 col = texture(tex[0], tpos);
+	col.a *= opacity;
+	return col;
+}
+
+vec4 sampleLayerRGB() {
+	vec4 col = texture(tex[0], tpos);
+	col.a = 1.0;
+	return col;
+}
+
+vec4 sampleLayerFallback() {
+	return vec4(
+		{{ .FallbackColour.R }},
+		{{ .FallbackColour.G }},
+		{{ .FallbackColour.B }},
+		{{ .FallbackColour.A }}
+	);
+}
+
+vec4 sampleLayer() {
+	// return sampleLayerDebugBBox();
+	if (sourceIndex >= 0) {
+		// the planar formats only differ in the size of their chroma planes
+		if (sourceType == {{ .FrameType "YUV422" }} || sourceType == {{ .FrameType "YUV420" }}) {
+			return sampleLayerYUV422(1.0);
+		}
+		if (sourceType == {{ .FrameType "YUV422P10" }}) {
+			return sampleLayerYUV422(65535.0 / 1023.0);
+		}
+		// P010 keeps its 10 bits in the high end, so it samples like NV12
+		if (sourceType == {{ .FrameType "NV12" }} || sourceType == {{ .FrameType "P010" }}) {
+			return sampleLayerNV12();
+		}
+		if (sourceType == {{ .FrameType "V210" }}) {
+			return sampleLayerV210();
+		}
+		if (sourceType == {{ .FrameType "GRAY" }}) {
+			return sampleLayerGray();
+		}
+		if (sourceType == {{ .FrameType "YUV422p" }}) {
+			return sampleLayerYUYV();
+		}
+		// BGRA is swapped around when it is uploaded
+		if (sourceType == {{ .FrameType "RGBA" }} || sourceType == {{ .FrameType "BGRA" }}) {
+			return sampleLayerRGBA();
+		}
+		if (sourceType == {{ .FrameType "RGB" }}) {
+			return sampleLayerRGB();
+		}
+	}
+
+	return sampleLayerFallback();
+}
+
+// the layer is blended over the ones below it
+void main() {
+	color = sampleLayer();
+}
@@ -0,0 +1,23 @@
+#version 400
+
+out vec2 tpos;
+uniform uint stageData;
+// x, y, width and height of the layer, where 0 to 1 fills the stage
+uniform vec4 layerPosition;
+
+void main() {
+    vec2 orientation=vec2(0.5, -0.5);
+    if ((stageData & 1) != 0) {
+        orientation.y = 0.5;
+    }
+    if ((stageData & 2) != 0) {
+        orientation.x = -0.5;
+    }
+
+    // Generate a strip of two triangles over the layer, and map its
+    // position on the stage back to the viewport the way screen.vert does
+    vec2 corners[4]=vec2[4](vec2(0,0), vec2(1,0), vec2(0,1), vec2(1,1));
+    tpos = corners[gl_VertexID];
+    vec2 uv = layerPosition.xy + tpos * layerPosition.zw;
+    gl_Position = vec4((uv - vec2(0.5)) / orientation, 0, 1);
+}
@@ -34,8 +34,6 @@ func NewShaderer() (*Shaderer, error) {
 // ShaderData contains stuff that gets passed to the shader
 type ShaderData struct {
 	Sources        []layer.Source
-	NumSources     uint32
-	NumLayers      uint32
 	FallbackColour utils.Colour
 }
 
@@ -0,0 +1,53 @@
+#version 400
+
+out vec4 color;
+
+// rendered is the RGBA output of a stage, which gets converted into the
+// planes of a YUV frame. Every fragment is one byte of the frame, counted
+// from the start, so the whole frame can be read back in one go.
+uniform sampler2D rendered;
+uniform mat4 rgbMatrix;
+// subsampling is how many pixels across and down share their chroma
+uniform ivec2 subsampling;
+// interleaved chroma comes in Cb Cr pairs, like in NV12
+uniform bool interleaved;
+
+vec3 averageRGB(ivec2 block, ivec2 size) {
+	vec3 sum = vec3(0);
+	for (int dy = 0; dy < subsampling.y; dy++) {
+		for (int dx = 0; dx < subsampling.x; dx++) {
+			ivec2 pos = min(block * subsampling + ivec2(dx, dy), size - 1);
+			sum += texelFetch(rendered, pos, 0).rgb;
+		}
+	}
+	return sum / float(subsampling.x * subsampling.y);
+}
+
+void main() {
+	ivec2 size = textureSize(rendered, 0);
+	ivec2 frag = ivec2(gl_FragCoord.xy);
+	int idx = frag.y * size.x + frag.x;
+
+	int numPixels = size.x * size.y;
+	if (idx < numPixels) {
+		vec3 rgb = texelFetch(rendered, ivec2(idx % size.x, idx / size.x), 0).rgb;
+		color = vec4((rgbMatrix * vec4(rgb, 1.0)).x, 0, 0, 1);
+		return;
+	}
+	idx -= numPixels;
+
+	ivec2 chromaSize = size / subsampling;
+	int numChroma = chromaSize.x * chromaSize.y;
+	int component;
+	int c;
+	if (interleaved) {
+		component = 1 + idx % 2;
+		c = idx / 2;
+	} else {
+		component = 1 + idx / numChroma;
+		c = idx % numChroma;
+	}
+
+	vec3 rgb = averageRGB(ivec2(c % chromaSize.x, c / chromaSize.x), size);
+	color = vec4((rgbMatrix * vec4(rgb, 1.0))[component], 0, 0, 1);
+}
@@ -3,6 +3,7 @@ package rendering
 import (
 	_ "image/jpeg"
 	_ "image/png"
+	"unsafe"
 
 	"github.com/fosdem/fazantix/lib/encdec"
 	"github.com/fosdem/fazantix/lib/layer"
@@ -19,6 +20,24 @@ func SetupTextures(f *layer.FrameForwarder) {
 		f.TextureIDs[0] = SetupYUVTexture(width, height)
 		f.TextureIDs[1] = SetupYUVTexture(width/2, height)
 		f.TextureIDs[2] = SetupYUVTexture(width/2, height)
+	case encdec.YUV420Frames:
+		f.TextureIDs[0] = SetupYUVTexture(width, height)
+		f.TextureIDs[1] = SetupYUVTexture(width/2, height/2)
+		f.TextureIDs[2] = SetupYUVTexture(width/2, height/2)
+	case encdec.NV12Frames:
+		f.TextureIDs[0] = SetupYUVTexture(width, height)
+		f.TextureIDs[1] = SetupRGTexture(width/2, height/2)
+	case encdec.GrayFrames:
+		f.TextureIDs[0] = SetupYUVTexture(width, height)
+	case encdec.P010Frames:
+		f.TextureIDs[0] = SetupYUV16Texture(width, height, gl.RED)
+		f.TextureIDs[1] = SetupYUV16Texture(width/2, height/2, gl.RG)
+	case encdec.YUV422p10Frames:
+		f.TextureIDs[0] = SetupYUV16Texture(width, height, gl.RED)
+		f.TextureIDs[1] = SetupYUV16Texture(width/2, height, gl.RED)
+		f.TextureIDs[2] = SetupYUV16Texture(width/2, height, gl.RED)
+	case encdec.V210Frames:
+		f.TextureIDs[0] = SetupV210Texture(encdec.V210Words(width), height)
 	case encdec.RGBAFrames:
 		f.TextureIDs[0] = SetupRGBATexture(width, height, gl.RGBA)
 	case encdec.BGRAFrames:
@@ -78,6 +97,96 @@ func SetupYUVTexture(width int, height int) uint32 {
 	return id
 }
 
+// SetupRGTexture makes a texture for a plane of interleaved Cb and Cr
+// samples, which end up in the red and green channels
+func SetupRGTexture(width int, height int) uint32 {
+	var id uint32
+	gl.GenTextures(1, &id)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, id)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+	buf := make([]uint8, width*height*2)
+	gl.TexImage2D(
+		gl.TEXTURE_2D,
+		0,
+		gl.RG,
+		int32(width),
+		int32(height),
+		0,
+		gl.RG,
+		gl.UNSIGNED_BYTE,
+		gl.Ptr(&buf[0]),
+	)
+	return id
+}
+
+// SetupYUV16Texture makes a texture for a plane of 16-bit samples, format
+// is gl.RED for one sample per pixel or gl.RG for interleaved Cb and Cr
+func SetupYUV16Texture(width int, height int, format uint32) uint32 {
+	internalFormat := int32(gl.R16)
+	channels := 1
+	if format == gl.RG {
+		internalFormat = gl.RG16
+		channels = 2
+	}
+
+	var id uint32
+	gl.GenTextures(1, &id)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, id)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+	buf := make([]uint16, width*height*channels)
+	gl.TexImage2D(
+		gl.TEXTURE_2D,
+		0,
+		internalFormat,
+		int32(width),
+		int32(height),
+		0,
+		format,
+		gl.UNSIGNED_SHORT,
+		gl.Ptr(&buf[0]),
+	)
+	return id
+}
+
+// SetupV210Texture makes a texture with a texel for every 32-bit word of
+// v210, so the shader can pick the 10-bit samples out of its red, green
+// and blue. The words are only ever fetched whole, so there is no
+// filtering.
+func SetupV210Texture(width int, height int) uint32 {
+	var id uint32
+	gl.GenTextures(1, &id)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, id)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+	buf := make([]uint32, width*height)
+	gl.TexImage2D(
+		gl.TEXTURE_2D,
+		0,
+		gl.RGB10_A2,
+		int32(width),
+		int32(height),
+		0,
+		gl.RGBA,
+		gl.UNSIGNED_INT_2_10_10_10_REV,
+		gl.Ptr(&buf[0]),
+	)
+	return id
+}
+
 func SetupRGBATexture(width int, height int, packing uint32) uint32 {
 	var id uint32
 	gl.GenTextures(1, &id)
@@ -152,14 +261,16 @@ func UseTextureAsFramebuffer(textureID uint32) uint32 {
 
 var TextureUploadCounter uint64
 
-func SendTextureToGPU(texID uint32, offset int, w int, h int, channelType uint32, data []byte) {
-	gl.ActiveTexture(uint32(gl.TEXTURE0 + offset))
+// SendTextureToGPU uploads pixels into a texture, which point either into
+// Go memory or into the bound pixel unpack buffer
+func SendTextureToGPU(texID uint32, w int, h int, channelType uint32, pixelType uint32, pixels unsafe.Pointer, size int) {
+	gl.ActiveTexture(gl.TEXTURE0)
 	gl.BindTexture(gl.TEXTURE_2D, texID)
 	gl.TexSubImage2D(
 		gl.TEXTURE_2D,
 		0, 0, 0,
 		int32(w), int32(h),
-		channelType, gl.UNSIGNED_BYTE, gl.Ptr(data),
+		channelType, pixelType, pixels,
 	)
-	TextureUploadCounter += uint64(len(data))
+	TextureUploadCounter += uint64(size)
 }
@@ -0,0 +1,202 @@
+package rendering
+
+import (
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/fosdem/fazantix/lib/encdec"
+	"github.com/fosdem/fazantix/lib/layer"
+	"github.com/fosdem/fazantix/lib/metrics"
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Uploader sends fresh frames of sources to their textures. Frames are
+// copied into a pixel buffer object for each source, which the copies of
+// all sources do at the same time off the render thread, and the textures
+// are then filled from those buffers without waiting for the GPU.
+//
+// Only sources that are shown on a stage are uploaded. A source that is not
+// keeps its frames unread, so its latest one is uploaded as soon as it is
+// shown again.
+type Uploader struct {
+	sources   []layer.Source
+	fallbacks []int32
+	// visible marks the sources that the stages drawn this frame show
+	visible []bool
+	buffers []uint32
+	sizes   []int
+	timings []prometheus.Observer
+}
+
+type stagedFrame struct {
+	source int
+	frame  *encdec.Frame
+	data   unsafe.Pointer
+	took   time.Duration
+}
+
+var uploadTimes struct {
+	sync.Mutex
+	total map[string]time.Duration
+	count map[string]int
+}
+
+// UploadTimes is the average time each source took to upload a frame since
+// the last call
+func UploadTimes() map[string]time.Duration {
+	uploadTimes.Lock()
+	defer uploadTimes.Unlock()
+
+	times := make(map[string]time.Duration, len(uploadTimes.total))
+	for name, total := range uploadTimes.total {
+		times[name] = total / time.Duration(uploadTimes.count[name])
+	}
+	uploadTimes.total = nil
+	uploadTimes.count = nil
+	return times
+}
+
+func recordUploadTime(name string, took time.Duration) {
+	uploadTimes.Lock()
+	defer uploadTimes.Unlock()
+
+	if uploadTimes.total == nil {
+		uploadTimes.total = make(map[string]time.Duration)
+		uploadTimes.count = make(map[string]int)
+	}
+	uploadTimes.total[name] += took
+	uploadTimes.count[name]++
+}
+
+// NewUploader has to be called on the render thread
+func NewUploader(sources []layer.Source, fallbackSourceIndices []int32) *Uploader {
+	u := &Uploader{
+		sources:   sources,
+		fallbacks: fallbackSourceIndices,
+		visible:   make([]bool, len(sources)),
+		buffers:   make([]uint32, len(sources)),
+		sizes:     make([]int, len(sources)),
+		timings:   make([]prometheus.Observer, len(sources)),
+	}
+	if len(sources) > 0 {
+		gl.GenBuffers(int32(len(sources)), &u.buffers[0])
+	}
+	for i, source := range sources {
+		u.timings[i] = metrics.SourceUploadTime.WithLabelValues(source.Frames().Name)
+	}
+	return u
+}
+
+// SendFramesToGPU ages every source and uploads the ones with a new frame
+// that are shown on any of the stages about to be drawn
+func (u *Uploader) SendFramesToGPU(dt time.Duration, stages []*layer.Stage) {
+	for i, source := range u.sources {
+		source.Frames().Age(dt)
+		u.visible[i] = false
+	}
+	var shown []int
+	for _, stage := range stages {
+		shown = append(shown, u.markVisible(stage)...)
+	}
+	u.upload(shown)
+}
+
+// CatchUp uploads the sources a stage shows that were not visible yet when
+// the frames were sent, like after a cut. It has to be called right before
+// the stage is drawn.
+func (u *Uploader) CatchUp(stage *layer.Stage) {
+	if shown := u.markVisible(stage); len(shown) > 0 {
+		u.upload(shown)
+	}
+}
+
+// markVisible marks the sources of the visible layers of a stage, along with
+// the fallbacks that are drawn in their place when they are not ready. It
+// returns the ones that were not marked yet.
+func (u *Uploader) markVisible(stage *layer.Stage) []int {
+	var marked []int
+	for i, l := range stage.Layers {
+		if !l.Visible() {
+			continue
+		}
+		for idx := stage.SourceIndices[i]; idx != -1; idx = u.fallbacks[idx] {
+			if !u.visible[idx] {
+				u.visible[idx] = true
+				marked = append(marked, int(idx))
+			}
+			if u.sources[idx].Frames().IsReady {
+				break
+			}
+		}
+	}
+	return marked
+}
+
+func (u *Uploader) upload(shown []int) {
+	var staged []*stagedFrame
+
+	for _, i := range shown {
+		frames := u.sources[i].Frames()
+		frame := frames.GetFreshFrameForReading()
+		if frame == nil {
+			continue // we are instructed to drop the frame
+		}
+
+		start := time.Now()
+		gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, u.buffers[i])
+		if u.sizes[i] != len(frame.Data) {
+			u.sizes[i] = len(frame.Data)
+			gl.BufferData(gl.PIXEL_UNPACK_BUFFER, u.sizes[i], nil, gl.STREAM_DRAW)
+		}
+		// invalidating orphans the buffer, so the GPU can still read the
+		// last frame from it while the next one is copied in
+		data := gl.MapBufferRange(
+			gl.PIXEL_UNPACK_BUFFER, 0, u.sizes[i],
+			gl.MAP_WRITE_BIT|gl.MAP_INVALIDATE_BUFFER_BIT,
+		)
+		if data == nil {
+			gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, 0)
+			SendFrameToGPU(frame, frames.TextureIDs, false)
+			frames.FinishedReading(frame)
+			u.record(i, time.Since(start))
+			continue
+		}
+		staged = append(staged, &stagedFrame{
+			source: i,
+			frame:  frame,
+			data:   data,
+			took:   time.Since(start),
+		})
+	}
+	gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, 0)
+
+	var wg sync.WaitGroup
+	for _, s := range staged {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			copy(unsafe.Slice((*byte)(s.data), len(s.frame.Data)), s.frame.Data)
+			s.took += time.Since(start)
+		}()
+	}
+	wg.Wait()
+
+	for _, s := range staged {
+		start := time.Now()
+		frames := u.sources[s.source].Frames()
+		gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, u.buffers[s.source])
+		gl.UnmapBuffer(gl.PIXEL_UNPACK_BUFFER)
+		SendFrameToGPU(s.frame, frames.TextureIDs, true)
+		frames.FinishedReading(s.frame)
+		u.record(s.source, s.took+time.Since(start))
+	}
+	gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, 0)
+}
+
+func (u *Uploader) record(source int, took time.Duration) {
+	u.timings[source].Observe(took.Seconds())
+	recordUploadTime(u.sources[source].Frames().Name, took)
+}
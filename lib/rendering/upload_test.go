@@ -0,0 +1,154 @@
+package rendering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/encdec"
+	"github.com/fosdem/fazantix/lib/layer"
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+type testSource struct {
+	testSink
+}
+
+func (s *testSource) Start() bool {
+	return true
+}
+
+func newTestSources(n int) []layer.Source {
+	var sources []layer.Source
+	for range n {
+		source := &testSource{}
+		source.frames.Init("rgba", &encdec.FrameInfo{
+			FrameCfg:  encdec.FrameCfg{Width: 8, Height: 4, NumAllocatedFrames: 2},
+			FrameType: encdec.RGBAFrames,
+		}, &encdec.DumbFrameAllocator{})
+		SetupTextures(source.Frames())
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// testStage has a layer for each source with the given opacities
+func testStage(sources []layer.Source, opacities ...float32) *layer.Stage {
+	stage := &layer.Stage{}
+	for i, source := range sources {
+		l := layer.New(uint32(i), source, 8, 4)
+		l.Position = layer.Coordinate{}
+		l.Opacity = opacities[i]
+		stage.Layers = append(stage.Layers, l)
+		stage.SourceIndices = append(stage.SourceIndices, int32(i))
+		stage.SourceTypes = append(stage.SourceTypes, source.Frames().FrameType)
+	}
+	return stage
+}
+
+func writeTestFrame(t *testing.T, source layer.Source) {
+	frames := source.Frames()
+	frame := frames.GetFrameForWriting()
+	if err := encdec.Prepare(frame); err != nil {
+		t.Fatalf("could not prepare frame: %s", err)
+	}
+	frames.FinishedWriting(frame)
+}
+
+func TestUploadOnlyVisibleSources(t *testing.T) {
+	withGL(t, func() {
+		// the first layer falls back to the second source, which has no
+		// layer that shows it, and the third layer is hidden
+		sources := newTestSources(3)
+		stage := testStage(sources, 1, 0, 0)
+		uploader := NewUploader(sources, []int32{1, -1, -1})
+
+		uploaded := func(want ...bool) {
+			t.Helper()
+			for i, source := range sources {
+				frames := source.Frames()
+				got := frames.LastReadFrameID == frames.LastWrittenFrameID
+				if got != want[i] {
+					t.Errorf("source %d: expected uploaded to be %v", i, want[i])
+				}
+			}
+		}
+
+		for _, source := range sources {
+			writeTestFrame(t, source)
+		}
+		uploader.SendFramesToGPU(time.Millisecond, []*layer.Stage{stage})
+		uploaded(true, false, false)
+
+		// every source times out, but only the first one stays stopped, so
+		// its fallback is shown instead
+		uploader.SendFramesToGPU(2*time.Second, []*layer.Stage{stage})
+		writeTestFrame(t, sources[1])
+		writeTestFrame(t, sources[2])
+		uploader.SendFramesToGPU(time.Millisecond, []*layer.Stage{stage})
+		uploaded(true, true, false)
+
+		// a cut after the frames were sent is caught up before drawing
+		stage.Layers[2].ApplyState(&layer.LayerState{
+			LayerTransform: layer.LayerTransform{Opacity: 1, Scale: 1},
+		}, false)
+		uploader.CatchUp(stage)
+		uploaded(true, true, true)
+	})
+}
+
+func TestUploadThroughBuffers(t *testing.T) {
+	withGL(t, func() {
+		var sources []layer.Source
+		for _, pixFmt := range []string{"rgba", "yuv420p"} {
+			frameType, _ := encdec.ParsePixFmt(pixFmt)
+			source := &testSource{}
+			source.frames.Init(pixFmt, &encdec.FrameInfo{
+				FrameCfg:  encdec.FrameCfg{Width: 8, Height: 4, NumAllocatedFrames: 2},
+				FrameType: frameType,
+			}, &encdec.DumbFrameAllocator{})
+			SetupTextures(source.Frames())
+			sources = append(sources, source)
+		}
+		uploader := NewUploader(sources, []int32{-1, -1})
+		stage := testStage(sources, 1, 1)
+
+		// twice, so the second frame goes into an orphaned buffer
+		for round := range 2 {
+			for _, source := range sources {
+				frames := source.Frames()
+				frame := frames.GetFrameForWriting()
+				if err := encdec.Prepare(frame); err != nil {
+					t.Fatalf("could not prepare frame: %s", err)
+				}
+				for i := range frame.Data {
+					frame.Data[i] = byte(i*7 + round)
+				}
+				frames.FinishedWriting(frame)
+			}
+			uploader.SendFramesToGPU(time.Millisecond, []*layer.Stage{stage})
+
+			for _, source := range sources {
+				frames := source.Frames()
+				frame := frames.GetAnyFrameForReading()
+				for j := range frame.NumTextures {
+					want, _, _ := frame.Texture(j)
+					got := make([]byte, len(want))
+					channelType, pixelType := pixelLayout(frame.Type, j)
+					gl.BindTexture(gl.TEXTURE_2D, frames.TextureIDs[j])
+					gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+					gl.GetTexImage(gl.TEXTURE_2D, 0, channelType, pixelType, gl.Ptr(got))
+					gl.PixelStorei(gl.PACK_ALIGNMENT, 4)
+					if string(got) != string(want) {
+						t.Errorf("%s plane %d round %d: uploaded %v, texture has %v", frames.Name, j, round, want, got)
+					}
+				}
+				frames.FinishedReading(frame)
+			}
+		}
+
+		times := UploadTimes()
+		if len(times) != len(sources) {
+			t.Errorf("expected upload times for every source, got %v", times)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+// icalLine is a content line, as in NAME;PARAM=VALUE:value
+type icalLine struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// ParseICal reads the VEVENTs from an iCalendar file. Only what is needed
+// to switch scenes is supported: DTSTART with DTEND or DURATION, SUMMARY,
+// LOCATION, UID and the names of the ATTENDEEs. All-day events are skipped.
+func ParseICal(r io.Reader) ([]*theatre.ScheduledEvent, error) {
+	lines, err := unfoldICal(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ical: %w", err)
+	}
+
+	var events []*theatre.ScheduledEvent
+	var event *theatre.ScheduledEvent
+	var duration time.Duration
+	allDay := false
+	for i, line := range lines {
+		switch {
+		case line.name == "BEGIN" && line.value == "VEVENT":
+			event = &theatre.ScheduledEvent{}
+			duration = 0
+			allDay = false
+		case line.name == "END" && line.value == "VEVENT":
+			if event == nil {
+				return nil, fmt.Errorf("line %d: END:VEVENT without BEGIN", i+1)
+			}
+			if allDay {
+				event = nil
+				continue
+			}
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("event %s has no start", event.ID)
+			}
+			if event.End.IsZero() {
+				event.End = event.Start.Add(duration)
+			}
+			events = append(events, event)
+			event = nil
+		case event == nil:
+			continue
+		case line.name == "UID":
+			event.ID = line.value
+		case line.name == "SUMMARY":
+			event.Title = unescapeICal(line.value)
+		case line.name == "LOCATION":
+			event.Room = unescapeICal(line.value)
+		case line.name == "ATTENDEE":
+			if name := line.params["CN"]; name != "" {
+				event.Persons = append(event.Persons, name)
+			}
+		case line.name == "DTSTART" || line.name == "DTEND":
+			if line.params["VALUE"] == "DATE" {
+				allDay = true
+				continue
+			}
+			t, err := parseICalTime(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if line.name == "DTSTART" {
+				event.Start = t
+			} else {
+				event.End = t
+			}
+		case line.name == "DURATION":
+			duration, err = parseICalDuration(line.value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+	}
+	return events, nil
+}
+
+func unfoldICal(r io.Reader) ([]icalLine, error) {
+	var raw []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if len(raw) > 0 && (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) {
+			raw[len(raw)-1] += text[1:]
+			continue
+		}
+		raw = append(raw, text)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	lines := make([]icalLine, 0, len(raw))
+	for _, text := range raw {
+		if text == "" {
+			continue
+		}
+		lines = append(lines, parseICalLine(text))
+	}
+	return lines, nil
+}
+
+func parseICalLine(text string) icalLine {
+	line := icalLine{params: make(map[string]string)}
+	// the value starts at the first colon outside of a quoted parameter
+	quoted := false
+	end := len(text)
+	for i, c := range text {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			end = i
+			break
+		}
+	}
+	head := text[:end]
+	if end < len(text) {
+		line.value = text[end+1:]
+	}
+	parts := strings.Split(head, ";")
+	line.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		line.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+	return line
+}
+
+func parseICalTime(line icalLine) (time.Time, error) {
+	value := line.value
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	loc := time.Local
+	if tzid := line.params["TZID"]; tzid != "" {
+		var err error
+		loc, err = time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone %s: %w", tzid, err)
+		}
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+var icalDurationRe = regexp.MustCompile(`^P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+func parseICalDuration(s string) (time.Duration, error) {
+	m := icalDurationRe.FindStringSubmatch(strings.TrimPrefix(s, "+"))
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(m[i+1])
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+var icalUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func unescapeICal(s string) string {
+	return icalUnescaper.Replace(s)
+}
@@ -0,0 +1,97 @@
+package schedule
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+type pentabarfSchedule struct {
+	Days []struct {
+		Date  string `xml:"date,attr"`
+		Rooms []struct {
+			Name   string           `xml:"name,attr"`
+			Events []pentabarfEvent `xml:"event"`
+		} `xml:"room"`
+	} `xml:"day"`
+}
+
+type pentabarfEvent struct {
+	ID       string   `xml:"id,attr"`
+	Date     string   `xml:"date"`
+	Start    string   `xml:"start"`
+	Duration string   `xml:"duration"`
+	Room     string   `xml:"room"`
+	Title    string   `xml:"title"`
+	Persons  []string `xml:"persons>person"`
+}
+
+// ParsePentabarf reads a schedule in the Pentabarf XML format, as exported
+// by pretalx and the FOSDEM website. Events without a full date are taken to
+// be in the local time zone.
+func ParsePentabarf(r io.Reader) ([]*theatre.ScheduledEvent, error) {
+	var sched pentabarfSchedule
+	err := xml.NewDecoder(r).Decode(&sched)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse pentabarf xml: %w", err)
+	}
+
+	var events []*theatre.ScheduledEvent
+	for _, day := range sched.Days {
+		for _, room := range day.Rooms {
+			for _, e := range room.Events {
+				start, err := pentabarfStart(day.Date, &e)
+				if err != nil {
+					return nil, fmt.Errorf("event %s: %w", e.ID, err)
+				}
+				duration, err := parseClockDuration(e.Duration)
+				if err != nil {
+					return nil, fmt.Errorf("event %s: %w", e.ID, err)
+				}
+				event := &theatre.ScheduledEvent{
+					ID:      e.ID,
+					Title:   strings.TrimSpace(e.Title),
+					Room:    strings.TrimSpace(e.Room),
+					Persons: e.Persons,
+					Start:   start,
+					End:     start.Add(duration),
+				}
+				if event.Room == "" {
+					event.Room = room.Name
+				}
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
+func pentabarfStart(day string, e *pentabarfEvent) (time.Time, error) {
+	if e.Date != "" {
+		return time.Parse(time.RFC3339, strings.TrimSpace(e.Date))
+	}
+	return time.ParseInLocation("2006-01-02 15:04", day+" "+strings.TrimSpace(e.Start), time.Local)
+}
+
+// parseClockDuration parses durations written as HH:MM or HH:MM:SS
+func parseClockDuration(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var d time.Duration
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d += time.Duration(n) * units[i]
+	}
+	return d, nil
+}
@@ -0,0 +1,224 @@
+package schedule
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+const pentabarfXml = `<?xml version="1.0" encoding="UTF-8"?>
+<schedule>
+  <conference><title>FOSDEM 2025</title></conference>
+  <day index="1" date="2025-02-01">
+    <room name="H.1302 (Depage)">
+      <event id="101">
+        <date>2025-02-01T10:00:00+01:00</date>
+        <start>10:00</start>
+        <duration>00:50</duration>
+        <room>H.1302 (Depage)</room>
+        <title>Mixing video with shaders</title>
+        <persons><person id="1">Alice</person><person id="2">Bob</person></persons>
+      </event>
+      <event id="102">
+        <date>2025-02-01T11:00:00+01:00</date>
+        <start>11:00</start>
+        <duration>00:30</duration>
+        <room>H.1302 (Depage)</room>
+        <title>Lightning round</title>
+      </event>
+    </room>
+    <room name="K.1.105 (La Fontaine)">
+      <event id="201">
+        <date>2025-02-01T10:00:00+01:00</date>
+        <start>10:00</start>
+        <duration>01:00</duration>
+        <room>K.1.105 (La Fontaine)</room>
+        <title>Elsewhere</title>
+      </event>
+    </room>
+  </day>
+</schedule>
+`
+
+const icalFile = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:101@fosdem\r\n" +
+	"DTSTART;TZID=Europe/Brussels:20250201T100000\r\n" +
+	"DTEND;TZID=Europe/Brussels:20250201T105000\r\n" +
+	"SUMMARY:Mixing video with\r\n  shaders\r\n" +
+	"LOCATION:H.1302 (Depage)\r\n" +
+	"ATTENDEE;ROLE=REQ-PARTICIPANT;CN=\"Alice\":invalid:nomail\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:102@fosdem\r\n" +
+	"DTSTART:20250201T100000Z\r\n" +
+	"DURATION:PT30M\r\n" +
+	"SUMMARY:Lightning\\, round\r\n" +
+	"LOCATION:H.1302 (Depage)\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:all-day\r\n" +
+	"DTSTART;VALUE=DATE:20250201\r\n" +
+	"SUMMARY:Conference\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParsePentabarf(t *testing.T) {
+	events, err := ParsePentabarf(strings.NewReader(pentabarfXml))
+	if err != nil {
+		t.Fatalf("could not parse: %s", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	e := events[0]
+	start := time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC)
+	if e.ID != "101" || e.Title != "Mixing video with shaders" || e.Room != "H.1302 (Depage)" ||
+		!e.Start.Equal(start) || !e.End.Equal(start.Add(50*time.Minute)) ||
+		len(e.Persons) != 2 || e.Persons[1] != "Bob" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestParseICal(t *testing.T) {
+	events, err := ParseICal(strings.NewReader(icalFile))
+	if err != nil {
+		t.Fatalf("could not parse: %s", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, the all-day one skipped, got %d", len(events))
+	}
+	start := time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC)
+	e := events[0]
+	if e.ID != "101@fosdem" || e.Title != "Mixing video with shaders" || e.Room != "H.1302 (Depage)" ||
+		!e.Start.Equal(start) || !e.End.Equal(start.Add(50*time.Minute)) ||
+		len(e.Persons) != 1 || e.Persons[0] != "Alice" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	e = events[1]
+	if e.Title != "Lightning, round" || !e.Start.Equal(start.Add(time.Hour)) || e.End.Sub(e.Start) != 30*time.Minute {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"schedule.xml": pentabarfXml, "schedule.ics": icalFile} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("could not write %s: %s", name, err)
+		}
+		events, err := Load(path)
+		if err != nil || len(events) < 2 {
+			t.Errorf("could not load %s: %v", name, err)
+		}
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestTheatre(t *testing.T) *theatre.Theatre {
+	return theatretest.New(t, func(cfg *config.Config) {
+		cfg.Scenes["holding"] = theatretest.FullScreen("slides")
+		cfg.Stages["projector"].DefaultScene = "holding"
+	})
+}
+
+func TestScheduler(t *testing.T) {
+	th := newTestTheatre(t)
+	s := New(&config.ScheduleCfg{
+		Room:     "H.1302 (Depage)",
+		EndingMs: 5 * 60 * 1000,
+		Stages: map[string]*config.ScheduleScenesCfg{
+			"projector": {Holding: "holding", Talk: "full-cam", Ending: "full-slides"},
+		},
+	}, th)
+	clock := &fakeClock{}
+	s.Clock = clock
+	events, err := ParsePentabarf(strings.NewReader(pentabarfXml))
+	if err != nil {
+		t.Fatalf("could not parse: %s", err)
+	}
+	s.SetEvents(events)
+
+	stage := th.Stages["projector"]
+	start := time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC)
+	steps := []struct {
+		at      time.Duration
+		phase   string
+		current string
+		next    string
+		scene   string
+	}{
+		// the first update only takes note, so the stage stays on holding
+		{-10 * time.Minute, theatre.PhaseHolding, "", "101", "holding"},
+		{0, theatre.PhaseTalk, "101", "102", "full-cam"},
+		{49 * time.Minute, theatre.PhaseTalk, "101", "102", "full-cam"},
+		{50 * time.Minute, theatre.PhaseEnding, "101", "102", "full-slides"},
+		{55 * time.Minute, theatre.PhaseHolding, "", "102", "holding"},
+		{60 * time.Minute, theatre.PhaseTalk, "102", "", "full-cam"},
+		{92 * time.Minute, theatre.PhaseEnding, "102", "", "full-slides"},
+		{2 * time.Hour, theatre.PhaseHolding, "", "", "holding"},
+	}
+	id := func(e *theatre.ScheduledEvent) string {
+		if e == nil {
+			return ""
+		}
+		return e.ID
+	}
+	for _, step := range steps {
+		clock.now = start.Add(step.at)
+		s.Update()
+		status := th.Schedule()
+		if status == nil {
+			t.Fatalf("%s: no schedule status", step.at)
+		}
+		if status.Phase != step.phase || id(status.Current) != step.current || id(status.Next) != step.next {
+			t.Errorf("%s: expected %s with current %q and next %q, got %s with %q and %q",
+				step.at, step.phase, step.current, step.next, status.Phase, id(status.Current), id(status.Next))
+		}
+		if stage.ActiveScene != step.scene {
+			t.Errorf("%s: expected scene %s, got %s", step.at, step.scene, stage.ActiveScene)
+		}
+	}
+
+	// an operator override sticks until the next boundary
+	clock.now = start.Add(3 * time.Hour)
+	if err := th.SetScene("projector", "full-cam", false); err != nil {
+		t.Fatalf("could not set scene: %s", err)
+	}
+	s.Update()
+	if stage.ActiveScene != "full-cam" {
+		t.Errorf("scheduler overrode the operator without passing a boundary")
+	}
+}
+
+func TestEndingCutShort(t *testing.T) {
+	th := newTestTheatre(t)
+	s := New(&config.ScheduleCfg{
+		EndingMs: 30 * 60 * 1000,
+		Stages: map[string]*config.ScheduleScenesCfg{
+			"projector": {Holding: "holding", Talk: "full-cam", Ending: "full-slides"},
+		},
+	}, th)
+	start := time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC)
+	s.SetEvents([]*theatre.ScheduledEvent{
+		{ID: "1", Start: start, End: start.Add(50 * time.Minute)},
+		{ID: "2", Start: start.Add(60 * time.Minute), End: start.Add(90 * time.Minute)},
+	})
+	status := s.StatusAt(start.Add(65 * time.Minute))
+	if status.Phase != theatre.PhaseTalk || status.Current.ID != "2" {
+		t.Errorf("expected the next talk to cut the ending short, got %s", status.Phase)
+	}
+}
@@ -0,0 +1,212 @@
+// Package schedule switches stages between a holding, a talk and an ending
+// scene following a published talk schedule.
+package schedule
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/audit"
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+const defaultEnding = time.Minute
+
+// Clock tells the time, tests replace it by a fake one
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+type Scheduler struct {
+	cfg     *config.ScheduleCfg
+	theatre *theatre.Theatre
+	logger  *slog.Logger
+	ending  time.Duration
+
+	Clock Clock
+
+	events  []*theatre.ScheduledEvent
+	modTime time.Time
+	status  *theatre.ScheduleStatus
+	done    chan struct{}
+}
+
+func New(cfg *config.ScheduleCfg, t *theatre.Theatre) *Scheduler {
+	s := &Scheduler{
+		cfg:     cfg,
+		theatre: t,
+		logger:  slog.Default().With(slog.String("module", "schedule")),
+		ending:  time.Duration(cfg.EndingMs) * time.Millisecond,
+		Clock:   realClock{},
+		done:    make(chan struct{}),
+	}
+	if s.ending == 0 {
+		s.ending = defaultEnding
+	}
+	return s
+}
+
+// Load reads a schedule file, Pentabarf XML unless its extension is .ics
+func Load(path string) ([]*theatre.ScheduledEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open schedule: %w", err)
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".ics") {
+		return ParseICal(f)
+	}
+	return ParsePentabarf(f)
+}
+
+// SetEvents replaces the schedule, keeping only the events in the
+// configured room
+func (s *Scheduler) SetEvents(events []*theatre.ScheduledEvent) {
+	var kept []*theatre.ScheduledEvent
+	for _, event := range events {
+		if s.cfg.Room == "" || event.Room == s.cfg.Room {
+			kept = append(kept, event)
+		}
+	}
+	slices.SortFunc(kept, func(a, b *theatre.ScheduledEvent) int {
+		return a.Start.Compare(b.Start)
+	})
+	s.events = kept
+}
+
+// reload reads the schedule file again if it changed since the last time,
+// so an updated schedule can be dropped in while running
+func (s *Scheduler) reload() error {
+	info, err := os.Stat(string(s.cfg.Path))
+	if err != nil {
+		return fmt.Errorf("could not stat schedule: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	events, err := Load(string(s.cfg.Path))
+	if err != nil {
+		return err
+	}
+	s.modTime = info.ModTime()
+	s.SetEvents(events)
+	s.logger.Info(fmt.Sprintf("loaded %d events from %s", len(s.events), s.cfg.Path))
+	return nil
+}
+
+// StatusAt works out the phase and the current and next event at now
+func (s *Scheduler) StatusAt(now time.Time) theatre.ScheduleStatus {
+	status := theatre.ScheduleStatus{Phase: theatre.PhaseHolding}
+	for _, event := range s.events {
+		switch {
+		case !now.Before(event.Start) && now.Before(event.End):
+			status.Phase = theatre.PhaseTalk
+			status.Current = event
+		case !now.Before(event.End) && now.Before(event.End.Add(s.ending)) && status.Phase == theatre.PhaseHolding:
+			status.Phase = theatre.PhaseEnding
+			status.Current = event
+		case event.Start.After(now) && status.Next == nil:
+			status.Next = event
+		}
+	}
+	return status
+}
+
+func sameEvent(a, b *theatre.ScheduledEvent) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ID == b.ID && a.Start.Equal(b.Start)
+}
+
+// Update switches the scheduled stages if an event boundary was passed
+// since the last call. The first call only takes note of where in the
+// schedule we are, so a restart does not undo what an operator did.
+func (s *Scheduler) Update() {
+	status := s.StatusAt(s.Clock.Now())
+	previous := s.status
+	if previous != nil && previous.Phase == status.Phase &&
+		sameEvent(previous.Current, status.Current) && sameEvent(previous.Next, status.Next) {
+		return
+	}
+	s.status = &status
+	s.theatre.SetSchedule(status)
+
+	if previous == nil || (previous.Phase == status.Phase && sameEvent(previous.Current, status.Current)) {
+		return
+	}
+	if status.Current != nil {
+		s.logger.Info(fmt.Sprintf("entering %s phase of %s", status.Phase, status.Current.Title))
+	} else {
+		s.logger.Info(fmt.Sprintf("entering %s phase", status.Phase))
+	}
+	for stageName, scenes := range s.cfg.Stages {
+		scene := scenes.Holding
+		switch status.Phase {
+		case theatre.PhaseTalk:
+			scene = scenes.Talk
+		case theatre.PhaseEnding:
+			scene = scenes.Ending
+		}
+		if scene == "" {
+			scene = scenes.Holding
+		}
+		err := s.theatre.SetScene(stageName, scene, true)
+		s.theatre.Audit.Record(audit.Entry{
+			Origin: "schedule",
+			Action: "set-scene",
+			Params: map[string]interface{}{
+				"stage": stageName,
+				"scene": scene,
+				"phase": status.Phase,
+			},
+		}, err)
+		if err != nil {
+			s.logger.Warn(fmt.Sprintf("could not switch stage %s to %s: %s", stageName, scene, err))
+		}
+	}
+}
+
+func (s *Scheduler) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		err := s.reload()
+		if err != nil {
+			s.logger.Error(err.Error())
+		}
+		s.Update()
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) Stop() {
+	close(s.done)
+}
+
+func StartInBackground(t *theatre.Theatre, cfg *config.ScheduleCfg) *Scheduler {
+	if cfg == nil {
+		return nil
+	}
+	s := New(cfg, t)
+	err := s.reload()
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("could not load schedule, will keep trying: %s", err))
+	}
+	go s.run()
+	return s
+}
@@ -0,0 +1,258 @@
+package scripting
+
+import (
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/fosdem/fazantix/lib/audit"
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+// fingerprintSamples is how many bytes of a frame source_fingerprint hashes
+const fingerprintSamples = 4096
+
+// register sets up the fazantix table. Functions that can fail return true
+// on success and nil plus an error message otherwise, like io.open does.
+func (v *vm) register() {
+	v.L.SetGlobal("fazantix", v.L.SetFuncs(v.L.NewTable(), map[string]lua.LGFunction{
+		"set_scene":          v.luaSetScene,
+		"cue":                v.luaCue,
+		"take":               v.luaTake,
+		"set_transition":     v.luaSetTransition,
+		"start_macro":        v.luaStartMacro,
+		"stages":             v.luaStages,
+		"scenes":             v.luaScenes,
+		"sources":            v.luaSources,
+		"source":             v.luaSource,
+		"source_fingerprint": v.luaSourceFingerprint,
+		"on":                 v.luaOn,
+		"after":              v.luaAfter,
+		"every":              v.luaEvery,
+		"cancel":             v.luaCancel,
+		"log":                v.luaLog,
+		"now_ms":             v.luaNowMs,
+	}))
+}
+
+func (v *vm) theatre() *theatre.Theatre {
+	return v.script.host.theatre
+}
+
+func (v *vm) result(L *lua.LState, action string, params map[string]interface{}, err error) int {
+	params["script"] = v.script.name
+	v.theatre().Audit.Record(audit.Entry{
+		Origin: "script",
+		Action: action,
+		Params: params,
+	}, err)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// fazantix.set_scene(stage, scene[, transition = true])
+func (v *vm) luaSetScene(L *lua.LState) int {
+	stage := L.CheckString(1)
+	scene := L.CheckString(2)
+	transition := L.OptBool(3, true)
+	err := v.theatre().SetScene(stage, scene, transition)
+	return v.result(L, "set-scene", map[string]interface{}{
+		"stage": stage, "scene": scene, "transition": transition,
+	}, err)
+}
+
+// fazantix.cue(stage, scene)
+func (v *vm) luaCue(L *lua.LState) int {
+	stage := L.CheckString(1)
+	scene := L.CheckString(2)
+	err := v.theatre().Cue(stage, scene)
+	return v.result(L, "cue", map[string]interface{}{
+		"stage": stage, "scene": scene,
+	}, err)
+}
+
+// fazantix.take(stage[, transition = true])
+func (v *vm) luaTake(L *lua.LState) int {
+	stage := L.CheckString(1)
+	transition := L.OptBool(2, true)
+	err := v.theatre().Take(stage, transition)
+	return v.result(L, "take", map[string]interface{}{
+		"stage": stage, "transition": transition,
+	}, err)
+}
+
+// fazantix.set_transition(stage, ms)
+func (v *vm) luaSetTransition(L *lua.LState) int {
+	stage := L.CheckString(1)
+	ms := L.CheckInt(2)
+	var err error
+	if ms < 0 {
+		err = fmt.Errorf("transition time must be nonnegative")
+	} else {
+		err = v.theatre().SetTransitionSpeed(stage, time.Duration(ms)*time.Millisecond)
+	}
+	return v.result(L, "set-transition", map[string]interface{}{
+		"stage": stage, "transition_ms": ms,
+	}, err)
+}
+
+// fazantix.start_macro(name)
+func (v *vm) luaStartMacro(L *lua.LState) int {
+	name := L.CheckString(1)
+	err := v.theatre().StartMacro(theatre.Caller{}, name)
+	return v.result(L, "macro-start", map[string]interface{}{
+		"macro": name,
+	}, err)
+}
+
+// fazantix.stages() returns a table of stages by name
+func (v *vm) luaStages(L *lua.LState) int {
+	stages := L.NewTable()
+	t := v.theatre()
+	t.ReadStages(func() {
+		for name, stage := range t.Stages {
+			info := L.NewTable()
+			info.RawSetString("active_scene", lua.LString(stage.ActiveScene))
+			info.RawSetString("cued_scene", lua.LString(stage.CuedScene))
+			info.RawSetString("transition_ms", lua.LNumber(stage.TransitionTime.Milliseconds()))
+			info.RawSetString("locked_by", lua.LString(stage.LockedBy))
+			stages.RawSetString(name, info)
+		}
+	})
+	L.Push(stages)
+	return 1
+}
+
+// fazantix.scenes() returns the sorted scene names
+func (v *vm) luaScenes(L *lua.LState) int {
+	scenes := L.NewTable()
+	for _, name := range slices.Sorted(maps.Keys(v.theatre().Scenes)) {
+		scenes.Append(lua.LString(name))
+	}
+	L.Push(scenes)
+	return 1
+}
+
+func sourceHealthTable(L *lua.LState, health theatre.SourceHealth) *lua.LTable {
+	info := L.NewTable()
+	info.RawSetString("name", lua.LString(health.Name))
+	info.RawSetString("ready", lua.LBool(health.Ready))
+	info.RawSetString("frame_age_ms", lua.LNumber(health.FrameAgeMs))
+	return info
+}
+
+// fazantix.sources() returns the health of every source
+func (v *vm) luaSources(L *lua.LState) int {
+	sources := L.NewTable()
+	for _, health := range v.theatre().SourceHealth() {
+		sources.Append(sourceHealthTable(L, health))
+	}
+	L.Push(sources)
+	return 1
+}
+
+// fazantix.source(name) returns the health of one source
+func (v *vm) luaSource(L *lua.LState) int {
+	name := L.CheckString(1)
+	for _, health := range v.theatre().SourceHealth() {
+		if health.Name == name {
+			L.Push(sourceHealthTable(L, health))
+			return 1
+		}
+	}
+	L.Push(lua.LNil)
+	L.Push(lua.LString(fmt.Sprintf("no such source: %s", name)))
+	return 2
+}
+
+// fazantix.source_fingerprint(name) returns a hash of samples of the latest
+// frame of a source, which stays the same for as long as the picture does.
+// It returns nil if the source has no frame.
+func (v *vm) luaSourceFingerprint(L *lua.LState) int {
+	name := L.CheckString(1)
+	source := v.theatre().SourceByName(name)
+	if source == nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(fmt.Sprintf("no such source: %s", name)))
+		return 2
+	}
+	frames := source.Frames()
+	frame := frames.GetAnyFrameForReading()
+	if frame == nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+	hash := fnv.New64a()
+	step := max(len(frame.Data)/fingerprintSamples, 1)
+	sample := make([]byte, 0, fingerprintSamples)
+	for i := 0; i < len(frame.Data); i += step {
+		sample = append(sample, frame.Data[i])
+	}
+	frames.FinishedReading(frame)
+	_, _ = hash.Write(sample)
+	L.Push(lua.LString(fmt.Sprintf("%016x", hash.Sum64())))
+	return 1
+}
+
+// fazantix.on(event, fn) calls fn(data) for every such event, data has
+// the same fields as the event has in the API
+func (v *vm) luaOn(L *lua.LState) int {
+	event := L.CheckString(1)
+	fn := L.CheckFunction(2)
+	if !slices.Contains(events, event) {
+		L.ArgError(1, fmt.Sprintf("unknown event %s, expected one of %s", event, strings.Join(events, ", ")))
+	}
+	v.handlers[event] = append(v.handlers[event], fn)
+	return 0
+}
+
+// fazantix.after(ms, fn) calls fn once and returns a timer id
+func (v *vm) luaAfter(L *lua.LState) int {
+	ms := L.CheckInt(1)
+	fn := L.CheckFunction(2)
+	L.Push(lua.LNumber(v.addTimer(time.Duration(ms)*time.Millisecond, fn, false)))
+	return 1
+}
+
+// fazantix.every(ms, fn) calls fn repeatedly and returns a timer id
+func (v *vm) luaEvery(L *lua.LState) int {
+	ms := L.CheckInt(1)
+	fn := L.CheckFunction(2)
+	if ms <= 0 {
+		L.ArgError(1, "interval must be positive")
+	}
+	L.Push(lua.LNumber(v.addTimer(time.Duration(ms)*time.Millisecond, fn, true)))
+	return 1
+}
+
+// fazantix.cancel(id) stops a timer
+func (v *vm) luaCancel(L *lua.LState) int {
+	v.cancelTimer(L.CheckInt(1))
+	return 0
+}
+
+// fazantix.log(...) logs its arguments, separated by spaces
+func (v *vm) luaLog(L *lua.LState) int {
+	parts := make([]string, L.GetTop())
+	for i := range parts {
+		parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+	}
+	v.script.logger.Info(strings.Join(parts, " "))
+	return 0
+}
+
+// fazantix.now_ms() returns the unix time in milliseconds
+func (v *vm) luaNowMs(L *lua.LState) int {
+	L.Push(lua.LNumber(time.Now().UnixMilli()))
+	return 1
+}
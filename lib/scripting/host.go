@@ -0,0 +1,264 @@
+// Package scripting runs Lua scripts that react to what happens in the
+// theatre. Every script runs in its own interpreter on its own goroutine, so
+// a slow or broken script can never hold up the render loop. Errors are
+// logged and counted, and a script that fails to reload keeps running its
+// previous version.
+package scripting
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/metrics"
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+const (
+	defaultTimeout = time.Second
+	queueLen       = 256
+	watchInterval  = 2 * time.Second
+)
+
+// events are the theatre events scripts can subscribe to with fazantix.on
+var events = []string{
+	"set-scene", "cue", "set-transition", "tally", "source-health",
+	"crash", "lock", "macro", "automation", "schedule",
+}
+
+type Host struct {
+	theatre *theatre.Theatre
+	scripts map[string]*Script
+	done    chan struct{}
+}
+
+type Status struct {
+	Name     string
+	Path     string
+	Loaded   bool
+	LoadedAt time.Time
+	// Error is the last error the script raised, if any
+	Error  string
+	Errors uint64
+}
+
+type Script struct {
+	name    string
+	path    string
+	timeout time.Duration
+	host    *Host
+	logger  *slog.Logger
+
+	// reloading keeps the watcher and the API from reloading at once
+	reloading sync.Mutex
+
+	mutex sync.Mutex
+	vm    *vm
+	// loading is the interpreter that runs the script for the first time,
+	// until it has loaded
+	loading *vm
+	modTime time.Time
+	status  Status
+}
+
+func New(cfgs map[string]*config.ScriptCfg, t *theatre.Theatre) *Host {
+	h := &Host{
+		theatre: t,
+		scripts: make(map[string]*Script),
+		done:    make(chan struct{}),
+	}
+	for name, cfg := range cfgs {
+		s := &Script{
+			name:    name,
+			path:    string(cfg.Path),
+			timeout: time.Duration(cfg.TimeoutMs) * time.Millisecond,
+			host:    h,
+			logger:  slog.Default().With(slog.String("module", "script/"+name)),
+			status:  Status{Name: name, Path: string(cfg.Path)},
+		}
+		if s.timeout == 0 {
+			s.timeout = defaultTimeout
+		}
+		metrics.ScriptErrors.WithLabelValues(name).Add(0)
+		h.scripts[name] = s
+	}
+	return h
+}
+
+// Start loads every script, forwards the theatre events to them and
+// reloads them when their file changes
+func (h *Host) Start() {
+	if len(h.scripts) == 0 {
+		return
+	}
+	for _, event := range events {
+		h.theatre.AddEventListener(event, func(_ *theatre.Theatre, data interface{}) {
+			for _, s := range h.scripts {
+				s.dispatch(event, data)
+			}
+		})
+	}
+	for _, name := range slices.Sorted(maps.Keys(h.scripts)) {
+		_ = h.scripts[name].Reload()
+	}
+	go h.watch()
+}
+
+func (h *Host) Stop() {
+	if len(h.scripts) == 0 {
+		return
+	}
+	close(h.done)
+	for _, s := range h.scripts {
+		s.mutex.Lock()
+		if s.vm != nil {
+			s.vm.close()
+			s.vm = nil
+		}
+		s.mutex.Unlock()
+	}
+}
+
+func (h *Host) watch() {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+		}
+		for _, s := range h.scripts {
+			info, err := os.Stat(s.path)
+			if err != nil {
+				continue
+			}
+			s.mutex.Lock()
+			changed := !info.ModTime().Equal(s.modTime)
+			s.mutex.Unlock()
+			if changed {
+				s.logger.Info("file changed, reloading")
+				_ = s.Reload()
+			}
+		}
+	}
+}
+
+// Reload loads the script again by name
+func (h *Host) Reload(name string) error {
+	s, ok := h.scripts[name]
+	if !ok {
+		return fmt.Errorf("no such script: %s", name)
+	}
+	return s.Reload()
+}
+
+// Statuses returns the state of every script, sorted by name
+func (h *Host) Statuses() []Status {
+	statuses := make([]Status, 0, len(h.scripts))
+	for _, name := range slices.Sorted(maps.Keys(h.scripts)) {
+		s := h.scripts[name]
+		s.mutex.Lock()
+		statuses = append(statuses, s.status)
+		s.mutex.Unlock()
+	}
+	return statuses
+}
+
+// Reload runs the script file in a fresh interpreter. Only if that works
+// does it replace the running version, along with its event handlers and
+// timers.
+func (s *Script) Reload() error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err == nil {
+		s.mutex.Lock()
+		s.modTime = info.ModTime()
+		s.mutex.Unlock()
+	}
+
+	v := newVM(s)
+	s.mutex.Lock()
+	if s.vm == nil {
+		// events that happen while the script loads, maybe caused by
+		// the script itself, wait for it in the queue
+		s.loading = v
+	}
+	s.mutex.Unlock()
+	loaded := make(chan error, 1)
+	v.post(func() {
+		loaded <- v.protect(func(ctx context.Context) error {
+			return v.L.DoFile(s.path)
+		})
+	})
+	err = <-loaded
+	if err != nil {
+		s.mutex.Lock()
+		s.loading = nil
+		s.mutex.Unlock()
+		v.close()
+		s.reportError(fmt.Errorf("could not load %s: %w", s.path, err))
+		return err
+	}
+
+	s.mutex.Lock()
+	old := s.vm
+	s.vm = v
+	s.loading = nil
+	s.status.Loaded = true
+	s.status.LoadedAt = time.Now()
+	s.status.Error = ""
+	s.mutex.Unlock()
+	if old != nil {
+		old.close()
+	}
+	s.logger.Info(fmt.Sprintf("loaded %s", s.path))
+	return nil
+}
+
+func (s *Script) reportError(err error) {
+	s.logger.Error(err.Error())
+	metrics.ScriptErrors.WithLabelValues(s.name).Inc()
+	s.mutex.Lock()
+	s.status.Error = err.Error()
+	s.status.Errors++
+	s.mutex.Unlock()
+}
+
+// dispatch hands an event to the running version of the script. While a
+// new version loads, the old one keeps getting the events, so none are
+// handled twice or lost if the new version fails to load.
+func (s *Script) dispatch(event string, data interface{}) {
+	s.mutex.Lock()
+	v := s.vm
+	if v == nil {
+		v = s.loading
+	}
+	s.mutex.Unlock()
+	if v == nil {
+		return
+	}
+	v.post(func() {
+		handlers := v.handlers[event]
+		if len(handlers) == 0 {
+			return
+		}
+		arg := toLua(v.L, data)
+		if table, ok := arg.(*lua.LTable); ok {
+			table.RawSetString("Event", lua.LString(event))
+		}
+		for _, handler := range handlers {
+			v.call(handler, arg)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package scripting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+// the script bounces the projector back to the slides shortly after
+// anything puts the camera on it
+const testScript = `
+fazantix.on("set-scene", function(e)
+	if e.Scene == "full-cam" then
+		fazantix.after(10, function()
+			assert(fazantix.set_scene("projector", "full-slides", false))
+		end)
+	end
+end)
+fazantix.on("cue", function(e) error("cue " .. e.Scene) end)
+fazantix.on("set-transition", function(e) while true do end end)
+assert(fazantix.set_transition("projector", -1) == nil)
+assert(fazantix.stages().projector.active_scene == "full-slides")
+assert(fazantix.set_scene("projector", "full-cam", false))
+`
+
+func TestScript(t *testing.T) {
+	th := theatretest.New(t, nil)
+	path := filepath.Join(t.TempDir(), "bounce.lua")
+	err := os.WriteFile(path, []byte(testScript), 0o644)
+	if err != nil {
+		t.Fatalf("could not write script: %s", err)
+	}
+	h := New(map[string]*config.ScriptCfg{
+		"bounce": {Path: config.CfgPath(path), TimeoutMs: 50},
+	}, th)
+	scenes := make(chan string, 16)
+	th.AddEventListener("set-scene", func(_ *theatre.Theatre, data interface{}) {
+		scenes <- data.(theatre.EventDataSetScene).Scene
+	})
+	expectScenes := func(expected ...string) {
+		for _, scene := range expected {
+			select {
+			case got := <-scenes:
+				if got != scene {
+					t.Fatalf("expected a switch to %s, got %s", scene, got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no switch to %s", scene)
+			}
+		}
+	}
+	waitForErrors := func(errors uint64) Status {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			status := h.Statuses()[0]
+			if status.Errors >= errors {
+				return status
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d errors, got %+v", errors, status)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	h.Start()
+	defer h.Stop()
+	expectScenes("full-cam", "full-slides")
+
+	// errors and runaway handlers are logged and counted, nothing more
+	err = th.Cue("projector", "full-cam")
+	if err != nil {
+		t.Fatalf("could not cue: %s", err)
+	}
+	waitForErrors(1)
+	err = th.SetTransitionSpeed("projector", time.Second)
+	if err != nil {
+		t.Fatalf("could not set transition: %s", err)
+	}
+	waitForErrors(2)
+	err = th.SetScene("projector", "full-cam", false)
+	if err != nil {
+		t.Fatalf("could not set scene: %s", err)
+	}
+	expectScenes("full-cam", "full-slides")
+
+	// a broken version does not replace the running one
+	err = os.WriteFile(path, []byte("fazantix.on("), 0o644)
+	if err != nil {
+		t.Fatalf("could not write script: %s", err)
+	}
+	if h.Reload("bounce") == nil {
+		t.Fatalf("reloading a broken script should fail")
+	}
+	status := waitForErrors(3)
+	if !status.Loaded || status.Error == "" {
+		t.Errorf("expected the old version to stay loaded with an error, got %+v", status)
+	}
+	err = th.SetScene("projector", "full-cam", false)
+	if err != nil {
+		t.Fatalf("could not set scene: %s", err)
+	}
+	expectScenes("full-cam", "full-slides")
+}
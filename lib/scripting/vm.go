@@ -0,0 +1,192 @@
+package scripting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// vm is one loaded version of a script. The Lua state is not safe for
+// concurrent use, so everything that touches it, from event handlers to
+// timers, is posted to the queue and run on the vm's own goroutine.
+type vm struct {
+	L      *lua.LState
+	script *Script
+	queue  chan func()
+	done   chan struct{}
+
+	handlers map[string][]*lua.LFunction
+	timers   map[int]*time.Timer
+	nextID   int
+}
+
+func newVM(s *Script) *vm {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.CoroutineLibName, lua.OpenCoroutine},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// scripts should not be able to load code from anywhere else
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	v := &vm{
+		L:        L,
+		script:   s,
+		queue:    make(chan func(), queueLen),
+		done:     make(chan struct{}),
+		handlers: make(map[string][]*lua.LFunction),
+		timers:   make(map[int]*time.Timer),
+	}
+	v.register()
+	go v.run()
+	return v
+}
+
+func (v *vm) run() {
+	for {
+		select {
+		case <-v.done:
+			for _, timer := range v.timers {
+				timer.Stop()
+			}
+			v.L.Close()
+			return
+		case f := <-v.queue:
+			f()
+		}
+	}
+}
+
+// post queues f to run on the vm's goroutine. If the script cannot keep
+// up, f is dropped rather than blocking whoever posted it.
+func (v *vm) post(f func()) {
+	select {
+	case <-v.done:
+	case v.queue <- f:
+	default:
+		v.script.reportError(fmt.Errorf("script is too slow, dropped a call"))
+	}
+}
+
+func (v *vm) close() {
+	close(v.done)
+}
+
+// protect runs f with the script's time limit and turns panics into errors
+func (v *vm) protect(f func(ctx context.Context) error) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), v.script.timeout)
+	defer cancel()
+	v.L.SetContext(ctx)
+	defer v.L.RemoveContext()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return f(ctx)
+}
+
+// call runs a Lua function, logging any error it raises
+func (v *vm) call(fn *lua.LFunction, args ...lua.LValue) {
+	err := v.protect(func(ctx context.Context) error {
+		return v.L.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, args...)
+	})
+	if err != nil {
+		v.script.reportError(err)
+	}
+}
+
+func (v *vm) addTimer(d time.Duration, fn *lua.LFunction, repeat bool) int {
+	v.nextID++
+	id := v.nextID
+	var fire func()
+	fire = func() {
+		v.post(func() {
+			if _, ok := v.timers[id]; !ok {
+				return
+			}
+			if repeat {
+				v.timers[id] = time.AfterFunc(d, fire)
+			} else {
+				delete(v.timers, id)
+			}
+			v.call(fn)
+		})
+	}
+	v.timers[id] = time.AfterFunc(d, fire)
+	return id
+}
+
+func (v *vm) cancelTimer(id int) {
+	if timer, ok := v.timers[id]; ok {
+		timer.Stop()
+		delete(v.timers, id)
+	}
+}
+
+// toLua converts event data to Lua values by way of its JSON encoding, so
+// scripts see the same fields as API clients do
+func toLua(L *lua.LState, data interface{}) lua.LValue {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return lua.LNil
+	}
+	var decoded interface{}
+	err = json.Unmarshal(encoded, &decoded)
+	if err != nil {
+		return lua.LNil
+	}
+	return goToLua(L, decoded)
+}
+
+func goToLua(L *lua.LState, value interface{}) lua.LValue {
+	switch value := value.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(value)
+	case float64:
+		return lua.LNumber(value)
+	case int:
+		return lua.LNumber(value)
+	case int64:
+		return lua.LNumber(value)
+	case string:
+		return lua.LString(value)
+	case []interface{}:
+		table := L.NewTable()
+		for _, item := range value {
+			table.Append(goToLua(L, item))
+		}
+		return table
+	case []string:
+		table := L.NewTable()
+		for _, item := range value {
+			table.Append(lua.LString(item))
+		}
+		return table
+	case map[string]interface{}:
+		table := L.NewTable()
+		for key, item := range value {
+			table.RawSetString(key, goToLua(L, item))
+		}
+		return table
+	default:
+		return lua.LString(fmt.Sprint(value))
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,16 +24,31 @@ type FFmpegSink struct {
 	stdin    io.WriteCloser
 	frames   layer.FrameForwarder
 	rate     float64
+	pixFmt   string
 	cfg      *config.FFmpegSinkCfg
+
+	running  atomic.Bool
+	restarts atomic.Uint64
 }
 
+// defaultPixFmt is what ffmpeg sinks used to be limited to
+const defaultPixFmt = "rgba"
+
 func New(name string, cfg *config.FFmpegSinkCfg, frameCfg *encdec.FrameCfg, alloc encdec.FrameAllocator) *FFmpegSink {
-	f := &FFmpegSink{shellCmd: cfg.Cmd, cfg: cfg}
+	f := &FFmpegSink{shellCmd: cfg.Cmd, cfg: cfg, pixFmt: cfg.PixFmt}
+	if f.pixFmt == "" {
+		f.pixFmt = defaultPixFmt
+	}
+	// the config is validated, so the pixel format and colours are known
+	frameType, _ := encdec.ParsePixFmt(f.pixFmt)
+	colorspace, colorRange, _ := cfg.ColourCfg.Parse()
 	f.frames.Init(
 		name,
 		&encdec.FrameInfo{
-			FrameType: encdec.RGBAFrames,
-			FrameCfg:  *frameCfg,
+			FrameType:  frameType,
+			FrameCfg:   *frameCfg,
+			Colorspace: colorspace,
+			Range:      colorRange,
 		},
 		alloc,
 	)
@@ -61,6 +77,7 @@ func (f *FFmpegSink) setupCmd() error {
 	f.cmd.Env = append(f.cmd.Env, fmt.Sprintf("HEIGHT=%d", f.Frames().Height))
 	f.cmd.Env = append(f.cmd.Env, fmt.Sprintf("SIZE=%dx%d", f.Frames().Width, f.Frames().Height))
 	f.cmd.Env = append(f.cmd.Env, fmt.Sprintf("RATE=%f", f.rate))
+	f.cmd.Env = append(f.cmd.Env, fmt.Sprintf("PIX_FMT=%s", f.pixFmt))
 	f.cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
 	var err error
 	f.stdin, err = f.cmd.StdinPipe()
@@ -82,12 +99,15 @@ func (f *FFmpegSink) runFFmpeg() {
 	for {
 		f.Frames().Debug("starting ffmpeg")
 
+		f.running.Store(true)
 		err := f.cmd.Run()
+		f.running.Store(false)
 		if err != nil {
 			f.Frames().Error("ffmpeg error: %s", err)
 		}
 
 		f.Frames().Error("ffmpeg died")
+		f.restarts.Add(1)
 		err = f.setupCmd()
 		if err != nil {
 			f.Frames().Error("could not setup ffmpeg command: %s", err)
@@ -137,6 +157,16 @@ func (f *FFmpegSink) Frames() *layer.FrameForwarder {
 	return &f.frames
 }
 
+// Running tells whether the ffmpeg process is currently alive
+func (f *FFmpegSink) Running() bool {
+	return f.running.Load()
+}
+
+// Restarts is the number of times ffmpeg had to be restarted
+func (f *FFmpegSink) Restarts() uint64 {
+	return f.restarts.Load()
+}
+
 func (f *FFmpegSink) log(msg string, args ...interface{}) {
 	f.Frames().Log(msg, args...)
 }
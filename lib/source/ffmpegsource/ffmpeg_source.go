@@ -5,6 +5,7 @@ import (
 	"io"
 	"os/exec"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -21,16 +22,30 @@ type FFmpegSource struct {
 	stderr   io.ReadCloser
 	frames   layer.FrameForwarder
 	cfg      *config.FFmpegSourceCfg
+
+	restarts atomic.Uint64
 }
 
+// defaultPixFmt is what ffmpeg sources used to be limited to
+const defaultPixFmt = "yuv422p"
+
 func New(name string, cfg *config.FFmpegSourceCfg, alloc encdec.FrameAllocator) *FFmpegSource {
 	f := &FFmpegSource{shellCmd: cfg.Cmd}
+	pixFmt := cfg.PixFmt
+	if pixFmt == "" {
+		pixFmt = defaultPixFmt
+	}
+	// the config is validated, so the pixel format is known
+	frameType, _ := encdec.ParsePixFmt(pixFmt)
+	colorspace, colorRange, _ := cfg.ColourCfg.Parse()
 	f.frames.Init(
 		name,
 		&encdec.FrameInfo{
-			FrameType: encdec.YUV422Frames,
-			PixFmt:    []uint8{},
-			FrameCfg:  cfg.FrameCfg,
+			FrameType:  frameType,
+			PixFmt:     []uint8{},
+			FrameCfg:   cfg.FrameCfg,
+			Colorspace: colorspace,
+			Range:      colorRange,
 		},
 		alloc,
 	)
@@ -77,6 +92,7 @@ func (f *FFmpegSource) runFFmpeg() {
 		}
 
 		f.Frames().Error("ffmpeg died")
+		f.restarts.Add(1)
 		err = f.setupCmd()
 		if err != nil {
 			f.Frames().Error("could not setup ffmpeg command: %s", err)
@@ -99,16 +115,23 @@ func (f *FFmpegSource) processStderr() {
 }
 
 func (f *FFmpegSource) processStdout() {
+	blockFor := time.Duration(f.cfg.BlockMs) * time.Millisecond
+	frameSize := int64(encdec.FrameSize(&f.frames.FrameInfo))
 	for {
-		frame := f.frames.GetFrameForWriting()
+		frame := f.frames.GetFrameForWritingWithin(blockFor)
 		if frame == nil {
-			panic("framedropping for ffmpeg sources not yet implemented")
-			// TODO: here we should discard the exact amount of data from
-			// ffmpeg's stdout and continue the loop
+			// all frames are held by readers, so skip this one to stay
+			// in step with ffmpeg's output
+			_, err := io.CopyN(io.Discard, f.stdout, frameSize)
+			if err != nil {
+				f.Frames().Error("could not read from ffmpeg's output: %s", err)
+				return
+			}
+			continue
 		}
-		err := encdec.PrepareYUYV422p(frame)
+		err := encdec.Prepare(frame)
 		if err != nil {
-			f.Frames().Error("Could not prepare YUV422 buffer: %s", err)
+			f.Frames().Error("Could not prepare %s buffer: %s", frame.Type, err)
 			f.frames.FailedWriting(frame)
 			return
 		}
@@ -127,6 +150,11 @@ func (f *FFmpegSource) Frames() *layer.FrameForwarder {
 	return &f.frames
 }
 
+// Restarts is the number of times ffmpeg had to be restarted
+func (f *FFmpegSource) Restarts() uint64 {
+	return f.restarts.Load()
+}
+
 func (f *FFmpegSource) log(msg string, args ...interface{}) {
 	f.Frames().Log(msg, args...)
 }
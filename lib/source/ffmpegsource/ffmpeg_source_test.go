@@ -0,0 +1,81 @@
+package ffmpegsource
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/encdec"
+)
+
+// a 4x2 yuv422p frame is 16 bytes, which is exactly one line of yes
+const floodLine = "0123456789abcde\n"
+
+func startFlood(t *testing.T, blockMs int) *FFmpegSource {
+	cfg := &config.FFmpegSourceCfg{
+		FrameCfg: encdec.FrameCfg{Width: 4, Height: 2, NumAllocatedFrames: 2},
+		Cmd:      "exec yes " + floodLine[:len(floodLine)-1],
+		BlockMs:  blockMs,
+	}
+	f := New("flood", cfg, &encdec.DumbFrameAllocator{})
+	if !f.Start() {
+		t.Fatalf("could not start fake ffmpeg")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.frames.Lock()
+		ready := f.frames.IsReady
+		f.frames.Unlock()
+		if ready {
+			return f
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no frame from fake ffmpeg")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func droppedIn(f *FFmpegSource) uint64 {
+	f.frames.Lock()
+	defer f.frames.Unlock()
+	return f.frames.DroppedFramesIn
+}
+
+// holdFrame keeps a frame from being written to for a while, so the
+// source runs out of free frames
+func holdFrame(t *testing.T, f *FFmpegSource, d time.Duration) {
+	frame := f.frames.GetAnyFrameForReading()
+	if frame == nil {
+		t.Fatalf("no frame to read")
+	}
+	time.Sleep(d)
+	f.frames.FinishedReading(frame)
+}
+
+func TestFloodDropsFrames(t *testing.T) {
+	f := startFlood(t, 0)
+	holdFrame(t, f, 100*time.Millisecond)
+	if droppedIn(f) == 0 {
+		t.Fatalf("expected frames to be dropped while one was held")
+	}
+
+	// the dropped frames are skipped whole, so later frames line up
+	time.Sleep(10 * time.Millisecond)
+	frame := f.frames.GetAnyFrameForReading()
+	if frame == nil {
+		t.Fatalf("no frame to read")
+	}
+	defer f.frames.FinishedReading(frame)
+	if string(frame.Data) != floodLine {
+		t.Errorf("frame is out of step with the output: %q", frame.Data)
+	}
+}
+
+func TestFloodBlocks(t *testing.T) {
+	f := startFlood(t, 5000)
+	holdFrame(t, f, 100*time.Millisecond)
+	if dropped := droppedIn(f); dropped != 0 {
+		t.Errorf("expected the source to wait for the held frame, got %d drops", dropped)
+	}
+}
@@ -29,6 +29,8 @@ type V4LSource struct {
 	requestedFrameCfg  *encdec.FrameCfg
 	numFramesInWriting int
 	fps                uint32
+	colorspace         encdec.Colorspace
+	colorRange         encdec.ColorRange
 	framesInWriting    []*encdec.Frame
 
 	hadValidFrame      bool
@@ -49,6 +51,8 @@ func New(name string, cfg *config.V4LSourceCfg) *V4LSource {
 		s.frames.FrameType = encdec.YUV422pFrames
 	case "mjpeg":
 		s.frames.FrameType = encdec.RGBAFrames
+	case "p010":
+		s.frames.FrameType = encdec.P010Frames
 	default:
 		panic("Unsupported format: " + cfg.Fmt)
 	}
@@ -57,6 +61,8 @@ func New(name string, cfg *config.V4LSourceCfg) *V4LSource {
 
 	s.requestedFrameCfg = &cfg.FrameCfg
 	s.numFramesInWriting = cfg.NumFramesInWriting
+	// the config is validated, so the colour settings are known
+	s.colorspace, s.colorRange, _ = cfg.ColourCfg.Parse()
 
 	return s
 }
@@ -72,6 +78,8 @@ func (s *V4LSource) Start() bool {
 		pixfmt = v4l2.PixelFmtMJPEG
 	case "yuyv":
 		pixfmt = v4l2.PixelFmtYUYV
+	case "p010":
+		pixfmt = v4l2.PixelFmtP010
 	}
 
 	s.log("Loading v4l2 device %s with %d frames in transit", s.path, s.requestedFrameCfg.NumAllocatedFrames)
@@ -152,9 +160,23 @@ func (s *V4LSource) Start() bool {
 		s.Frames().Init(
 			s.Frames().Name,
 			&encdec.FrameInfo{
-				FrameType: encdec.YUV422pFrames,
-				PixFmt:    []uint8{},
-				FrameCfg:  frameCfg,
+				FrameType:  encdec.YUV422pFrames,
+				PixFmt:     []uint8{},
+				FrameCfg:   frameCfg,
+				Colorspace: s.colorspace,
+				Range:      s.colorRange,
+			},
+			alloc,
+		)
+	case "p010":
+		s.Frames().Init(
+			s.Frames().Name,
+			&encdec.FrameInfo{
+				FrameType:  encdec.P010Frames,
+				PixFmt:     []uint8{},
+				FrameCfg:   frameCfg,
+				Colorspace: s.colorspace,
+				Range:      s.colorRange,
 			},
 			alloc,
 		)
@@ -181,6 +203,8 @@ func (s *V4LSource) finaliseFrame(frame *encdec.Frame) error {
 		return encdec.DecodeRGBfromImage(frame.Data, frame)
 	case "yuyv":
 		return encdec.PrepareYUYV(frame)
+	case "p010":
+		return encdec.PrepareP010(frame)
 	}
 	return fmt.Errorf("unknown format: %s", s.Format)
 }
@@ -0,0 +1,236 @@
+// Package statefile keeps the runtime state of the theatre in a file: the
+// active scene and transition time of every stage and the images uploaded
+// through the API. A restarted fazantix picks up where it left off instead
+// of going back to the default scenes.
+package statefile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/source/imgsource"
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+const version = 1
+
+type StageState struct {
+	Scene        string `json:"scene"`
+	TransitionMs int64  `json:"transition_ms"`
+}
+
+// State is the content of the state file
+type State struct {
+	Version int                   `json:"version"`
+	Stages  map[string]StageState `json:"stages"`
+	// Images lists the image sources that got an image uploaded, the
+	// images themselves are in the image directory
+	Images []string `json:"images"`
+}
+
+type File struct {
+	path     string
+	imageDir string
+	theatre  *theatre.Theatre
+	logger   *slog.Logger
+
+	// images holds the sources with an uploaded image
+	images map[string]bool
+	mutex  sync.Mutex
+}
+
+func New(cfg *config.StateCfg, t *theatre.Theatre) *File {
+	return &File{
+		path:     string(cfg.Path),
+		imageDir: string(cfg.Path) + ".images",
+		theatre:  t,
+		logger:   slog.Default().With(slog.String("module", "state")),
+		images:   make(map[string]bool),
+	}
+}
+
+func (f *File) imagePath(source string) string {
+	return filepath.Join(f.imageDir, url.PathEscape(source)+".png")
+}
+
+// Restore applies the saved state to the theatre. It has to be called
+// before the theatre is started, which puts the stages that were not
+// restored on their default scene. Entries that no longer match the config,
+// such as a scene or source that was renamed, are skipped with a warning.
+// A missing state file is not an error.
+func (f *File) Restore() error {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", f.path, err)
+	}
+	var state State
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("could not parse %s: %w", f.path, err)
+	}
+	if state.Version != version {
+		return fmt.Errorf("%s has version %d, expected %d", f.path, state.Version, version)
+	}
+
+	for name, stageState := range state.Stages {
+		if _, ok := f.theatre.Stages[name]; !ok {
+			f.logger.Warn(fmt.Sprintf("not restoring stage %s, it no longer exists", name))
+			continue
+		}
+		if stageState.TransitionMs >= 0 {
+			err = f.theatre.SetTransitionSpeed(name, time.Duration(stageState.TransitionMs)*time.Millisecond)
+			if err != nil {
+				f.logger.Warn(fmt.Sprintf("could not restore the transition time of stage %s: %s", name, err))
+			}
+		}
+		err = f.theatre.SetSceneAs(theatre.Caller{Force: true}, name, stageState.Scene, false)
+		if err != nil {
+			f.logger.Warn(fmt.Sprintf("not restoring stage %s, using its default scene: %s", name, err))
+			continue
+		}
+		f.logger.Info(fmt.Sprintf("restored scene %s on stage %s", stageState.Scene, name))
+	}
+
+	for _, source := range state.Images {
+		err = f.restoreImage(source)
+		if err != nil {
+			f.logger.Warn(fmt.Sprintf("not restoring the image of source %s: %s", source, err))
+			continue
+		}
+		f.images[source] = true
+	}
+	return nil
+}
+
+func (f *File) restoreImage(source string) error {
+	if _, ok := f.theatre.SourceByName(source).(*imgsource.ImgSource); !ok {
+		return fmt.Errorf("it is no longer an image source")
+	}
+	imgFile, err := os.Open(f.imagePath(source))
+	if err != nil {
+		return err
+	}
+	defer imgFile.Close()
+	img, err := png.Decode(imgFile)
+	if err != nil {
+		return err
+	}
+	return f.theatre.SetImage(source, img)
+}
+
+// Start saves the state every time it changes
+func (f *File) Start() {
+	f.theatre.AddEventListener("set-scene", func(t *theatre.Theatre, data interface{}) {
+		f.save()
+	})
+	f.theatre.AddEventListener("set-transition", func(t *theatre.Theatre, data interface{}) {
+		f.save()
+	})
+	f.theatre.AddEventListener("set-image", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSetImage)
+		f.saveImage(event.Source, event.Image)
+	})
+}
+
+// save writes the current state of the theatre. The listeners run
+// concurrently, so it reads the stages instead of trusting the event.
+func (f *File) save() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	state := State{
+		Version: version,
+		Stages:  make(map[string]StageState),
+		Images:  slices.Sorted(maps.Keys(f.images)),
+	}
+	f.theatre.ReadStages(func() {
+		for name, stage := range f.theatre.Stages {
+			state.Stages[name] = StageState{
+				Scene:        stage.ActiveScene,
+				TransitionMs: stage.TransitionTime.Milliseconds(),
+			}
+		}
+	})
+	data, err := json.MarshalIndent(&state, "", "  ")
+	if err != nil {
+		f.logger.Error(fmt.Sprintf("could not encode state: %s", err))
+		return
+	}
+	err = writeAtomically(f.path, func(w *os.File) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+	if err != nil {
+		f.logger.Error(fmt.Sprintf("could not save state: %s", err))
+	}
+}
+
+func (f *File) saveImage(source string, img image.Image) {
+	err := os.MkdirAll(f.imageDir, 0750)
+	if err == nil {
+		err = writeAtomically(f.imagePath(source), func(w *os.File) error {
+			return png.Encode(w, img)
+		})
+	}
+	if err != nil {
+		f.logger.Error(fmt.Sprintf("could not save the image of source %s: %s", source, err))
+		return
+	}
+
+	f.mutex.Lock()
+	f.images[source] = true
+	f.mutex.Unlock()
+	f.save()
+}
+
+// writeAtomically writes to a temporary file and renames it over path, so a
+// crash halfway never leaves a truncated file behind
+func writeAtomically(path string, write func(w *os.File) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp)
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// StartInBackground restores the state and keeps saving it. If the state
+// cannot be restored at all, the stages start on their default scenes.
+func StartInBackground(t *theatre.Theatre, cfg *config.StateCfg) *File {
+	if cfg == nil {
+		return nil
+	}
+	f := New(cfg, t)
+	err := f.Restore()
+	if err != nil {
+		f.logger.Warn(fmt.Sprintf("could not restore state, using the default scenes: %s", err))
+	}
+	f.Start()
+	return f
+}
@@ -0,0 +1,180 @@
+package statefile
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/source/imgsource"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+func readState(t *testing.T, path string) *State {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	state := &State{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		t.Fatalf("could not parse state file: %s", err)
+	}
+	return state
+}
+
+func TestSaveAndRestore(t *testing.T) {
+	cfg := &config.StateCfg{Path: config.CfgPath(filepath.Join(t.TempDir(), "state.json"))}
+
+	th := theatretest.Build(t, theatretest.Config())
+	f := New(cfg, th)
+	err := f.Restore()
+	if err != nil {
+		t.Fatalf("restoring without a state file: %s", err)
+	}
+	f.Start()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 9))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{R: 0xff, A: 0xff})
+	if err := th.SetScene("projector", "full-cam", false); err != nil {
+		t.Fatalf("could not set scene: %s", err)
+	}
+	if err := th.SetTransitionSpeed("projector", 250*time.Millisecond); err != nil {
+		t.Fatalf("could not set transition: %s", err)
+	}
+	if err := th.SetImage("slides", img); err != nil {
+		t.Fatalf("could not set image: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state := readState(t, string(cfg.Path))
+		if state != nil && len(state.Images) == 1 &&
+			state.Stages["projector"] == (StageState{Scene: "full-cam", TransitionMs: 250}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("state was not saved, got %+v", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	restarted := theatretest.Build(t, theatretest.Config())
+	err = New(cfg, restarted).Restore()
+	if err != nil {
+		t.Fatalf("could not restore: %s", err)
+	}
+	stage := restarted.Stages["projector"]
+	if stage.ActiveScene != "full-cam" || stage.TransitionTime != 250*time.Millisecond {
+		t.Errorf("expected full-cam with a 250ms transition, got %s with %s", stage.ActiveScene, stage.TransitionTime)
+	}
+	restored := restarted.SourceByName("slides").(*imgsource.ImgSource).GetImage()
+	if r, g, _, _ := restored.At(0, 0).RGBA(); r != 0xffff || g != 0 {
+		t.Errorf("uploaded image was not restored")
+	}
+}
+
+func TestRestoreLastScene(t *testing.T) {
+	cfg := &config.StateCfg{Path: config.CfgPath(filepath.Join(t.TempDir(), "state.json"))}
+	th := theatretest.Build(t, theatretest.Config())
+	f := New(cfg, th)
+	f.Start()
+
+	for _, scene := range []string{"full-cam", "full-slides"} {
+		if err := th.SetScene("projector", scene, true); err != nil {
+			t.Fatalf("could not set scene: %s", err)
+		}
+	}
+	// the saves of both switches may run in any order, but have to end
+	// up with the last scene
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state := readState(t, string(cfg.Path))
+		if state != nil && state.Stages["projector"].Scene == "full-slides" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("last scene was not saved, got %+v", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// a late save of the first switch must not undo the second
+	time.Sleep(100 * time.Millisecond)
+
+	restarted := theatretest.Build(t, theatretest.Config())
+	if err := New(cfg, restarted).Restore(); err != nil {
+		t.Fatalf("could not restore: %s", err)
+	}
+	if scene := restarted.Stages["projector"].ActiveScene; scene != "full-slides" {
+		t.Errorf("expected the last scene to be restored, got %s", scene)
+	}
+}
+
+func TestIncompatibleState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	cfg := &config.StateCfg{Path: config.CfgPath(path)}
+
+	write := func(state *State) {
+		data, _ := json.Marshal(state)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("could not write state: %s", err)
+		}
+	}
+
+	write(&State{
+		Version: version,
+		Stages: map[string]StageState{
+			"projector": {Scene: "renamed-scene", TransitionMs: 300},
+			"old-stage": {Scene: "full-cam"},
+		},
+		Images: []string{"camera", "old-source"},
+	})
+	th := theatretest.Build(t, theatretest.Config())
+	err := New(cfg, th).Restore()
+	if err != nil {
+		t.Fatalf("partial restore should not fail: %s", err)
+	}
+	stage := th.Stages["projector"]
+	if stage.ActiveScene != "" {
+		t.Errorf("expected the projector to be left for its default scene, got %s", stage.ActiveScene)
+	}
+	if stage.TransitionTime != 300*time.Millisecond {
+		t.Errorf("expected the transition time to be restored, got %s", stage.TransitionTime)
+	}
+
+	write(&State{Version: version + 1, Stages: map[string]StageState{"projector": {Scene: "full-cam"}}})
+	th = theatretest.Build(t, theatretest.Config())
+	err = New(cfg, th).Restore()
+	if err == nil {
+		t.Errorf("expected an error for an unknown version")
+	}
+	if th.Stages["projector"].ActiveScene != "" {
+		t.Errorf("nothing should be restored from an unknown version")
+	}
+}
+
+func TestRestoreZeroTransition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	data, _ := json.Marshal(&State{
+		Version: version,
+		Stages:  map[string]StageState{"projector": {Scene: "full-cam", TransitionMs: 0}},
+	})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("could not write state: %s", err)
+	}
+	th := theatretest.Build(t, theatretest.Config())
+	err := New(&config.StateCfg{Path: config.CfgPath(path)}, th).Restore()
+	if err != nil {
+		t.Fatalf("could not restore: %s", err)
+	}
+	if transition := th.Stages["projector"].TransitionTime; transition != 0 {
+		t.Errorf("expected the cut to be restored, got a transition of %s", transition)
+	}
+}
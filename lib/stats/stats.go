@@ -12,6 +12,9 @@ type Stats struct {
 	Uptime             float64 `json:"uptime" example:"22.355897797"`
 	FPS                uint64  `json:"fps" example:"60"`
 	WsClients          int     `json:"ws_clients" example:"1"`
+	// UploadMs is how long each source took to upload a frame to the GPU,
+	// on average over the last second
+	UploadMs map[string]float64 `json:"upload_ms"`
 
 	frameCounter uint64
 	frameTimer   time.Time
@@ -31,6 +34,12 @@ func (s *Stats) Update() {
 		s.FPS = s.frameCounter
 		s.frameCounter = 0
 		s.frameTimer = time.Now()
+
+		uploadMs := make(map[string]float64)
+		for name, took := range rendering.UploadTimes() {
+			uploadMs[name] = float64(took.Microseconds()) / 1000
+		}
+		s.UploadMs = uploadMs
 	}
 
 	s.Uptime = float64(time.Since(s.start).Nanoseconds()) / 1e9
@@ -0,0 +1,157 @@
+package theatre
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/audit"
+	"github.com/fosdem/fazantix/lib/config"
+)
+
+// AutomationRule switches stages to another scene while a source is lost.
+// A source is lost when it is not ready or, if MaxFrameAge is set, when its
+// last frame is older than that. Both switching away and switching back
+// only happen once the source has been in its new state for LostAfter and
+// RecoverAfter respectively, so a flaky signal does not flip the stages
+// back and forth.
+type AutomationRule struct {
+	Name         string
+	Source       string
+	Stages       []string
+	Scene        string
+	RecoverScene string
+	MaxFrameAge  time.Duration
+	LostAfter    time.Duration
+	RecoverAfter time.Duration
+
+	sourceIdx uint32
+	lost      bool
+	// pendingSince is when the source changed state, zero if it is in the
+	// state the rule last acted on
+	pendingSince time.Time
+	// previous holds the scene every stage showed before the rule fired
+	previous map[string]string
+}
+
+func buildAutomation(cfg *config.Config, sourceIdxByName map[string]uint32) ([]*AutomationRule, error) {
+	var rules []*AutomationRule
+	for _, name := range slices.Sorted(maps.Keys(cfg.Automation)) {
+		ruleCfg := cfg.Automation[name]
+		idx, ok := sourceIdxByName[ruleCfg.Source]
+		if !ok {
+			return nil, fmt.Errorf("automation rule %s watches source %s, which is not used in any scene", name, ruleCfg.Source)
+		}
+		rules = append(rules, &AutomationRule{
+			Name:         name,
+			Source:       ruleCfg.Source,
+			Stages:       ruleCfg.Sinks,
+			Scene:        ruleCfg.Scene,
+			RecoverScene: ruleCfg.RecoverScene,
+			MaxFrameAge:  time.Duration(ruleCfg.MaxFrameAgeMs) * time.Millisecond,
+			LostAfter:    time.Duration(ruleCfg.LostMs) * time.Millisecond,
+			RecoverAfter: time.Duration(ruleCfg.RecoverMs) * time.Millisecond,
+			sourceIdx:    idx,
+			previous:     make(map[string]string),
+		})
+	}
+	return rules, nil
+}
+
+// CheckAutomation evaluates the automation rules, it is meant to be called
+// once per frame
+func (t *Theatre) CheckAutomation() {
+	t.checkAutomation(time.Now())
+}
+
+func (t *Theatre) checkAutomation(now time.Time) {
+	for _, rule := range t.AutomationRules {
+		frames := t.SourceList[rule.sourceIdx].Frames()
+		lost := !frames.IsReady || (rule.MaxFrameAge > 0 && frames.FrameAge > rule.MaxFrameAge)
+		if lost == rule.lost {
+			rule.pendingSince = time.Time{}
+			continue
+		}
+		if rule.pendingSince.IsZero() {
+			rule.pendingSince = now
+		}
+		hold := rule.LostAfter
+		if rule.lost {
+			hold = rule.RecoverAfter
+		}
+		if now.Sub(rule.pendingSince) < hold {
+			continue
+		}
+		rule.lost = lost
+		rule.pendingSince = time.Time{}
+		if lost {
+			t.automationLost(rule)
+		} else {
+			t.automationRecovered(rule)
+		}
+	}
+}
+
+func (t *Theatre) automationLost(rule *AutomationRule) {
+	for _, stageName := range rule.Stages {
+		t.ReadStages(func() {
+			rule.previous[stageName] = t.Stages[stageName].ActiveScene
+		})
+		t.automationSwitch(rule, "lost", stageName, rule.Scene)
+	}
+}
+
+func (t *Theatre) automationRecovered(rule *AutomationRule) {
+	for _, stageName := range rule.Stages {
+		// leave stages alone that an operator switched in the meantime
+		var active string
+		t.ReadStages(func() {
+			active = t.Stages[stageName].ActiveScene
+		})
+		if active != rule.Scene {
+			continue
+		}
+		scene := rule.RecoverScene
+		if scene == "" {
+			scene = rule.previous[stageName]
+		}
+		if scene == "" || scene == rule.Scene {
+			continue
+		}
+		t.automationSwitch(rule, "recovered", stageName, scene)
+	}
+}
+
+func (t *Theatre) automationSwitch(rule *AutomationRule, action string, stageName string, scene string) {
+	logger := slog.Default().With(slog.String("module", "automation"))
+	err := t.SetScene(stageName, scene, true)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("%s: source %s %s, could not switch stage %s to %s: %s", rule.Name, rule.Source, action, stageName, scene, err))
+	} else {
+		logger.Info(fmt.Sprintf("%s: source %s %s, switched stage %s to %s", rule.Name, rule.Source, action, stageName, scene))
+	}
+	t.Audit.Record(audit.Entry{
+		Origin: "automation",
+		Action: "set-scene",
+		Params: map[string]interface{}{
+			"rule":   rule.Name,
+			"source": rule.Source,
+			"stage":  stageName,
+			"scene":  scene,
+		},
+	}, err)
+
+	event := EventDataAutomation{
+		Rule:   rule.Name,
+		Source: rule.Source,
+		Action: action,
+		Stage:  stageName,
+		Scene:  scene,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	t.invoke("automation", event)
+}
@@ -0,0 +1,102 @@
+package theatre_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+func TestAutomation(t *testing.T) {
+	th := theatretest.New(t, func(cfg *config.Config) {
+		cfg.Automation = map[string]*config.AutomationRuleCfg{
+			"slides-lost": {
+				Source:        "slides",
+				Sinks:         []string{"projector"},
+				Scene:         "full-cam",
+				MaxFrameAgeMs: 500,
+				LostMs:        3000,
+				RecoverMs:     2000,
+			},
+		}
+	})
+	events := make(chan theatre.EventDataAutomation, 16)
+	th.AddEventListener("automation", func(_ *theatre.Theatre, data interface{}) {
+		events <- data.(theatre.EventDataAutomation)
+	})
+	expectEvent := func(action string, scene string) {
+		select {
+		case event := <-events:
+			if event.Action != action || event.Scene != scene || event.Rule != "slides-lost" {
+				t.Errorf("expected %s event switching to %s, got %+v", action, scene, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", action)
+		}
+	}
+
+	stage := th.Stages["projector"]
+	slides := th.SourceByName("slides").Frames()
+	start := time.Now()
+	at := func(d time.Duration) {
+		th.CheckAutomationAt(start.Add(d))
+	}
+
+	slides.IsReady = true
+	at(0)
+	slides.IsReady = false
+	at(time.Second)
+	// a short glitch does not switch
+	slides.IsReady = true
+	at(2 * time.Second)
+	slides.IsReady = false
+	at(3 * time.Second)
+	at(5 * time.Second)
+	if stage.ActiveScene != "full-slides" {
+		t.Fatalf("switched before the source was lost for long enough")
+	}
+	at(6 * time.Second)
+	if stage.ActiveScene != "full-cam" {
+		t.Fatalf("did not switch away from the lost source, got %s", stage.ActiveScene)
+	}
+	expectEvent("lost", "full-cam")
+
+	// ready, but with stale frames still counts as lost
+	slides.IsReady = true
+	slides.FrameAge = time.Second
+	at(7 * time.Second)
+	at(10 * time.Second)
+	if stage.ActiveScene != "full-cam" {
+		t.Fatalf("switched back while frames were stale")
+	}
+	slides.FrameAge = 0
+	at(11 * time.Second)
+	at(12 * time.Second)
+	if stage.ActiveScene != "full-cam" {
+		t.Fatalf("switched back before the source was back for long enough")
+	}
+	at(13 * time.Second)
+	if stage.ActiveScene != "full-slides" {
+		t.Fatalf("did not switch back to the previous scene, got %s", stage.ActiveScene)
+	}
+	expectEvent("recovered", "full-slides")
+
+	// stages an operator switched in the meantime are left alone
+	slides.IsReady = false
+	at(20 * time.Second)
+	at(23 * time.Second)
+	expectEvent("lost", "full-cam")
+	if err := th.SetScene("projector", "full-slides", false); err != nil {
+		t.Fatalf("could not set scene: %s", err)
+	}
+	slides.IsReady = true
+	at(24 * time.Second)
+	at(26 * time.Second)
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,11 +1,90 @@
 package theatre
 
+import "image"
+
 type EventListener func(theatre *Theatre, data interface{})
 
 type EventDataSetScene struct {
 	Event string
 	Stage string
 	Scene string
+	// Seq counts the scene changes, so listeners, which run concurrently,
+	// can tell an old change that reaches them late
+	Seq uint64
+}
+
+type EventDataCue struct {
+	Event string
+	Stage string
+	Scene string
+}
+
+type EventDataSetTransition struct {
+	Event        string
+	Stage        string
+	TransitionMs int64
+}
+
+// EventDataTally maps every source to the stages it is visible on
+type EventDataTally struct {
+	Event   string
+	Sources map[string][]string
+}
+
+type EventDataSourceHealth struct {
+	Event  string
+	Source string
+	Ready  bool
+}
+
+// EventDataLock is sent when a stage gets locked or unlocked, Holder is
+// empty in the latter case
+type EventDataLock struct {
+	Event  string
+	Stage  string
+	Holder string
+}
+
+// EventDataCrash is sent when the external process behind a source or
+// sink died and had to be restarted
+type EventDataCrash struct {
+	Event    string
+	Kind     string
+	Name     string
+	Restarts uint64
+}
+
+// EventDataSetImage is sent when an image source gets a new image at
+// runtime, as opposed to one from its config
+type EventDataSetImage struct {
+	Event  string
+	Source string
+	Image  image.Image
+}
+
+type EventDataSchedule struct {
+	Event string
+	ScheduleStatus
+}
+
+// EventDataMacro is sent when a macro starts, moves to the next step, is
+// paused or resumed, and when it ends
+type EventDataMacro struct {
+	Event string
+	MacroStatus
+	Error string
+}
+
+// EventDataAutomation is sent for every scene switch made by an automation
+// rule, Action is lost or recovered
+type EventDataAutomation struct {
+	Event  string
+	Rule   string
+	Source string
+	Action string
+	Stage  string
+	Scene  string
+	Error  string
 }
 
 func (t *Theatre) AddEventListener(event string, callback EventListener) {
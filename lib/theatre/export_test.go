@@ -0,0 +1,8 @@
+package theatre
+
+import "time"
+
+// CheckAutomationAt lets the tests run the automation rules at a given time
+func (t *Theatre) CheckAutomationAt(now time.Time) {
+	t.checkAutomation(now)
+}
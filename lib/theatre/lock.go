@@ -0,0 +1,81 @@
+package theatre
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/layer"
+)
+
+// Caller identifies who asks for a scene change on a possibly locked
+// stage. The zero value holds no lock and does not force.
+type Caller struct {
+	Holder string
+	// Force overrides the lock of someone else
+	Force bool
+}
+
+// StageLockedError is returned when a stage is locked by someone else
+type StageLockedError struct {
+	Stage  string
+	Holder string
+}
+
+func (e *StageLockedError) Error() string {
+	return fmt.Sprintf("stage %s is locked by %s", e.Stage, e.Holder)
+}
+
+func (c Caller) mayChange(stageName string, stage *layer.Stage) error {
+	if stage.LockedBy == "" || c.Force || (c.Holder != "" && c.Holder == stage.LockedBy) {
+		return nil
+	}
+	return &StageLockedError{Stage: stageName, Holder: stage.LockedBy}
+}
+
+// Lock makes caller the only one allowed to change the scene on the
+// stage. Taking over someone else's lock needs Force.
+func (t *Theatre) Lock(caller Caller, stageName string) error {
+	stage, ok := t.Stages[stageName]
+	if !ok {
+		return fmt.Errorf("no such stage: %s", stageName)
+	}
+	if caller.Holder == "" {
+		return fmt.Errorf("a lock needs a holder")
+	}
+	t.stageMutex.Lock()
+	err := caller.mayChange(stageName, stage)
+	if err != nil {
+		t.stageMutex.Unlock()
+		return err
+	}
+	stage.LockedBy = caller.Holder
+	stage.LockedAt = time.Now()
+	t.stageMutex.Unlock()
+	t.invoke("lock", EventDataLock{
+		Stage:  stageName,
+		Holder: caller.Holder,
+	})
+	return nil
+}
+
+// Unlock releases the lock on the stage, which only its holder can do
+// without Force
+func (t *Theatre) Unlock(caller Caller, stageName string) error {
+	stage, ok := t.Stages[stageName]
+	if !ok {
+		return fmt.Errorf("no such stage: %s", stageName)
+	}
+	t.stageMutex.Lock()
+	err := caller.mayChange(stageName, stage)
+	if err != nil || stage.LockedBy == "" {
+		t.stageMutex.Unlock()
+		return err
+	}
+	stage.LockedBy = ""
+	stage.LockedAt = time.Time{}
+	t.stageMutex.Unlock()
+	t.invoke("lock", EventDataLock{
+		Stage: stageName,
+	})
+	return nil
+}
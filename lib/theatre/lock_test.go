@@ -0,0 +1,59 @@
+package theatre_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/fosdem/fazantix/lib/theatre"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+func TestConcurrentLock(t *testing.T) {
+	for range 50 {
+		th := theatretest.New(t, nil)
+		errs := make([]error, 2)
+		var wg sync.WaitGroup
+		for i, holder := range []string{"alice", "bob"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = th.Lock(theatre.Caller{Holder: holder}, "projector")
+			}()
+		}
+		wg.Wait()
+
+		var locked *theatre.StageLockedError
+		if (errs[0] == nil) == (errs[1] == nil) {
+			t.Fatalf("expected exactly one lock to succeed, got %v and %v", errs[0], errs[1])
+		}
+		failed := errs[0]
+		if failed == nil {
+			failed = errs[1]
+		}
+		if !errors.As(failed, &locked) {
+			t.Fatalf("expected a StageLockedError, got %v", failed)
+		}
+	}
+}
+
+func TestLockRefusesSceneChange(t *testing.T) {
+	th := theatretest.New(t, nil)
+	if err := th.Lock(theatre.Caller{Holder: "alice"}, "projector"); err != nil {
+		t.Fatalf("could not lock: %s", err)
+	}
+	var locked *theatre.StageLockedError
+	err := th.SetSceneAs(theatre.Caller{Holder: "bob"}, "projector", "full-cam", false)
+	if !errors.As(err, &locked) || locked.Holder != "alice" {
+		t.Errorf("expected the stage to be locked by alice, got %v", err)
+	}
+	if err := th.Unlock(theatre.Caller{Holder: "bob"}, "projector"); !errors.As(err, &locked) {
+		t.Errorf("someone else could release the lock: %v", err)
+	}
+	if err := th.SetSceneAs(theatre.Caller{Holder: "alice"}, "projector", "full-cam", false); err != nil {
+		t.Errorf("the holder could not change the scene: %s", err)
+	}
+	if err := th.Unlock(theatre.Caller{Holder: "alice"}, "projector"); err != nil {
+		t.Errorf("the holder could not unlock: %s", err)
+	}
+}
@@ -0,0 +1,276 @@
+package theatre
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+)
+
+// The states a macro can be in. Finished, stopped and failed are only
+// sent in events; afterwards the macro is idle again.
+const (
+	MacroIdle     = "idle"
+	MacroRunning  = "running"
+	MacroPaused   = "paused"
+	MacroFinished = "finished"
+	MacroStopped  = "stopped"
+	MacroFailed   = "failed"
+)
+
+// Macro is a named sequence of scene changes, transition changes and waits
+type Macro struct {
+	Name  string
+	Label string
+	Steps []*MacroStep
+}
+
+type MacroStep struct {
+	Stages     []string
+	Scene      string
+	Transition time.Duration
+	Wait       time.Duration
+}
+
+type MacroStatus struct {
+	Name  string
+	Label string
+	State string
+	// Step is the index of the step being run or waited on
+	Step  int
+	Steps int
+}
+
+// macroRun is a running macro. Its goroutine owns it, except for the
+// status fields, which are guarded by the theatre's macro mutex.
+type macroRun struct {
+	caller Caller
+	ctl    chan string
+	paused bool
+	step   int
+}
+
+func buildMacros(cfg *config.Config) map[string]*Macro {
+	macros := make(map[string]*Macro)
+	for name, macroCfg := range cfg.Macros {
+		macro := &Macro{Name: name, Label: macroCfg.Label}
+		if macro.Label == "" {
+			macro.Label = name
+		}
+		for _, stepCfg := range macroCfg.Steps {
+			step := &MacroStep{
+				Stages: stepCfg.Sinks,
+				Scene:  stepCfg.Scene,
+				Wait:   time.Duration(stepCfg.WaitMs) * time.Millisecond,
+			}
+			if len(step.Stages) == 0 {
+				step.Stages = macroCfg.Sinks
+			}
+			if stepCfg.TransitionMs != nil {
+				step.Transition = time.Duration(*stepCfg.TransitionMs) * time.Millisecond
+			}
+			macro.Steps = append(macro.Steps, step)
+		}
+		macros[name] = macro
+	}
+	return macros
+}
+
+// MacroStatuses returns the state of every macro, sorted by name
+func (t *Theatre) MacroStatuses() []MacroStatus {
+	t.macroMutex.Lock()
+	defer t.macroMutex.Unlock()
+	statuses := make([]MacroStatus, 0, len(t.Macros))
+	for _, name := range slices.Sorted(maps.Keys(t.Macros)) {
+		statuses = append(statuses, t.macroStatus(name))
+	}
+	return statuses
+}
+
+// macroStatus needs the macro mutex to be held
+func (t *Theatre) macroStatus(name string) MacroStatus {
+	macro := t.Macros[name]
+	status := MacroStatus{
+		Name:  name,
+		Label: macro.Label,
+		State: MacroIdle,
+		Steps: len(macro.Steps),
+	}
+	if run, ok := t.macroRuns[name]; ok {
+		status.State = MacroRunning
+		if run.paused {
+			status.State = MacroPaused
+		}
+		status.Step = run.step
+	}
+	return status
+}
+
+func (t *Theatre) invokeMacro(name string, state string, err error) {
+	t.macroMutex.Lock()
+	status := t.macroStatus(name)
+	t.macroMutex.Unlock()
+	event := EventDataMacro{MacroStatus: status}
+	if state != "" {
+		event.State = state
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	t.invoke("macro", event)
+}
+
+// StartMacro runs a macro in the background. Its scene changes are made on
+// behalf of caller, so they respect stage locks like caller's own would.
+func (t *Theatre) StartMacro(caller Caller, name string) error {
+	macro, ok := t.Macros[name]
+	if !ok {
+		return fmt.Errorf("no such macro: %s", name)
+	}
+	t.macroMutex.Lock()
+	if _, running := t.macroRuns[name]; running {
+		t.macroMutex.Unlock()
+		return fmt.Errorf("macro %s is already running", name)
+	}
+	run := &macroRun{caller: caller, ctl: make(chan string, 4)}
+	t.macroRuns[name] = run
+	t.macroMutex.Unlock()
+
+	t.invokeMacro(name, "", nil)
+	go t.runMacro(macro, run)
+	return nil
+}
+
+func (t *Theatre) controlMacro(name string, command string) error {
+	if _, ok := t.Macros[name]; !ok {
+		return fmt.Errorf("no such macro: %s", name)
+	}
+	t.macroMutex.Lock()
+	defer t.macroMutex.Unlock()
+	run, ok := t.macroRuns[name]
+	if !ok {
+		return fmt.Errorf("macro %s is not running", name)
+	}
+	select {
+	case run.ctl <- command:
+		return nil
+	default:
+		return fmt.Errorf("macro %s is busy", name)
+	}
+}
+
+// StopMacro stops a macro after the step it is on
+func (t *Theatre) StopMacro(name string) error {
+	return t.controlMacro(name, MacroStopped)
+}
+
+// PauseMacro holds a macro before its next step. A wait that is paused
+// halfway continues with what was left of it when resumed.
+func (t *Theatre) PauseMacro(name string) error {
+	return t.controlMacro(name, MacroPaused)
+}
+
+func (t *Theatre) ResumeMacro(name string) error {
+	return t.controlMacro(name, MacroRunning)
+}
+
+func (t *Theatre) runMacro(macro *Macro, run *macroRun) {
+	logger := slog.Default().With(slog.String("module", "macro"))
+	logger.Info(fmt.Sprintf("starting macro %s", macro.Name))
+	state, err := t.runMacroSteps(macro, run)
+	if err != nil {
+		logger.Error(fmt.Sprintf("macro %s failed: %s", macro.Name, err))
+	} else {
+		logger.Info(fmt.Sprintf("macro %s %s", macro.Name, state))
+	}
+
+	t.macroMutex.Lock()
+	delete(t.macroRuns, macro.Name)
+	t.macroMutex.Unlock()
+	t.invokeMacro(macro.Name, state, err)
+}
+
+func (t *Theatre) runMacroSteps(macro *Macro, run *macroRun) (string, error) {
+	for i, step := range macro.Steps {
+		t.macroMutex.Lock()
+		run.step = i
+		t.macroMutex.Unlock()
+		t.invokeMacro(macro.Name, "", nil)
+
+		if !t.macroWait(macro.Name, run, step.Wait) {
+			return MacroStopped, nil
+		}
+		if step.Wait > 0 {
+			continue
+		}
+		for _, stage := range step.Stages {
+			if step.Transition > 0 {
+				err := t.SetTransitionSpeed(stage, step.Transition)
+				if err != nil {
+					return MacroFailed, fmt.Errorf("step %d: %w", i, err)
+				}
+			}
+			if step.Scene != "" {
+				err := t.SetSceneAs(run.caller, stage, step.Scene, true)
+				if err != nil {
+					return MacroFailed, fmt.Errorf("step %d: %w", i, err)
+				}
+			}
+		}
+	}
+	return MacroFinished, nil
+}
+
+// macroWait sleeps for d while handling pause, resume and stop commands.
+// With d zero, it only holds while the macro is paused. It returns false
+// if the macro got stopped.
+func (t *Theatre) macroWait(name string, run *macroRun, d time.Duration) bool {
+	setPaused := func(paused bool) {
+		t.macroMutex.Lock()
+		changed := run.paused != paused
+		run.paused = paused
+		t.macroMutex.Unlock()
+		if changed {
+			t.invokeMacro(name, "", nil)
+		}
+	}
+
+	for {
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		start := time.Now()
+		if !run.paused {
+			if d <= 0 {
+				select {
+				case command := <-run.ctl:
+					if command == MacroStopped {
+						return false
+					}
+					setPaused(command == MacroPaused)
+					continue
+				default:
+					return true
+				}
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case <-timeout:
+			return true
+		case command := <-run.ctl:
+			if timer != nil {
+				timer.Stop()
+				d -= time.Since(start)
+			}
+			if command == MacroStopped {
+				return false
+			}
+			setPaused(command == MacroPaused)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package theatre_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+func newMacroTheatre(t *testing.T) *theatre.Theatre {
+	fastMs := 20
+	return theatretest.New(t, func(cfg *config.Config) {
+		cfg.Macros = map[string]*config.MacroCfg{
+			"intro": {
+				Sinks: []string{"projector"},
+				Steps: []*config.MacroStepCfg{
+					{Scene: "full-cam", TransitionMs: &fastMs},
+					{WaitMs: 200},
+					{Scene: "full-slides"},
+				},
+			},
+		}
+	})
+}
+
+func waitForMacro(t *testing.T, th *theatre.Theatre, state string, step int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := th.MacroStatuses()[0]
+		if status.State == state && status.Step == step {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("macro did not reach %s at step %d, got %+v", state, step, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMacro(t *testing.T) {
+	th := newMacroTheatre(t)
+	stage := th.Stages["projector"]
+	ended := make(chan theatre.EventDataMacro, 16)
+	th.AddEventListener("macro", func(_ *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataMacro)
+		if event.State == theatre.MacroFinished || event.State == theatre.MacroStopped || event.State == theatre.MacroFailed {
+			ended <- event
+		}
+	})
+
+	err := th.StartMacro(theatre.Caller{}, "intro")
+	if err != nil {
+		t.Fatalf("could not start macro: %s", err)
+	}
+	if th.StartMacro(theatre.Caller{}, "intro") == nil {
+		t.Errorf("a running macro should not start twice")
+	}
+	waitForMacro(t, th, theatre.MacroRunning, 1)
+	if stage.ActiveScene != "full-cam" || stage.TransitionTime != 20*time.Millisecond {
+		t.Errorf("first step was not run, got %s with %s", stage.ActiveScene, stage.TransitionTime)
+	}
+
+	err = th.PauseMacro("intro")
+	if err != nil {
+		t.Fatalf("could not pause macro: %s", err)
+	}
+	waitForMacro(t, th, theatre.MacroPaused, 1)
+	time.Sleep(300 * time.Millisecond)
+	if stage.ActiveScene != "full-cam" {
+		t.Errorf("paused macro carried on")
+	}
+	err = th.ResumeMacro("intro")
+	if err != nil {
+		t.Fatalf("could not resume macro: %s", err)
+	}
+	select {
+	case event := <-ended:
+		if event.State != theatre.MacroFinished {
+			t.Errorf("expected the macro to finish, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("macro did not finish")
+	}
+	waitForMacro(t, th, theatre.MacroIdle, 0)
+	if stage.ActiveScene != "full-slides" {
+		t.Errorf("last step was not run, got %s", stage.ActiveScene)
+	}
+
+	err = th.StartMacro(theatre.Caller{}, "intro")
+	if err != nil {
+		t.Fatalf("could not restart macro: %s", err)
+	}
+	waitForMacro(t, th, theatre.MacroRunning, 1)
+	err = th.StopMacro("intro")
+	if err != nil {
+		t.Fatalf("could not stop macro: %s", err)
+	}
+	if event := <-ended; event.State != theatre.MacroStopped {
+		t.Errorf("expected the macro to stop, got %+v", event)
+	}
+	if stage.ActiveScene != "full-cam" {
+		t.Errorf("stopped macro carried on to %s", stage.ActiveScene)
+	}
+	if th.StopMacro("intro") == nil {
+		t.Errorf("stopping an idle macro should fail")
+	}
+}
+
+func TestMacroRespectsLock(t *testing.T) {
+	th := newMacroTheatre(t)
+	ended := make(chan theatre.EventDataMacro, 16)
+	th.AddEventListener("macro", func(_ *theatre.Theatre, data interface{}) {
+		if event := data.(theatre.EventDataMacro); event.State == theatre.MacroFailed {
+			ended <- event
+		}
+	})
+	err := th.Lock(theatre.Caller{Holder: "director"}, "projector")
+	if err != nil {
+		t.Fatalf("could not lock: %s", err)
+	}
+	err = th.StartMacro(theatre.Caller{Holder: "desk"}, "intro")
+	if err != nil {
+		t.Fatalf("could not start macro: %s", err)
+	}
+	select {
+	case event := <-ended:
+		if event.Error == "" {
+			t.Errorf("expected an error in the failed event")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("macro did not fail on a locked stage")
+	}
+	if th.Stages["projector"].ActiveScene != "full-slides" {
+		t.Errorf("macro switched a locked stage")
+	}
+}
@@ -0,0 +1,46 @@
+package theatre
+
+import "time"
+
+// ScheduledEvent is a talk, or any other event, from a schedule
+type ScheduledEvent struct {
+	ID      string
+	Title   string
+	Room    string
+	Persons []string
+	Start   time.Time
+	End     time.Time
+}
+
+// The phases a scheduled room goes through
+const (
+	PhaseHolding = "holding"
+	PhaseTalk    = "talk"
+	PhaseEnding  = "ending"
+)
+
+// ScheduleStatus is where the scheduler is in the schedule. Current is the
+// running event, or the one that just ended during the ending phase.
+type ScheduleStatus struct {
+	Phase   string
+	Current *ScheduledEvent
+	Next    *ScheduledEvent
+}
+
+// SetSchedule is called by the scheduler whenever its status changes
+func (t *Theatre) SetSchedule(status ScheduleStatus) {
+	t.scheduleMutex.Lock()
+	t.schedule = &status
+	t.scheduleMutex.Unlock()
+	t.invoke("schedule", EventDataSchedule{
+		ScheduleStatus: status,
+	})
+}
+
+// Schedule returns the last status set by the scheduler, or nil if there is
+// no scheduler
+func (t *Theatre) Schedule() *ScheduleStatus {
+	t.scheduleMutex.Lock()
+	defer t.scheduleMutex.Unlock()
+	return t.schedule
+}
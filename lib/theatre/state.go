@@ -0,0 +1,138 @@
+package theatre
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+type SourceHealth struct {
+	Name       string
+	Ready      bool
+	FrameAgeMs int64
+}
+
+// Cue selects the scene that the next Take on the given stage will
+// transition to, without changing what is currently shown
+func (t *Theatre) Cue(stageName string, sceneName string) error {
+	stage, ok := t.Stages[stageName]
+	if !ok {
+		return fmt.Errorf("no such stage: %s", stageName)
+	}
+	if _, ok := t.Scenes[sceneName]; !ok {
+		return fmt.Errorf("no such scene: %s", sceneName)
+	}
+	t.stageMutex.Lock()
+	stage.CuedScene = sceneName
+	t.stageMutex.Unlock()
+	t.invoke("cue", EventDataCue{
+		Stage: stageName,
+		Scene: sceneName,
+	})
+	return nil
+}
+
+// Take switches the stage to its cued scene
+func (t *Theatre) Take(stageName string, transition bool) error {
+	return t.TakeAs(Caller{}, stageName, transition)
+}
+
+func (t *Theatre) TakeAs(caller Caller, stageName string, transition bool) error {
+	stage, ok := t.Stages[stageName]
+	if !ok {
+		return fmt.Errorf("no such stage: %s", stageName)
+	}
+	if stage.CuedScene == "" {
+		return fmt.Errorf("no scene cued on stage %s", stageName)
+	}
+	return t.SetSceneAs(caller, stageName, stage.CuedScene, transition)
+}
+
+// Tally returns, for every source, the sorted names of the stages whose
+// active scene shows that source
+func (t *Theatre) Tally() map[string][]string {
+	t.stageMutex.Lock()
+	defer t.stageMutex.Unlock()
+	tally := make(map[string][]string)
+	for _, src := range t.SourceList {
+		tally[src.Frames().Name] = []string{}
+	}
+	for _, stageName := range slices.Sorted(maps.Keys(t.Stages)) {
+		scene, ok := t.Scenes[t.Stages[stageName].ActiveScene]
+		if !ok {
+			continue
+		}
+		for srcIdx, states := range scene.LayerStatesBySourceIdx {
+			for _, state := range states {
+				if state.Opacity > 0 {
+					name := t.SourceList[srcIdx].Frames().Name
+					tally[name] = append(tally[name], stageName)
+					break
+				}
+			}
+		}
+	}
+	return tally
+}
+
+func (t *Theatre) SourceHealth() []SourceHealth {
+	health := make([]SourceHealth, len(t.SourceList))
+	for i, src := range t.SourceList {
+		frames := src.Frames()
+		health[i] = SourceHealth{
+			Name:       frames.Name,
+			Ready:      frames.IsReady,
+			FrameAgeMs: frames.FrameAge.Milliseconds(),
+		}
+	}
+	return health
+}
+
+// CheckSourceHealth emits a source-health event for every source that
+// became ready or stopped being ready since the last call
+func (t *Theatre) CheckSourceHealth() {
+	for i, src := range t.SourceList {
+		ready := src.Frames().IsReady
+		if ready != t.sourceReady[i] {
+			t.sourceReady[i] = ready
+			t.invoke("source-health", EventDataSourceHealth{
+				Source: src.Frames().Name,
+				Ready:  ready,
+			})
+		}
+	}
+}
+
+// restarter is implemented by sources and sinks that wrap an external
+// process which gets restarted when it dies
+type restarter interface {
+	Restarts() uint64
+}
+
+// CheckCrashes emits a crash event for every source or sink whose process
+// got restarted since the last call
+func (t *Theatre) CheckCrashes() {
+	for _, src := range t.SourceList {
+		if r, ok := src.(restarter); ok {
+			t.checkCrash("source", src.Frames().Name, r.Restarts())
+		}
+	}
+	for name, stage := range t.Stages {
+		if r, ok := stage.Sink.(restarter); ok {
+			t.checkCrash("sink", name, r.Restarts())
+		}
+	}
+}
+
+func (t *Theatre) checkCrash(kind string, name string, restarts uint64) {
+	key := kind + "/" + name
+	if restarts == t.restarts[key] {
+		return
+	}
+	t.restarts[key] = restarts
+	t.invoke("crash", EventDataCrash{
+		Kind:     kind,
+		Name:     name,
+		Restarts: restarts,
+	})
+}
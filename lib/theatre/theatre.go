@@ -2,8 +2,11 @@ package theatre
 
 import (
 	"fmt"
+	"image"
+	"sync"
 	"time"
 
+	"github.com/fosdem/fazantix/lib/audit"
 	"github.com/fosdem/fazantix/lib/config"
 	"github.com/fosdem/fazantix/lib/encdec"
 	"github.com/fosdem/fazantix/lib/layer"
@@ -25,6 +28,8 @@ type Theatre struct {
 	SourceIdxByName map[string]uint32
 	Scenes          map[string]*Scene
 	Stages          map[string]*layer.Stage
+	Macros          map[string]*Macro
+	AutomationRules []*AutomationRule
 
 	FallbackSourceIndices []int32
 	FallbackColour        utils.Colour
@@ -38,8 +43,27 @@ type Theatre struct {
 
 	ShutdownRequested bool
 
+	// Audit records every state-changing action from the control surfaces
+	Audit *audit.Log
+
 	listener map[string][]EventListener
 
+	// stageMutex guards the scene, transition and layer state of the
+	// stages, which the render thread animates while the control surfaces
+	// change and read it
+	stageMutex sync.Mutex
+	// sceneChanges numbers the set-scene events, under stageMutex
+	sceneChanges uint64
+
+	sourceReady []bool
+	restarts    map[string]uint64
+
+	schedule      *ScheduleStatus
+	scheduleMutex sync.Mutex
+
+	macroRuns  map[string]*macroRun
+	macroMutex sync.Mutex
+
 	FrameRate    float64
 	VSyncEnabled bool
 	framePacer   *utils.Pacer
@@ -55,6 +79,14 @@ func New(cfg *config.Config, alloc encdec.FrameAllocator) (*Theatre, error) {
 	fallbackSourceIndices := buildFallbackSources(cfg, sourceMap)
 	sceneMap := buildSceneMap(cfg, sourceList, sourceMap)
 	stageMap, layersPerStage := buildStageMap(cfg, sourceList, sceneMap, alloc)
+	automation, err := buildAutomation(cfg, sourceMap)
+	if err != nil {
+		return nil, err
+	}
+	auditLog, err := audit.Open(cfg.Audit)
+	if err != nil {
+		return nil, err
+	}
 	var windowStageList []*layer.Stage
 	var windowSinkList []*windowsink.WindowSink
 	var nonWindowStageList []*layer.Stage
@@ -75,6 +107,9 @@ func New(cfg *config.Config, alloc encdec.FrameAllocator) (*Theatre, error) {
 		SourceIdxByName:       sourceMap,
 		Scenes:                sceneMap,
 		Stages:                stageMap,
+		Macros:                buildMacros(cfg),
+		AutomationRules:       automation,
+		macroRuns:             make(map[string]*macroRun),
 		FallbackSourceIndices: fallbackSourceIndices,
 		FallbackColour:        utils.ColourParse(cfg.FallbackColour),
 		WindowStageList:       windowStageList,
@@ -84,6 +119,9 @@ func New(cfg *config.Config, alloc encdec.FrameAllocator) (*Theatre, error) {
 		LayersPerStage:        layersPerStage,
 		FrameRate:             cfg.BaseFramerate,
 		VSyncEnabled:          cfg.BaseFramerate <= 0,
+		sourceReady:           make([]bool, len(sourceList)),
+		restarts:              make(map[string]uint64),
+		Audit:                 auditLog,
 	}
 
 	return t, nil
@@ -316,7 +354,7 @@ func (t *Theatre) NumSources() int {
 }
 
 func (t *Theatre) Start() {
-	err := t.ResetToDefaultScenes()
+	err := t.resetScenes(true)
 	if err != nil {
 		return
 	}
@@ -352,6 +390,8 @@ func (t *Theatre) SleepUntilNextFrame() {
 }
 
 func (t *Theatre) Animate(delta float32) {
+	t.stageMutex.Lock()
+	defer t.stageMutex.Unlock()
 	for _, s := range t.Stages {
 		for _, l := range s.Layers {
 			l.Animate(delta, s.Speed)
@@ -359,9 +399,23 @@ func (t *Theatre) Animate(delta float32) {
 	}
 }
 
+// ReadStages runs read while the stages cannot change, for anything
+// outside the render thread that looks at more than the scene names
+func (t *Theatre) ReadStages(read func()) {
+	t.stageMutex.Lock()
+	defer t.stageMutex.Unlock()
+	read()
+}
+
 func (t *Theatre) SetTransitionSpeed(stageName string, transitionDuration time.Duration) error {
 	if stage, ok := t.Stages[stageName]; ok {
+		t.stageMutex.Lock()
 		stage.SetSpeed(transitionDuration)
+		t.stageMutex.Unlock()
+		t.invoke("set-transition", EventDataSetTransition{
+			Stage:        stageName,
+			TransitionMs: transitionDuration.Milliseconds(),
+		})
 		return nil
 	} else {
 		return fmt.Errorf("no such stage: %s", stageName)
@@ -369,15 +423,30 @@ func (t *Theatre) SetTransitionSpeed(stageName string, transitionDuration time.D
 }
 
 func (t *Theatre) SetScene(stageName string, sceneName string, transition bool) error {
+	return t.SetSceneAs(Caller{}, stageName, sceneName, transition)
+}
+
+// SetSceneAs changes the scene on behalf of caller, which is refused if
+// someone else holds the lock on the stage
+func (t *Theatre) SetSceneAs(caller Caller, stageName string, sceneName string, transition bool) error {
 	idxBySrc := make([]int, len(t.SourceList))
 
 	if stage, ok := t.Stages[stageName]; ok {
 		if scene, ok := t.Scenes[sceneName]; ok {
-			t.invoke("set-scene", EventDataSetScene{
-				Stage: stageName,
-				Scene: sceneName,
-			})
-
+			t.stageMutex.Lock()
+			err := caller.mayChange(stageName, stage)
+			if err != nil {
+				t.stageMutex.Unlock()
+				return err
+			}
+			stage.ActiveScene = sceneName
+			t.sceneChanges++
+			seq := t.sceneChanges
+			if transition {
+				stage.TransitionStart = time.Now()
+			} else {
+				stage.TransitionStart = time.Time{}
+			}
 			stage.Layers = stage.LayersByScene[sceneName]
 			for i, layer := range stage.Layers {
 				j := idxBySrc[layer.SourceIdx]
@@ -391,8 +460,20 @@ func (t *Theatre) SetScene(stageName string, sceneName string, transition bool)
 				}
 				stage.SourceIndices[i] = int32(layer.SourceIdx)
 			}
+			t.stageMutex.Unlock()
+
+			// listeners run concurrently and may look at the stage, so
+			// only tell them once it has changed
+			t.invoke("set-scene", EventDataSetScene{
+				Stage: stageName,
+				Scene: sceneName,
+				Seq:   seq,
+			})
+			t.invoke("tally", EventDataTally{
+				Sources: t.Tally(),
+			})
 		} else {
-			return fmt.Errorf("no such stage: %s", stageName)
+			return fmt.Errorf("no such scene: %s", sceneName)
 		}
 	} else {
 		return fmt.Errorf("no such stage: %s", stageName)
@@ -402,8 +483,18 @@ func (t *Theatre) SetScene(stageName string, sceneName string, transition bool)
 }
 
 func (t *Theatre) ResetToDefaultScenes() error {
+	return t.resetScenes(false)
+}
+
+// resetScenes puts stages on their default scene. With unsetOnly, stages
+// that already have an active scene, as restored from a state file, are
+// left alone.
+func (t *Theatre) resetScenes(unsetOnly bool) error {
 	for name, stage := range t.Stages {
-		err := t.SetScene(name, stage.DefaultScene, false)
+		if unsetOnly && stage.ActiveScene != "" {
+			continue
+		}
+		err := t.SetSceneAs(Caller{Force: true}, name, stage.DefaultScene, false)
 		if err != nil {
 			return fmt.Errorf(
 				"could not apply default scene (%s) to stage %s: %w",
@@ -416,9 +507,7 @@ func (t *Theatre) ResetToDefaultScenes() error {
 
 func (t *Theatre) ShaderData() *shaders.ShaderData {
 	return &shaders.ShaderData{
-		NumSources:     uint32(t.NumSources()),
 		Sources:        t.SourceList,
-		NumLayers:      t.LayersPerStage,
 		FallbackColour: t.FallbackColour,
 	}
 }
@@ -430,3 +519,20 @@ func (t *Theatre) SourceByName(name string) layer.Source {
 	}
 	return t.SourceList[idx]
 }
+
+// SetImage replaces the image shown by an image source
+func (t *Theatre) SetImage(sourceName string, img image.Image) error {
+	imgSource, ok := t.SourceByName(sourceName).(*imgsource.ImgSource)
+	if !ok {
+		return fmt.Errorf("no such image source: %s", sourceName)
+	}
+	err := imgSource.SetImage(img)
+	if err != nil {
+		return err
+	}
+	t.invoke("set-image", EventDataSetImage{
+		Source: sourceName,
+		Image:  img,
+	})
+	return nil
+}
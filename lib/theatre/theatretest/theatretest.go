@@ -0,0 +1,76 @@
+// Package theatretest builds small theatres for the tests of the packages
+// that drive one
+package theatretest
+
+import (
+	"testing"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/encdec"
+	"github.com/fosdem/fazantix/lib/layer"
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+// FullScreen is a scene that shows source over the whole stage
+func FullScreen(source string) *config.SceneCfg {
+	return &config.SceneCfg{Layers: []*config.LayerCfg{{
+		SourceName: source,
+		Transform: &config.LayerTransformCfg{
+			LayerTransform: layer.LayerTransform{Scale: 1, Opacity: 1},
+		},
+	}}}
+}
+
+// Config has a projector stage and two image sources, slides and camera,
+// each shown by a full-slides and a full-cam scene. The projector starts
+// on full-slides with a 100ms transition.
+func Config() *config.Config {
+	transitionMs := 100
+	return &config.Config{
+		Sources: map[string]*config.SourceCfg{
+			"slides": {Cfg: &config.ImgSourceCfg{Width: 16, Height: 9}},
+			"camera": {Cfg: &config.ImgSourceCfg{Width: 16, Height: 9}},
+		},
+		Scenes: map[string]*config.SceneCfg{
+			"full-slides": FullScreen("slides"),
+			"full-cam":    FullScreen("camera"),
+		},
+		Stages: map[string]*config.StageCfg{
+			"projector": {
+				StageCfgStub: config.StageCfgStub{
+					DefaultScene:     "full-slides",
+					TransitionTimeMs: &transitionMs,
+					FrameCfg:         encdec.FrameCfg{Width: 16, Height: 9},
+				},
+				SinkCfg: &config.WindowSinkCfg{},
+			},
+		},
+	}
+}
+
+// Build makes a theatre from cfg without putting the stages on a scene,
+// for tests that have to do something before that
+func Build(t testing.TB, cfg *config.Config) *theatre.Theatre {
+	t.Helper()
+	th, err := theatre.New(cfg, &encdec.DumbFrameAllocator{})
+	if err != nil {
+		t.Fatalf("could not build theatre: %s", err)
+	}
+	return th
+}
+
+// New builds a theatre from Config, which extend can change first, and
+// puts its stages on their default scenes. extend may be nil.
+func New(t testing.TB, extend func(cfg *config.Config)) *theatre.Theatre {
+	t.Helper()
+	cfg := Config()
+	if extend != nil {
+		extend(cfg)
+	}
+	th := Build(t, cfg)
+	err := th.ResetToDefaultScenes()
+	if err != nil {
+		t.Fatalf("could not reset scenes: %s", err)
+	}
+	return th
+}
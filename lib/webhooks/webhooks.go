@@ -0,0 +1,274 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/metrics"
+	"github.com/fosdem/fazantix/lib/theatre"
+)
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the request body,
+// prefixed with sha256=, if the webhook has a secret
+const SignatureHeader = "X-Fazantix-Signature"
+
+// EventHeader carries the name of the event, so receivers can route on it
+// without parsing the body
+const EventHeader = "X-Fazantix-Event"
+
+// Payload is the JSON body posted to the webhooks. Only the fields that
+// apply to the event are set.
+type Payload struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Stage    string    `json:"stage,omitempty"`
+	Scene    string    `json:"scene,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	Kind     string    `json:"kind,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Restarts uint64    `json:"restarts,omitempty"`
+}
+
+type delivery struct {
+	event string
+	body  []byte
+}
+
+type hook struct {
+	name    string
+	cfg     *config.WebhookCfg
+	events  []string
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	queue   chan delivery
+	pending sync.WaitGroup
+	logger  *slog.Logger
+
+	deliveries prometheus.Counter
+	retried    prometheus.Counter
+	failures   prometheus.Counter
+	dropped    prometheus.Counter
+}
+
+// Dispatcher posts theatre events to the configured webhooks. Every
+// webhook has its own bounded queue and worker, so a slow or dead receiver
+// does not hold up the others.
+type Dispatcher struct {
+	hooks  []*hook
+	logger *slog.Logger
+}
+
+func New(cfgs map[string]*config.WebhookCfg) *Dispatcher {
+	d := &Dispatcher{}
+	d.logger = slog.Default().With(slog.String("module", "webhooks"))
+	for _, name := range slices.Sorted(maps.Keys(cfgs)) {
+		d.hooks = append(d.hooks, newHook(name, cfgs[name], d.logger))
+	}
+	return d
+}
+
+func newHook(name string, cfg *config.WebhookCfg, logger *slog.Logger) *hook {
+	h := &hook{
+		name:    name,
+		cfg:     cfg,
+		events:  cfg.Events,
+		retries: 3,
+		backoff: time.Second,
+		logger:  logger.With(slog.String("webhook", name)),
+
+		deliveries: metrics.WebhookDeliveries.WithLabelValues(name),
+		retried:    metrics.WebhookRetries.WithLabelValues(name),
+		failures:   metrics.WebhookFailures.WithLabelValues(name),
+		dropped:    metrics.WebhookDropped.WithLabelValues(name),
+	}
+	if len(h.events) == 0 {
+		h.events = config.WebhookEvents
+	}
+	if cfg.Retries != nil {
+		h.retries = *cfg.Retries
+	}
+	queueSize := cfg.QueueSize
+	if queueSize == 0 {
+		queueSize = 64
+	}
+	h.queue = make(chan delivery, queueSize)
+	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	h.client = &http.Client{Timeout: timeout}
+
+	h.deliveries.Add(0)
+	h.retried.Add(0)
+	h.failures.Add(0)
+	h.dropped.Add(0)
+	return h
+}
+
+// Start launches the delivery workers
+func (d *Dispatcher) Start() {
+	for _, h := range d.hooks {
+		go h.run()
+	}
+}
+
+// Listen subscribes to the theatre events that can be sent as webhooks
+func (d *Dispatcher) Listen(t *theatre.Theatre) {
+	t.AddEventListener("set-scene", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSetScene)
+		d.Post(&Payload{Event: "scene", Stage: event.Stage, Scene: event.Scene})
+	})
+	t.AddEventListener("source-health", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataSourceHealth)
+		name := "source-lost"
+		if event.Ready {
+			name = "source-recovered"
+		}
+		d.Post(&Payload{Event: name, Source: event.Source})
+	})
+	t.AddEventListener("crash", func(t *theatre.Theatre, data interface{}) {
+		event := data.(theatre.EventDataCrash)
+		d.Post(&Payload{Event: "crash", Kind: event.Kind, Name: event.Name, Restarts: event.Restarts})
+	})
+}
+
+// Post queues the payload on every webhook that wants its event. If a
+// queue is full the payload is dropped for that webhook.
+func (d *Dispatcher) Post(p *Payload) {
+	if p.Time.IsZero() {
+		p.Time = time.Now()
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		d.logger.Error(fmt.Sprintf("could not encode %s payload: %s", p.Event, err))
+		return
+	}
+	for _, h := range d.hooks {
+		if !slices.Contains(h.events, p.Event) {
+			continue
+		}
+		h.pending.Add(1)
+		select {
+		case h.queue <- delivery{event: p.Event, body: body}:
+		default:
+			h.pending.Done()
+			h.dropped.Inc()
+			h.logger.Warn(fmt.Sprintf("queue full, dropping %s event", p.Event))
+		}
+	}
+}
+
+// Shutdown posts the shutdown event and waits until every queue has been
+// drained, or the timeout has passed
+func (d *Dispatcher) Shutdown(timeout time.Duration) {
+	if d == nil {
+		return
+	}
+	d.Post(&Payload{Event: "shutdown"})
+
+	drained := make(chan struct{})
+	go func() {
+		for _, h := range d.hooks {
+			h.pending.Wait()
+		}
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		d.logger.Warn("gave up waiting for webhooks to be delivered")
+	}
+}
+
+func (h *hook) run() {
+	for delivery := range h.queue {
+		h.deliver(delivery)
+		h.pending.Done()
+	}
+}
+
+func (h *hook) deliver(d delivery) {
+	var err error
+	for attempt := 0; attempt <= h.retries; attempt++ {
+		if attempt > 0 {
+			h.retried.Inc()
+			time.Sleep(h.backoff << (attempt - 1))
+		}
+		var retry bool
+		retry, err = h.post(d)
+		if err == nil {
+			h.deliveries.Inc()
+			return
+		}
+		if !retry {
+			break
+		}
+	}
+	h.failures.Inc()
+	h.logger.Warn(fmt.Sprintf("could not deliver %s event: %s", d.event, err))
+}
+
+// post sends a single request. It tells whether a failure is worth
+// retrying: network errors, timeouts and server errors are, other client
+// errors are not.
+func (h *hook) post(d delivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.cfg.Url, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fazantix")
+	req.Header.Set(EventHeader, d.event)
+	if h.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(h.cfg.Secret, d.body))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("server responded with %s", resp.Status)
+	default:
+		return false, fmt.Errorf("server responded with %s", resp.Status)
+	}
+}
+
+// Sign returns the value of the signature header for the body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func StartInBackground(t *theatre.Theatre, cfgs map[string]*config.WebhookCfg) *Dispatcher {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	d := New(cfgs)
+	d.Listen(t)
+	d.Start()
+	d.logger.Info(fmt.Sprintf("posting events to %d webhooks", len(d.hooks)))
+	return d
+}
@@ -0,0 +1,182 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/fosdem/fazantix/lib/config"
+	"github.com/fosdem/fazantix/lib/theatre/theatretest"
+)
+
+type receiver struct {
+	t        *testing.T
+	secret   string
+	statuses []int
+	received chan *Payload
+
+	mutex    sync.Mutex
+	requests int
+}
+
+func newReceiver(t *testing.T, secret string, statuses ...int) (*receiver, string) {
+	r := &receiver{t: t, secret: secret, statuses: statuses, received: make(chan *Payload, 16)}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server.URL
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Errorf("could not read body: %s", err)
+		return
+	}
+	if r.secret != "" && req.Header.Get(SignatureHeader) != Sign(r.secret, body) {
+		r.t.Errorf("bad signature %q", req.Header.Get(SignatureHeader))
+	}
+
+	r.mutex.Lock()
+	status := http.StatusOK
+	if r.requests < len(r.statuses) {
+		status = r.statuses[r.requests]
+	}
+	r.requests++
+	r.mutex.Unlock()
+
+	if status == http.StatusOK {
+		p := &Payload{}
+		err = json.Unmarshal(body, p)
+		if err != nil {
+			r.t.Errorf("could not decode payload: %s", err)
+		}
+		if req.Header.Get(EventHeader) != p.Event {
+			r.t.Errorf("event header %q does not match payload %q", req.Header.Get(EventHeader), p.Event)
+		}
+		r.received <- p
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) expect(event string) *Payload {
+	select {
+	case p := <-r.received:
+		if p.Event != event {
+			r.t.Fatalf("expected %s event, got %s", event, p.Event)
+		}
+		return p
+	case <-time.After(5 * time.Second):
+		r.t.Fatalf("did not receive %s event", event)
+		return nil
+	}
+}
+
+func (r *receiver) expectNothing() {
+	select {
+	case p := <-r.received:
+		r.t.Fatalf("unexpected %s event", p.Event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func newDispatcher(t *testing.T, cfgs map[string]*config.WebhookCfg) *Dispatcher {
+	for name, cfg := range cfgs {
+		err := cfg.Validate()
+		if err != nil {
+			t.Fatalf("webhook %s is invalid: %s", name, err)
+		}
+	}
+	d := New(cfgs)
+	for _, h := range d.hooks {
+		h.backoff = time.Millisecond
+	}
+	d.Start()
+	return d
+}
+
+func TestDelivery(t *testing.T) {
+	chat, chatUrl := newReceiver(t, "hunter2")
+	incidents, incidentsUrl := newReceiver(t, "")
+	d := newDispatcher(t, map[string]*config.WebhookCfg{
+		"chat":      {Url: chatUrl, Secret: "hunter2"},
+		"incidents": {Url: incidentsUrl, Events: []string{"source-lost", "shutdown"}},
+	})
+
+	th := theatretest.Build(t, theatretest.Config())
+	d.Listen(th)
+	err := th.SetScene("projector", "full-slides", false)
+	if err != nil {
+		t.Fatalf("could not set scene: %s", err)
+	}
+	p := chat.expect("scene")
+	if p.Stage != "projector" || p.Scene != "full-slides" {
+		t.Errorf("unexpected payload %+v", p)
+	}
+	incidents.expectNothing()
+
+	d.Post(&Payload{Event: "source-lost", Source: "camera"})
+	chat.expect("source-lost")
+	p = incidents.expect("source-lost")
+	if p.Source != "camera" {
+		t.Errorf("unexpected payload %+v", p)
+	}
+
+	d.Shutdown(5 * time.Second)
+	chat.expect("shutdown")
+	incidents.expect("shutdown")
+}
+
+func TestRetries(t *testing.T) {
+	flaky, flakyUrl := newReceiver(t, "", http.StatusServiceUnavailable, http.StatusBadGateway)
+	broken, brokenUrl := newReceiver(t, "", http.StatusBadRequest)
+	d := newDispatcher(t, map[string]*config.WebhookCfg{
+		"test-flaky":  {Url: flakyUrl},
+		"test-broken": {Url: brokenUrl},
+	})
+
+	d.Post(&Payload{Event: "crash", Kind: "sink", Name: "stream", Restarts: 1})
+	flaky.expect("crash")
+	d.Shutdown(5 * time.Second)
+	broken.expect("shutdown")
+
+	if n := testutil.ToFloat64(d.hooks[0].retried); n != 0 {
+		t.Errorf("broken webhook should not be retried after a client error, got %v retries", n)
+	}
+	if n := testutil.ToFloat64(d.hooks[0].failures); n != 1 {
+		t.Errorf("expected 1 failure on the broken webhook, got %v", n)
+	}
+	if n := testutil.ToFloat64(d.hooks[1].retried); n != 2 {
+		t.Errorf("expected 2 retries on the flaky webhook, got %v", n)
+	}
+	if n := testutil.ToFloat64(d.hooks[1].deliveries); n != 2 {
+		t.Errorf("expected 2 deliveries on the flaky webhook, got %v", n)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(block) })
+
+	d := newDispatcher(t, map[string]*config.WebhookCfg{
+		"test-stuck": {Url: server.URL, QueueSize: 2},
+	})
+	for range 5 {
+		d.Post(&Payload{Event: "scene"})
+	}
+
+	// one payload is being delivered and two are queued
+	dropped := testutil.ToFloat64(d.hooks[0].dropped)
+	if dropped < 2 || dropped > 3 {
+		t.Errorf("expected 2 or 3 dropped payloads, got %v", dropped)
+	}
+}